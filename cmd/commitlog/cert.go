@@ -0,0 +1,21 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/a-shakra/commit-log/internal/config"
+)
+
+// runCert creates a development CA with server and client certificates where the server and tests look for them
+func runCert(args []string) error {
+	flags := flag.NewFlagSet("cert", flag.ContinueOnError)
+	dir := flags.String("dir", config.Dir(), "directory the certificates are written to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := config.GenerateCerts(*dir); err != nil {
+		return err
+	}
+	fmt.Printf("wrote certificates to %s\n", *dir)
+	return nil
+}
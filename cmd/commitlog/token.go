@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/a-shakra/commit-log/internal/auth"
+	"github.com/a-shakra/commit-log/internal/config"
+	"strings"
+	"time"
+)
+
+// runToken prints a token signed with the secret the server verifies tokens against
+func runToken(args []string) error {
+	flags := flag.NewFlagSet("token", flag.ContinueOnError)
+	secretFile := flags.String("secret-file", config.TokenSecretFile, "file holding the signing secret")
+	subject := flags.String("subject", "", "subject the token authenticates as")
+	ttl := flags.Duration("ttl", time.Hour, "how long the token is valid for")
+	actions := flags.String("actions", "", "comma separated actions the token is limited to, all when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	tokens, err := auth.NewTokens(*secretFile)
+	if err != nil {
+		return err
+	}
+	claims := auth.Claims{
+		Subject:   *subject,
+		ExpiresAt: time.Now().Add(*ttl).Unix(),
+	}
+	if *actions != "" {
+		claims.Actions = strings.Split(*actions, ",")
+	}
+	token, err := tokens.Sign(claims)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
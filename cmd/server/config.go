@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"github.com/a-shakra/commit-log/internal/auth"
+	"github.com/a-shakra/commit-log/internal/config"
+	"github.com/a-shakra/commit-log/internal/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// flags holds the command line of the server
+type flags struct {
+	addr        string
+	httpAddr    string
+	metricsAddr string
+	dataDir     string
+
+	maxEventStreams    int
+	maxEventsPerStream uint64
+
+	certFile string
+	keyFile  string
+	caFile   string
+	crlFile  string
+
+	policyFile      string
+	tokenSecretFile string
+}
+
+func parseFlags(args []string) (*flags, error) {
+	f := &flags{}
+	set := flag.NewFlagSet("server", flag.ContinueOnError)
+	set.StringVar(&f.addr, "addr", "127.0.0.1:8400", "address the grpc server listens on")
+	set.StringVar(&f.httpAddr, "http-addr", "", "address the HTTP/JSON gateway listens on, disabled when empty")
+	set.StringVar(&f.metricsAddr, "metrics-addr", "", "address metrics are served on at /metrics, disabled when empty")
+	set.IntVar(&f.maxEventStreams, "http-max-event-streams", 0,
+		"event streams the gateway serves at once, unlimited when 0")
+	set.Uint64Var(&f.maxEventsPerStream, "http-max-events-per-stream", 0,
+		"records an event stream sends before it ends, unlimited when 0")
+	set.StringVar(&f.dataDir, "data-dir", "data", "directory holding the topics and the committed offsets of consumer groups")
+	set.StringVar(&f.certFile, "tls-cert-file", "", "certificate of the server, calls are served without TLS when empty")
+	set.StringVar(&f.keyFile, "tls-key-file", "", "key of the certificate of the server")
+	set.StringVar(&f.caFile, "tls-ca-file", "", "CA client certificates are verified against, required when set")
+	set.StringVar(&f.crlFile, "tls-crl-file", "", "revocation list of client certificates signed by the CA")
+	set.StringVar(&f.policyFile, "acl-policy-file", "", "policy calls are authorized against, every call is allowed when empty")
+	set.StringVar(&f.tokenSecretFile, "token-secret-file", "", "secret bearer tokens are verified with, "+
+		"clients with a token need no client certificate")
+	if err := set.Parse(args); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// serverConfig returns the config of the server and its grpc options. The
+// TLS files are reloaded when they change, so certificates rotate without a
+// restart. tlsConfig is nil when the server is not configured with TLS
+func (f *flags) serverConfig() (cfg *server.Config, opts []grpc.ServerOption, tlsConfig *tls.Config, err error) {
+	cfg = &server.Config{
+		Health:             server.NewHealth(),
+		HTTPAddr:           f.httpAddr,
+		MetricsAddr:        f.metricsAddr,
+		MaxEventStreams:    f.maxEventStreams,
+		MaxEventsPerStream: f.maxEventsPerStream,
+	}
+	if f.policyFile != "" {
+		if cfg.Authorizer, err = auth.NewAuthorizer(f.policyFile); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if f.tokenSecretFile != "" {
+		if cfg.Tokens, err = auth.NewTokens(f.tokenSecretFile); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if f.certFile != "" || f.keyFile != "" {
+		provider, err := config.NewTLSProvider(config.TLSConfig{
+			CertFile:           f.certFile,
+			KeyFile:            f.keyFile,
+			CAFile:             f.caFile,
+			CRLFile:            f.crlFile,
+			Server:             true,
+			ClientCertOptional: cfg.Tokens != nil,
+		})
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		tlsConfig = provider.ServerTLSConfig()
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	return cfg, opts, tlsConfig, nil
+}
@@ -1,5 +1,122 @@
+// Command server serves the topics of a data directory over grpc and,
+// optionally, the HTTP/JSON gateway and metrics
 package main
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/a-shakra/commit-log/internal/group"
+	"github.com/a-shakra/commit-log/internal/server"
+	"github.com/a-shakra/commit-log/internal/topic"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+// offsetsDir holds the committed offsets of consumer groups next to the
+// topics, topic names may not start with "__"
+const offsetsDir = "__consumer_offsets"
+
 func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "server: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run starts the server before opening the topics, so health checks report
+// NOT_SERVING while their segments are recovered, and drains it on SIGINT or SIGTERM
+func run(args []string) error {
+	f, err := parseFlags(args)
+	if err != nil {
+		return err
+	}
+	config, opts, tlsConfig, err := f.serverConfig()
+	if err != nil {
+		return err
+	}
+	if config.Authorizer != nil {
+		defer config.Authorizer.Close()
+	}
+	gServer, err := server.NewGrpcServer(config, opts...)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if config.MetricsAddr != "" {
+		metricsServer, err := server.NewMetricsServer(config)
+		if err != nil {
+			return err
+		}
+		if err = listenHTTP(ctx, metricsServer); err != nil {
+			return err
+		}
+	}
+	if config.HTTPAddr != "" {
+		httpServer, err := server.NewHTTPServer(config, tlsConfig)
+		if err != nil {
+			return err
+		}
+		if err = listenHTTP(ctx, httpServer); err != nil {
+			return err
+		}
+	}
+	listener, err := net.Listen("tcp", f.addr)
+	if err != nil {
+		return err
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(ctx, gServer, config.Health, listener)
+	}()
+
+	topics, err := topic.NewManager(f.dataDir)
+	if err == nil {
+		defer topics.Close()
+		config.Offsets, err = group.NewOffsetStore(filepath.Join(f.dataDir, offsetsDir))
+	}
+	if err == nil {
+		defer config.Offsets.Close()
+		err = config.OpenTopics(topics)
+	}
+	if err != nil {
+		stop()
+		<-served
+		return err
+	}
+	return <-served
+}
+
+// listenHTTP listens on the address of srv and serves it until ctx is done
+func listenHTTP(ctx context.Context, srv *http.Server) error {
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	go serveHTTP(ctx, srv, listener)
+	return nil
+}
 
+// serveHTTP serves srv on listener until ctx is done, failures are logged as
+// they do not affect the grpc server
+func serveHTTP(ctx context.Context, srv *http.Server, listener net.Listener) {
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	serve := srv.Serve
+	if srv.TLSConfig != nil {
+		serve = func(listener net.Listener) error {
+			return srv.ServeTLS(listener, "", "")
+		}
+	}
+	if err := serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("http server failed", "addr", srv.Addr, "error", err)
+	}
 }
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ActionProduce = "produce"
+	ActionConsume = "consume"
+	ActionAdmin   = "admin"
+	// Wildcard matches any subject, resource or action in a policy rule
+	Wildcard = "*"
+)
+
+type rule struct {
+	subject  string
+	resource string
+	action   string
+}
+
+func (r rule) matches(subject string, resource string, action string) bool {
+	return (r.subject == Wildcard || r.subject == subject) &&
+		(r.resource == Wildcard || r.resource == resource) &&
+		(r.action == Wildcard || r.action == action)
+}
+
+// policyReloadInterval is how often the policy file is checked for changes
+var policyReloadInterval = 5 * time.Second
+
+// Authorizer checks requests against a policy file of rules, one per line in
+// the form "subject,resource,action", where the subject is the common name of
+// a client certificate and the resource is a topic. Lines starting with # are
+// comments. The policy file is checked for changes in the background and
+// reloaded when it changed, requests are checked against the rules loaded last
+type Authorizer struct {
+	// mu serializes reloads, requests only load the rules
+	mu sync.Mutex
+
+	PolicyFile string
+	modTime    time.Time
+	size       int64
+	rules      atomic.Pointer[[]rule]
+
+	stop func()
+	done chan struct{}
+}
+
+// NewAuthorizer loads the policy file, which has to be valid, and reloads it
+// until the Authorizer is closed
+func NewAuthorizer(policyFile string) (*Authorizer, error) {
+	a := &Authorizer{PolicyFile: policyFile, done: make(chan struct{})}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	stop := make(chan struct{})
+	a.stop = sync.OnceFunc(func() { close(stop) })
+	go a.reloadEvery(policyReloadInterval, stop)
+	return a, nil
+}
+
+// Authorize returns ErrPermissionDenied unless a rule of the policy allows
+// the subject to perform the action on the resource
+func (a *Authorizer) Authorize(subject string, resource string, action string) error {
+	for _, r := range *a.rules.Load() {
+		if r.matches(subject, resource, action) {
+			return nil
+		}
+	}
+	return ErrPermissionDenied{Subject: subject, Resource: resource, Action: action}
+}
+
+// Reload reads the policy file again if it changed since it was last read.
+// The previous policy stays in use when the file is missing or invalid
+func (a *Authorizer) Reload() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	fi, err := os.Stat(a.PolicyFile)
+	if err != nil {
+		return err
+	}
+	if a.rules.Load() != nil && fi.ModTime().Equal(a.modTime) && fi.Size() == a.size {
+		return nil
+	}
+	rules, err := readPolicy(a.PolicyFile)
+	if err != nil {
+		return err
+	}
+	a.rules.Store(&rules)
+	a.modTime = fi.ModTime()
+	a.size = fi.Size()
+	return nil
+}
+
+// Close stops reloading the policy file, the rules loaded last stay in use
+func (a *Authorizer) Close() error {
+	a.stop()
+	<-a.done
+	return nil
+}
+
+func (a *Authorizer) reloadEvery(interval time.Duration, stop <-chan struct{}) {
+	defer close(a.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// a policy that fails to load keeps the previous one in place until it is fixed
+			_ = a.Reload()
+		}
+	}
+}
+
+func readPolicy(name string) ([]rule, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rules := []rule{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, ",")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: rule should have a subject, a resource and an action", name, line)
+		}
+		r := rule{
+			subject:  strings.TrimSpace(fields[0]),
+			resource: strings.TrimSpace(fields[1]),
+			action:   strings.TrimSpace(fields[2]),
+		}
+		if r.action != Wildcard && !validAction(r.action) {
+			return nil, fmt.Errorf("%s:%d: unknown action %q", name, line, r.action)
+		}
+		rules = append(rules, r)
+	}
+	return rules, scanner.Err()
+}
+
+func validAction(action string) bool {
+	switch action {
+	case ActionProduce, ActionConsume, ActionAdmin:
+		return true
+	default:
+		return false
+	}
+}
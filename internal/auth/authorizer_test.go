@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type AuthorizerTestSuite struct {
+	suite.Suite
+	policyFile string
+	authorizer *Authorizer
+	writes     int
+}
+
+func TestAuthorizerTestSuite(t *testing.T) {
+	suite.Run(t, &AuthorizerTestSuite{})
+}
+
+func (s *AuthorizerTestSuite) SetupTest() {
+	s.policyFile = filepath.Join(s.T().TempDir(), "policy.csv")
+	s.writePolicy("# subject,resource,action\nroot,*,*\nnobody,orders,consume\n*,public,consume\n")
+	authorizer, err := NewAuthorizer(s.policyFile)
+	s.Require().NoError(err)
+	s.authorizer = authorizer
+}
+
+func (s *AuthorizerTestSuite) TearDownTest() {
+	s.Require().NoError(s.authorizer.Close())
+}
+
+func (s *AuthorizerTestSuite) TestAuthorize() {
+	s.Require().NoError(s.authorizer.Authorize("root", "orders", ActionAdmin))
+	s.Require().NoError(s.authorizer.Authorize("nobody", "orders", ActionConsume))
+	s.Require().NoError(s.authorizer.Authorize("anyone", "public", ActionConsume))
+
+	err := s.authorizer.Authorize("nobody", "orders", ActionProduce)
+	s.Require().Equal(codes.PermissionDenied, status.Code(err))
+	s.Require().Equal(ErrPermissionDenied{Subject: "nobody", Resource: "orders", Action: ActionProduce}, err)
+	err = s.authorizer.Authorize("", "orders", ActionConsume)
+	s.Require().Equal(codes.PermissionDenied, status.Code(err))
+}
+
+func (s *AuthorizerTestSuite) TestReloadOnChange() {
+	s.Require().Error(s.authorizer.Authorize("nobody", "orders", ActionProduce))
+	s.writePolicy("root,*,*\nnobody,orders,produce\n")
+	s.Require().NoError(s.authorizer.Reload())
+	s.Require().NoError(s.authorizer.Authorize("nobody", "orders", ActionProduce))
+	// rules that were removed no longer apply
+	s.Require().Error(s.authorizer.Authorize("nobody", "orders", ActionConsume))
+}
+
+func (s *AuthorizerTestSuite) TestReloadInBackground() {
+	defer func(interval time.Duration) { policyReloadInterval = interval }(policyReloadInterval)
+	policyReloadInterval = 10 * time.Millisecond
+	s.Require().NoError(s.authorizer.Close())
+	authorizer, err := NewAuthorizer(s.policyFile)
+	s.Require().NoError(err)
+	s.authorizer = authorizer
+
+	s.writePolicy("nobody,orders,produce\n")
+	s.Require().Eventually(func() bool {
+		return s.authorizer.Authorize("nobody", "orders", ActionProduce) == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func (s *AuthorizerTestSuite) TestInvalidPolicyKeepsPrevious() {
+	s.writePolicy("root,*\n")
+	s.Require().Error(s.authorizer.Reload())
+	s.Require().NoError(s.authorizer.Authorize("nobody", "orders", ActionConsume))
+
+	_, err := NewAuthorizer(s.policyFile)
+	s.Require().Error(err)
+}
+
+func (s *AuthorizerTestSuite) TestUnknownAction() {
+	s.writePolicy("root,*,delete\n")
+	_, err := NewAuthorizer(s.policyFile)
+	s.Require().ErrorContains(err, "unknown action")
+}
+
+// writePolicy replaces the policy file and moves its modification time
+// forward, so the change is seen even on file systems with coarse timestamps
+func (s *AuthorizerTestSuite) writePolicy(policy string) {
+	err := os.WriteFile(s.policyFile, []byte(policy), 0644)
+	s.Require().NoError(err)
+	s.writes++
+	later := time.Now().Add(time.Duration(s.writes) * time.Minute)
+	err = os.Chtimes(s.policyFile, later, later)
+	s.Require().NoError(err)
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type ErrPermissionDenied struct {
+	Subject  string
+	Resource string
+	Action   string
+}
+
+func (e ErrPermissionDenied) GRPCStatus() *status.Status {
+	return status.New(
+		codes.PermissionDenied,
+		fmt.Sprintf("%q is not permitted to %s on %q", e.Subject, e.Action, e.Resource),
+	)
+}
+
+func (e ErrPermissionDenied) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrInvalidToken struct {
+	Reason string
+}
+
+func (e ErrInvalidToken) GRPCStatus() *status.Status {
+	return status.New(codes.Unauthenticated, fmt.Sprintf("invalid bearer token: %s", e.Reason))
+}
+
+func (e ErrInvalidToken) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
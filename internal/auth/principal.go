@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"context"
+)
+
+type principalKey struct{}
+
+// Principal is the authenticated identity a call is authorized as. Actions is
+// nil unless the identity was proven with a token that limits its actions
+type Principal struct {
+	Subject string
+	Actions []string
+}
+
+// Allows reports whether the principal may perform the action, before the policy is consulted
+func (p Principal) Allows(action string) bool {
+	if p.Actions == nil {
+		return true
+	}
+	for _, a := range p.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// NewContext returns a copy of ctx that carries the principal
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal carried by ctx, if any
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// minSecretBytes is the shortest secret tokens may be signed with
+const minSecretBytes = 32
+
+// tokenHeader is the only JWT header tokens are signed with
+var tokenHeader = []byte(`{"alg":"HS256","typ":"JWT"}`)
+
+// Claims are carried by a bearer token, ExpiresAt is in unix seconds.
+// Actions limits what the subject may do with the token on top of the
+// policy, a token without actions is only limited by the policy
+type Claims struct {
+	Subject   string   `json:"sub"`
+	ExpiresAt int64    `json:"exp"`
+	Actions   []string `json:"actions,omitempty"`
+}
+
+// Tokens signs and verifies JWT-style bearer tokens with HMAC-SHA256
+type Tokens struct {
+	secret []byte
+}
+
+// NewTokens reads the signing secret from secretFile
+func NewTokens(secretFile string) (*Tokens, error) {
+	b, err := os.ReadFile(secretFile)
+	if err != nil {
+		return nil, err
+	}
+	secret := bytes.TrimSpace(b)
+	if len(secret) < minSecretBytes {
+		return nil, fmt.Errorf("token secret should be at least %d bytes long", minSecretBytes)
+	}
+	return &Tokens{secret: secret}, nil
+}
+
+// Sign returns a token carrying the claims
+func (t *Tokens) Sign(claims Claims) (string, error) {
+	if claims.Subject == "" {
+		return "", errors.New("token subject should not be empty")
+	}
+	if claims.ExpiresAt == 0 {
+		return "", errors.New("token should expire")
+	}
+	for _, action := range claims.Actions {
+		if !validAction(action) {
+			return "", fmt.Errorf("unknown action %q", action)
+		}
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := encodeSegment(tokenHeader) + "." + encodeSegment(payload)
+	return unsigned + "." + encodeSegment(t.sign(unsigned)), nil
+}
+
+// Verify checks the signature and expiry of a token and returns its claims
+func (t *Tokens) Verify(token string) (Claims, error) {
+	var claims Claims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, ErrInvalidToken{Reason: "malformed token"}
+	}
+	header, err := decodeSegment(parts[0])
+	if err != nil || !bytes.Equal(header, tokenHeader) {
+		return claims, ErrInvalidToken{Reason: "unsupported token header"}
+	}
+	signature, err := decodeSegment(parts[2])
+	if err != nil || !hmac.Equal(signature, t.sign(parts[0]+"."+parts[1])) {
+		return claims, ErrInvalidToken{Reason: "invalid signature"}
+	}
+	payload, err := decodeSegment(parts[1])
+	if err != nil {
+		return claims, ErrInvalidToken{Reason: "malformed claims"}
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" {
+		return claims, ErrInvalidToken{Reason: "malformed claims"}
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return claims, ErrInvalidToken{Reason: "token expired"}
+	}
+	return claims, nil
+}
+
+func (t *Tokens) sign(unsigned string) []byte {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
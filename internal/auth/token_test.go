@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+type TokensTestSuite struct {
+	suite.Suite
+	dir    string
+	tokens *Tokens
+}
+
+func TestTokensTestSuite(t *testing.T) {
+	suite.Run(t, &TokensTestSuite{})
+}
+
+func (s *TokensTestSuite) SetupTest() {
+	s.dir = s.T().TempDir()
+	s.tokens = s.newTokens(testSecret)
+}
+
+func (s *TokensTestSuite) TestSignAndVerify() {
+	want := Claims{
+		Subject:   "root",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		Actions:   []string{ActionConsume},
+	}
+	token, err := s.tokens.Sign(want)
+	s.Require().NoError(err)
+	got, err := s.tokens.Verify(token)
+	s.Require().NoError(err)
+	s.Require().Equal(want, got)
+}
+
+func (s *TokensTestSuite) TestVerifyRejectsInvalidTokens() {
+	token, err := s.tokens.Sign(Claims{Subject: "nobody", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	s.Require().NoError(err)
+
+	// swapping the claims keeps the signature of the original ones
+	forged, err := s.tokens.Sign(Claims{Subject: "root", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	s.Require().NoError(err)
+	parts := strings.Split(token, ".")
+	parts[1] = strings.Split(forged, ".")[1]
+
+	other := s.newTokens(strings.Repeat("x", minSecretBytes))
+	expired, err := s.tokens.Sign(Claims{Subject: "root", ExpiresAt: time.Now().Add(-time.Second).Unix()})
+	s.Require().NoError(err)
+
+	for _, invalid := range []string{"", "a.b", strings.Join(parts, "."), expired} {
+		_, err = s.tokens.Verify(invalid)
+		s.Require().Equal(codes.Unauthenticated, status.Code(err))
+	}
+	_, err = other.Verify(token)
+	s.Require().ErrorIs(err, ErrInvalidToken{Reason: "invalid signature"})
+}
+
+func (s *TokensTestSuite) TestSignValidatesClaims() {
+	_, err := s.tokens.Sign(Claims{ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	s.Require().Error(err)
+	_, err = s.tokens.Sign(Claims{Subject: "root"})
+	s.Require().Error(err)
+	_, err = s.tokens.Sign(Claims{Subject: "root", ExpiresAt: time.Now().Add(time.Hour).Unix(), Actions: []string{"delete"}})
+	s.Require().Error(err)
+}
+
+func (s *TokensTestSuite) TestShortSecret() {
+	secretFile := filepath.Join(s.dir, "short-secret")
+	err := os.WriteFile(secretFile, []byte("short"), 0600)
+	s.Require().NoError(err)
+	_, err = NewTokens(secretFile)
+	s.Require().Error(err)
+}
+
+func (s *TokensTestSuite) newTokens(secret string) *Tokens {
+	secretFile := filepath.Join(s.dir, "secret")
+	err := os.WriteFile(secretFile, []byte(secret+"\n"), 0600)
+	s.Require().NoError(err)
+	tokens, err := NewTokens(secretFile)
+	s.Require().NoError(err)
+	return tokens
+}
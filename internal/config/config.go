@@ -6,18 +6,44 @@ import (
 )
 
 var (
-	CAFile         = configFile("ca.pem")
-	ServerCertFile = configFile("server.pem")
-	ServerKeyFile  = configFile("server-key.pem")
+	CAFile               string
+	ServerCertFile       string
+	ServerKeyFile        string
+	RootClientCertFile   string
+	RootClientKeyFile    string
+	NobodyClientCertFile string
+	NobodyClientKeyFile  string
+	ACLPolicyFile        string
+	TokenSecretFile      string
 )
 
-func configFile(filename string) string {
+func init() {
+	UseDir(Dir())
+}
+
+// Dir is where the configuration files are looked up by default, CONFIG_DIR
+// when it is set and ~/.proglog otherwise
+func Dir() string {
 	if dir := os.Getenv("CONFIG_DIR"); dir != "" {
-		return filepath.Join(dir, filename)
+		return dir
 	}
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		panic(err)
 	}
-	return filepath.Join(homeDir, ".proglog", filename)
+	return filepath.Join(homeDir, ".proglog")
+}
+
+// UseDir points the configuration files to dir, such as a throwaway PKI
+// created with GenerateCerts in tests
+func UseDir(dir string) {
+	CAFile = filepath.Join(dir, "ca.pem")
+	ServerCertFile = filepath.Join(dir, "server.pem")
+	ServerKeyFile = filepath.Join(dir, "server-key.pem")
+	RootClientCertFile = filepath.Join(dir, "root-client.pem")
+	RootClientKeyFile = filepath.Join(dir, "root-client-key.pem")
+	NobodyClientCertFile = filepath.Join(dir, "nobody-client.pem")
+	NobodyClientKeyFile = filepath.Join(dir, "nobody-client-key.pem")
+	ACLPolicyFile = filepath.Join(dir, "policy.csv")
+	TokenSecretFile = filepath.Join(dir, "token-secret")
 }
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// certificates are issued like the profiles of test/ca-config.json
+const (
+	certExpiry  = 8760 * time.Hour
+	certKeySize = 2048
+)
+
+var certSubject = pkix.Name{
+	Country:            []string{"CA"},
+	Locality:           []string{"QC"},
+	Province:           []string{"Montreal"},
+	Organization:       []string{"My Company"},
+	OrganizationalUnit: []string{"CA Services"},
+}
+
+// GenerateCerts creates a development CA in dir along with a server
+// certificate and the root and nobody client certificates, under the same
+// file names the variables of this package point to
+func GenerateCerts(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	ca, caKey, err := issue(dir, "ca", caTemplate(), nil, nil)
+	if err != nil {
+		return err
+	}
+	server := leafTemplate("127.0.0.1", x509.ExtKeyUsageServerAuth)
+	server.DNSNames = []string{"localhost"}
+	server.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	if _, _, err = issue(dir, "server", server, ca, caKey); err != nil {
+		return err
+	}
+	for _, cn := range []string{"root", "nobody"} {
+		client := leafTemplate(cn, x509.ExtKeyUsageClientAuth)
+		if _, _, err = issue(dir, cn+"-client", client, ca, caKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func caTemplate() *x509.Certificate {
+	subject := certSubject
+	subject.CommonName = "My CA"
+	return &x509.Certificate{
+		Subject:               subject,
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(5 * certExpiry),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+}
+
+func leafTemplate(cn string, usage x509.ExtKeyUsage) *x509.Certificate {
+	subject := certSubject
+	subject.CommonName = cn
+	return &x509.Certificate{
+		Subject:     subject,
+		NotBefore:   time.Now().Add(-time.Minute),
+		NotAfter:    time.Now().Add(certExpiry),
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+	}
+}
+
+// issue signs template with the CA, or self-signs it when ca is nil, and
+// writes the certificate and key as <name>.pem and <name>-key.pem
+func issue(
+	dir string,
+	name string,
+	template *x509.Certificate,
+	ca *x509.Certificate,
+	caKey *rsa.PrivateKey,
+) (*x509.Certificate, *rsa.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, certKeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.SerialNumber, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	if ca == nil {
+		ca, caKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = writePEM(filepath.Join(dir, name+"-key.pem"), "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = writePEM(filepath.Join(dir, name+".pem"), "CERTIFICATE", der, 0644); err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func writePEM(name string, blockType string, b []byte, perm os.FileMode) error {
+	return os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: b}), perm)
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"testing"
+)
+
+type PKITestSuite struct {
+	suite.Suite
+}
+
+func TestPKITestSuite(t *testing.T) {
+	suite.Run(t, &PKITestSuite{})
+}
+
+func (s *PKITestSuite) TestGenerateCerts() {
+	dir := s.T().TempDir()
+	s.Require().NoError(GenerateCerts(dir))
+	UseDir(dir)
+	defer UseDir(Dir())
+
+	serverTLSConfig, err := SetupTLSConfig(TLSConfig{
+		CertFile: ServerCertFile,
+		KeyFile:  ServerKeyFile,
+		CAFile:   CAFile,
+		Server:   true,
+	})
+	s.Require().NoError(err)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverTLSConfig)
+	s.Require().NoError(err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	clientTLSConfig, err := SetupTLSConfig(TLSConfig{
+		CertFile:      RootClientCertFile,
+		KeyFile:       RootClientKeyFile,
+		CAFile:        CAFile,
+		ServerAddress: "127.0.0.1",
+	})
+	s.Require().NoError(err)
+	conn, err := tls.Dial("tcp", listener.Addr().String(), clientTLSConfig)
+	s.Require().NoError(err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	s.Require().NoError(err)
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	s.Require().NoError(err)
+
+	// client certificates carry the subject they are authorized as and cannot serve
+	for cn, certFile := range map[string]string{"root": RootClientCertFile, "nobody": NobodyClientCertFile} {
+		certs, err := readCertificates(certFile)
+		s.Require().NoError(err)
+		s.Require().Equal(cn, certs[0].Subject.CommonName)
+		s.Require().Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, certs[0].ExtKeyUsage)
+	}
+}
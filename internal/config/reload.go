@@ -0,0 +1,275 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSProvider serves the certificate, key, CA and CRL files of a TLSConfig
+// and reloads them whenever one of them changes, so certificates can be
+// rotated without restarting. Connections that are already established keep
+// the certificates they were set up with
+type TLSProvider struct {
+	mu sync.Mutex
+
+	cfg     TLSConfig
+	files   map[string]fileState
+	cert    *tls.Certificate
+	caCerts []*x509.Certificate
+	caPool  *x509.CertPool
+	crl     *x509.RevocationList
+	revoked map[revocation]struct{}
+}
+
+// revocation identifies a certificate by its issuer and serial number, as
+// serial numbers are only unique per issuer
+type revocation struct {
+	issuer string
+	serial string
+}
+
+// baseServerConfig is cloned for every handshake. The protocols have to be
+// offered explicitly, as the configuration returned from GetConfigForClient
+// replaces the one gRPC and net/http added them to
+var baseServerConfig = &tls.Config{
+	MinVersion: tls.VersionTLS12,
+	NextProtos: []string{"h2", "http/1.1"},
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewTLSProvider loads the files of cfg, which have to be valid
+func NewTLSProvider(cfg TLSConfig) (*TLSProvider, error) {
+	p := &TLSProvider{cfg: cfg}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ServerTLSConfig returns a server configuration that picks up the current
+// files on every handshake
+func (p *TLSProvider) ServerTLSConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return p.serverConfig()
+		},
+	}
+}
+
+// ClientTLSConfig returns a client configuration that presents the current
+// certificate on every handshake and verifies servers against the current CA
+func (p *TLSProvider) ClientTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: p.cfg.ServerAddress,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.reloadIfChanged()
+			if p.cert == nil {
+				return &tls.Certificate{}, nil
+			}
+			return p.cert, nil
+		},
+		// the CA may be rotated, so verification is done against the current pool below
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			p.mu.Lock()
+			pool := p.caPool
+			p.mu.Unlock()
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("server presented no certificate")
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range state.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+				DNSName:       state.ServerName,
+				Roots:         pool,
+				Intermediates: intermediates,
+			})
+			return err
+		},
+	}
+}
+
+// Reload reads every file of the configuration again. The previous files stay
+// in use when one of them is invalid
+func (p *TLSProvider) Reload() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.reload()
+}
+
+func (p *TLSProvider) serverConfig() (*tls.Config, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reloadIfChanged()
+	cfg := baseServerConfig.Clone()
+	if p.cert != nil {
+		cfg.Certificates = []tls.Certificate{*p.cert}
+	}
+	if p.caPool != nil {
+		cfg.ClientCAs = p.caPool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		if p.cfg.ClientCertOptional {
+			cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	if p.crl != nil {
+		crl, revoked := p.crl, p.revoked
+		cfg.VerifyPeerCertificate = func(_ [][]byte, chains [][]*x509.Certificate) error {
+			return checkRevoked(crl, revoked, chains)
+		}
+	}
+	return cfg, nil
+}
+
+// reloadIfChanged must be called with the lock held. The previous files stay
+// in use while the new ones are missing or invalid, as they may be halfway rotated
+func (p *TLSProvider) reloadIfChanged() {
+	for name, state := range p.files {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return
+		}
+		if !fi.ModTime().Equal(state.modTime) || fi.Size() != state.size {
+			_ = p.reload()
+			return
+		}
+	}
+}
+
+// reload must be called with the lock held
+func (p *TLSProvider) reload() error {
+	files := make(map[string]fileState)
+	for _, name := range []string{p.cfg.CertFile, p.cfg.KeyFile, p.cfg.CAFile, p.cfg.CRLFile} {
+		if name == "" {
+			continue
+		}
+		fi, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		files[name] = fileState{modTime: fi.ModTime(), size: fi.Size()}
+	}
+
+	var cert *tls.Certificate
+	if p.cfg.CertFile != "" && p.cfg.KeyFile != "" {
+		c, err := tls.LoadX509KeyPair(p.cfg.CertFile, p.cfg.KeyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+	var caCerts []*x509.Certificate
+	var caPool *x509.CertPool
+	if p.cfg.CAFile != "" {
+		var err error
+		if caCerts, err = readCertificates(p.cfg.CAFile); err != nil {
+			return err
+		}
+		caPool = x509.NewCertPool()
+		for _, ca := range caCerts {
+			caPool.AddCert(ca)
+		}
+	}
+	var crl *x509.RevocationList
+	if p.cfg.CRLFile != "" {
+		var err error
+		if crl, err = readRevocationList(p.cfg.CRLFile, caCerts); err != nil {
+			return err
+		}
+	}
+
+	p.files = files
+	p.cert = cert
+	p.caCerts = caCerts
+	p.caPool = caPool
+	p.crl = crl
+	p.revoked = revokedCertificates(crl)
+	return nil
+}
+
+func readCertificates(name string) ([]*x509.Certificate, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("failed to parse root certificate: %q", name)
+	}
+	return certs, nil
+}
+
+// readRevocationList reads a PEM or DER encoded CRL that has to be signed by one of the CAs
+func readRevocationList(name string, caCerts []*x509.Certificate) (*x509.RevocationList, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(b)
+	if err != nil {
+		return nil, err
+	}
+	for _, ca := range caCerts {
+		if crl.CheckSignatureFrom(ca) == nil {
+			return crl, nil
+		}
+	}
+	return nil, fmt.Errorf("revocation list %q is not signed by the CA", name)
+}
+
+func revokedCertificates(crl *x509.RevocationList) map[revocation]struct{} {
+	if crl == nil {
+		return nil
+	}
+	revoked := make(map[revocation]struct{}, len(crl.RevokedCertificateEntries))
+	for _, entry := range crl.RevokedCertificateEntries {
+		revoked[revocation{issuer: string(crl.RawIssuer), serial: entry.SerialNumber.String()}] = struct{}{}
+	}
+	return revoked
+}
+
+// checkRevoked rejects certificates listed in the CRL for their issuer. A CRL
+// past its NextUpdate rejects every certificate, as revocations issued since
+// then would be missed
+func checkRevoked(crl *x509.RevocationList, revoked map[revocation]struct{}, chains [][]*x509.Certificate) error {
+	if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+		return fmt.Errorf("revocation list expired at %s", crl.NextUpdate)
+	}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			key := revocation{issuer: string(cert.RawIssuer), serial: cert.SerialNumber.String()}
+			if _, ok := revoked[key]; ok {
+				return fmt.Errorf("certificate %q has been revoked", cert.Subject.CommonName)
+			}
+		}
+	}
+	return nil
+}
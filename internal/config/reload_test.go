@@ -0,0 +1,308 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type authority struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+type TLSProviderTestSuite struct {
+	suite.Suite
+	dir      string
+	serial   int64
+	ca       authority
+	server   TLSConfig
+	client   TLSConfig
+	listener net.Listener
+}
+
+func TestTLSProviderTestSuite(t *testing.T) {
+	suite.Run(t, &TLSProviderTestSuite{})
+}
+
+func (s *TLSProviderTestSuite) SetupTest() {
+	s.dir = s.T().TempDir()
+	s.ca = s.newAuthority("ca")
+	s.server = TLSConfig{
+		CertFile: filepath.Join(s.dir, "server.pem"),
+		KeyFile:  filepath.Join(s.dir, "server-key.pem"),
+		CAFile:   filepath.Join(s.dir, "ca.pem"),
+		Server:   true,
+	}
+	s.client = TLSConfig{
+		CertFile:      filepath.Join(s.dir, "client.pem"),
+		KeyFile:       filepath.Join(s.dir, "client-key.pem"),
+		CAFile:        filepath.Join(s.dir, "client-ca.pem"),
+		ServerAddress: "127.0.0.1",
+	}
+	s.writeCA(s.server.CAFile, s.ca)
+	s.writeCA(s.client.CAFile, s.ca)
+	s.writeCert(s.server, s.ca, "server")
+	s.writeCert(s.client, s.ca, "client")
+
+	s.listener = s.listen(s.server)
+}
+
+func (s *TLSProviderTestSuite) TearDownTest() {
+	s.Require().NoError(s.listener.Close())
+}
+
+func (s *TLSProviderTestSuite) TestRotateServerCertificate() {
+	conn := s.dial()
+	defer conn.Close()
+	s.Require().NoError(echo(conn))
+	before := conn.ConnectionState().PeerCertificates[0].SerialNumber
+
+	s.writeCert(s.server, s.ca, "server")
+	s.Require().NoError(echo(conn))
+
+	rotated := s.dial()
+	defer rotated.Close()
+	s.Require().NoError(echo(rotated))
+	after := rotated.ConnectionState().PeerCertificates[0].SerialNumber
+	s.Require().NotEqual(before, after)
+}
+
+func (s *TLSProviderTestSuite) TestRotateCA() {
+	conn := s.dial()
+	defer conn.Close()
+	s.Require().NoError(echo(conn))
+
+	next := s.newAuthority("next-ca")
+	s.writeCA(s.server.CAFile, next)
+	s.Require().NoError(echo(conn))
+
+	// clients with a certificate of the previous CA are rejected on new connections
+	rejected := s.dial()
+	defer rejected.Close()
+	s.Require().Error(echo(rejected))
+
+	s.writeCert(s.client, next, "client")
+	rotated := s.dial()
+	defer rotated.Close()
+	s.Require().NoError(echo(rotated))
+}
+
+func (s *TLSProviderTestSuite) TestRevokedClientCertificate() {
+	revoked := s.writeCert(s.client, s.ca, "client")
+	s.server.CRLFile = filepath.Join(s.dir, "crl.pem")
+	s.writeCRL(s.server.CRLFile, s.ca, revoked)
+	listener := s.listen(s.server)
+	defer listener.Close()
+
+	rejected := s.dialAddr(listener.Addr().String())
+	defer rejected.Close()
+	s.Require().Error(echo(rejected))
+
+	// issuing a new certificate lets the client back in
+	issued := s.writeCert(s.client, s.ca, "client")
+	conn := s.dialAddr(listener.Addr().String())
+	defer conn.Close()
+	s.Require().NoError(echo(conn))
+
+	// revoking it under a live connection only affects new connections
+	s.writeCRL(s.server.CRLFile, s.ca, revoked, issued)
+	s.Require().NoError(echo(conn))
+	rejected = s.dialAddr(listener.Addr().String())
+	defer rejected.Close()
+	s.Require().Error(echo(rejected))
+}
+
+func (s *TLSProviderTestSuite) TestRevocationsOfOtherIssuer() {
+	// both CAs are trusted and the CRL of one revokes a serial the other issued
+	other := s.newAuthority("other-ca")
+	s.writeFile(s.server.CAFile, append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.ca.cert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: other.cert.Raw})...,
+	))
+	serial := s.writeCert(s.client, other, "client")
+	s.server.CRLFile = filepath.Join(s.dir, "crl.pem")
+	s.writeCRL(s.server.CRLFile, s.ca, serial)
+	listener := s.listen(s.server)
+	defer listener.Close()
+
+	conn := s.dialAddr(listener.Addr().String())
+	defer conn.Close()
+	s.Require().NoError(echo(conn))
+}
+
+func (s *TLSProviderTestSuite) TestStaleRevocationList() {
+	s.server.CRLFile = filepath.Join(s.dir, "crl.pem")
+	s.writeCRL(s.server.CRLFile, s.ca)
+	listener := s.listen(s.server)
+	defer listener.Close()
+
+	stale := s.signCRL(s.ca, &x509.RevocationList{
+		ThisUpdate: time.Now().Add(-2 * time.Hour),
+		NextUpdate: time.Now().Add(-time.Hour),
+	})
+	s.writeFile(s.server.CRLFile, stale)
+	rejected := s.dialAddr(listener.Addr().String())
+	defer rejected.Close()
+	s.Require().Error(echo(rejected))
+}
+
+func (s *TLSProviderTestSuite) TestNegotiatesHTTP2() {
+	provider, err := NewTLSProvider(s.client)
+	s.Require().NoError(err)
+	cfg := provider.ClientTLSConfig()
+	cfg.NextProtos = []string{"h2"}
+	conn, err := tls.Dial("tcp", s.listener.Addr().String(), cfg)
+	s.Require().NoError(err)
+	defer conn.Close()
+	s.Require().NoError(echo(conn))
+	s.Require().Equal("h2", conn.ConnectionState().NegotiatedProtocol)
+}
+
+func (s *TLSProviderTestSuite) TestInvalidFilesKeepPreviousCertificate() {
+	conn := s.dial()
+	defer conn.Close()
+	s.Require().NoError(echo(conn))
+	before := conn.ConnectionState().PeerCertificates[0].SerialNumber
+
+	s.writeFile(s.server.CertFile, []byte("not a certificate"))
+	again := s.dial()
+	defer again.Close()
+	s.Require().NoError(echo(again))
+	s.Require().Equal(before, again.ConnectionState().PeerCertificates[0].SerialNumber)
+}
+
+// listen serves an echo server with a provider for cfg
+func (s *TLSProviderTestSuite) listen(cfg TLSConfig) net.Listener {
+	provider, err := NewTLSProvider(cfg)
+	s.Require().NoError(err)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", provider.ServerTLSConfig())
+	s.Require().NoError(err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}(conn)
+		}
+	}()
+	return listener
+}
+
+func (s *TLSProviderTestSuite) dial() *tls.Conn {
+	return s.dialAddr(s.listener.Addr().String())
+}
+
+// dialAddr connects with a client provider of its own, so it reads the client files as they are now
+func (s *TLSProviderTestSuite) dialAddr(addr string) *tls.Conn {
+	provider, err := NewTLSProvider(s.client)
+	s.Require().NoError(err)
+	conn, err := tls.Dial("tcp", addr, provider.ClientTLSConfig())
+	s.Require().NoError(err)
+	return conn
+}
+
+// echo round trips a message, which also surfaces the server rejecting the handshake
+func echo(conn *tls.Conn) error {
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return err
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(conn, make([]byte, 4))
+	return err
+}
+
+func (s *TLSProviderTestSuite) newAuthority(name string) authority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+	template := s.template(name)
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	s.Require().NoError(err)
+	cert, err := x509.ParseCertificate(der)
+	s.Require().NoError(err)
+	return authority{cert: cert, key: key}
+}
+
+func (s *TLSProviderTestSuite) writeCA(name string, ca authority) {
+	s.writeFile(name, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}))
+}
+
+// writeCert issues a new certificate for the files of cfg and returns its serial number
+func (s *TLSProviderTestSuite) writeCert(cfg TLSConfig, ca authority, name string) *big.Int {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+	template := s.template(name)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	s.Require().NoError(err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	s.Require().NoError(err)
+	s.writeFile(cfg.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	s.writeFile(cfg.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	return template.SerialNumber
+}
+
+func (s *TLSProviderTestSuite) writeCRL(name string, ca authority, serials ...*big.Int) {
+	template := &x509.RevocationList{
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+	for _, serial := range serials {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries,
+			x509.RevocationListEntry{SerialNumber: serial, RevocationTime: time.Now()})
+	}
+	s.writeFile(name, s.signCRL(ca, template))
+}
+
+// signCRL signs template with ca and returns it PEM encoded
+func (s *TLSProviderTestSuite) signCRL(ca authority, template *x509.RevocationList) []byte {
+	s.serial++
+	template.Number = big.NewInt(s.serial)
+	der, err := x509.CreateRevocationList(rand.Reader, template, ca.cert, ca.key)
+	s.Require().NoError(err)
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+func (s *TLSProviderTestSuite) template(name string) *x509.Certificate {
+	s.serial++
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(s.serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+}
+
+// writeFile moves the modification time forward, so rewrites within the
+// resolution of the file system are still picked up
+func (s *TLSProviderTestSuite) writeFile(name string, b []byte) {
+	err := os.WriteFile(name, b, 0600)
+	s.Require().NoError(err)
+	modTime := time.Now().Add(time.Duration(s.serial) * time.Second)
+	err = os.Chtimes(name, modTime, modTime)
+	s.Require().NoError(err)
+}
@@ -13,6 +13,11 @@ type TLSConfig struct {
 	CAFile        string
 	ServerAddress string
 	Server        bool
+	// ClientCertOptional lets servers accept clients without a certificate,
+	// such as clients that authenticate with a bearer token
+	ClientCertOptional bool
+	// CRLFile lists revoked client certificates, only used by TLSProvider
+	CRLFile string
 }
 
 func SetupTLSConfig(cfg TLSConfig) (*tls.Config, error) {
@@ -44,6 +49,9 @@ func SetupTLSConfig(cfg TLSConfig) (*tls.Config, error) {
 		if cfg.Server {
 			tlsConfig.ClientCAs = ca
 			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			if cfg.ClientCertOptional {
+				tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			}
 		} else {
 			tlsConfig.RootCAs = ca
 			tlsConfig.ServerName = cfg.ServerAddress
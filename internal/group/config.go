@@ -0,0 +1,38 @@
+package group
+
+import (
+	"errors"
+	"log/slog"
+)
+
+var defaultCompactionThreshold = 1024
+
+type options struct {
+	compactionThreshold *int
+	logger              *slog.Logger
+}
+
+type Options func(options *options) error
+
+// WithCompactionThreshold sets how many commits may be appended to the offsets
+// log on top of the live entries before the log is compacted
+func WithCompactionThreshold(n int) Options {
+	return func(options *options) error {
+		if n < 1 {
+			return errors.New("compaction threshold should be a positive value")
+		}
+		options.compactionThreshold = &n
+		return nil
+	}
+}
+
+// WithLogger logs compaction failures to logger instead of slog.Default
+func WithLogger(logger *slog.Logger) Options {
+	return func(options *options) error {
+		if logger == nil {
+			return errors.New("logger should not be nil")
+		}
+		options.logger = logger
+		return nil
+	}
+}
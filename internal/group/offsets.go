@@ -0,0 +1,280 @@
+package group
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/log"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	compactingSuffix = ".compacting"
+	oldSuffix        = ".old"
+)
+
+// key identifies the committed position of a group in a partition of a topic
+type key struct {
+	Group     string
+	Topic     string
+	Partition uint32
+}
+
+func (k key) encode() []byte {
+	return []byte(fmt.Sprintf("%s\x00%s\x00%d", k.Group, k.Topic, k.Partition))
+}
+
+func decodeKey(b []byte) (key, error) {
+	parts := strings.Split(string(b), "\x00")
+	if len(parts) != 3 {
+		return key{}, fmt.Errorf("malformed offset commit key %q", b)
+	}
+	p, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		return key{}, fmt.Errorf("malformed offset commit key %q: %w", b, err)
+	}
+	return key{Group: parts[0], Topic: parts[1], Partition: uint32(p)}, nil
+}
+
+// OffsetStore durably keeps the committed offsets of consumer groups.
+// Every commit is appended to an internal Log that is compacted down to
+// the latest commit per key once enough superseded commits piled up. Removed
+// commits are appended as records without a value, which compaction drops
+type OffsetStore struct {
+	mu sync.Mutex
+
+	Dir      string
+	log      *log.Log
+	offsets  map[key]uint64
+	appended int
+	options  options
+}
+
+// NewOffsetStore opens the offsets log in dir and rebuilds the committed
+// offsets from it
+func NewOffsetStore(dir string, opts ...Options) (*OffsetStore, error) {
+	var oOpts options
+	for _, opt := range opts {
+		if err := opt(&oOpts); err != nil {
+			return nil, fmt.Errorf("error on offset store creation: %v", err)
+		}
+	}
+	if oOpts.compactionThreshold == nil {
+		oOpts.compactionThreshold = &defaultCompactionThreshold
+	}
+	if oOpts.logger == nil {
+		oOpts.logger = slog.Default()
+	}
+
+	o := &OffsetStore{
+		Dir:     dir,
+		options: oOpts,
+	}
+	if err := o.recoverCompaction(); err != nil {
+		return nil, err
+	}
+	if err := o.open(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Commit durably records offset as the next offset the group consumes from the
+// partition. The commit succeeded once it was appended, a compaction that fails
+// afterwards is logged and tried again on a later commit
+func (o *OffsetStore) Commit(group, topic string, partition uint32, offset uint64) error {
+	if group == "" {
+		return errors.New("group id should not be empty")
+	}
+	k := key{Group: group, Topic: topic, Partition: partition}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.append(o.log, k, offset); err != nil {
+		return err
+	}
+	if err := o.log.Sync(); err != nil {
+		return err
+	}
+	o.offsets[k] = offset
+	o.appended++
+	o.maybeCompact()
+	return nil
+}
+
+// RemoveTopic durably deletes the offsets every group committed for the
+// partitions of topic, such as when the topic is deleted, so that groups of a
+// topic created under the same name start over
+func (o *OffsetStore) RemoveTopic(topic string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var removed []key
+	for k := range o.offsets {
+		if k.Topic != topic {
+			continue
+		}
+		if _, err := o.log.Append(&api.Record{Key: k.encode()}); err != nil {
+			return err
+		}
+		removed = append(removed, k)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	if err := o.log.Sync(); err != nil {
+		return err
+	}
+	for _, k := range removed {
+		delete(o.offsets, k)
+		// the removal and the commit it removes are both superseded
+		o.appended += 2
+	}
+	o.maybeCompact()
+	return nil
+}
+
+// maybeCompact compacts the log once enough superseded records piled up, a
+// compaction that fails is logged and tried again later
+func (o *OffsetStore) maybeCompact() {
+	if o.appended >= *o.options.compactionThreshold+len(o.offsets) {
+		if err := o.compact(); err != nil {
+			o.options.logger.Error("offsets compaction failed", "dir", o.Dir, "error", err)
+		}
+	}
+}
+
+// Fetch returns the offset committed by the group for the partition
+// and whether the group committed one at all
+func (o *OffsetStore) Fetch(group, topic string, partition uint32) (uint64, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	off, ok := o.offsets[key{Group: group, Topic: topic, Partition: partition}]
+	return off, ok
+}
+
+// Close closes the offsets log
+func (o *OffsetStore) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.log.Close()
+}
+
+// Remove closes the offsets log and deletes all committed offsets
+func (o *OffsetStore) Remove() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.log.Remove()
+}
+
+func (o *OffsetStore) open() error {
+	if err := os.MkdirAll(o.Dir, 0755); err != nil {
+		return err
+	}
+	l, err := log.NewLog(o.Dir)
+	if err != nil {
+		return err
+	}
+	offsets := make(map[key]uint64)
+	appended := 0
+	for off := l.LowestOffset(); off < l.NextOffset(); off++ {
+		rec, err := l.Read(off)
+		if err != nil {
+			return errors.Join(err, l.Close())
+		}
+		k, err := decodeKey(rec.Key)
+		if err != nil {
+			return errors.Join(err, l.Close())
+		}
+		appended++
+		switch len(rec.Value) {
+		case 0:
+			delete(offsets, k)
+		case 8:
+			offsets[k] = binary.BigEndian.Uint64(rec.Value)
+		default:
+			return errors.Join(fmt.Errorf("malformed offset commit at %d", off), l.Close())
+		}
+	}
+	o.log = l
+	o.offsets = offsets
+	o.appended = appended - len(offsets)
+	return nil
+}
+
+func (o *OffsetStore) append(l *log.Log, k key, offset uint64) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, offset)
+	_, err := l.Append(&api.Record{Key: k.encode(), Value: value})
+	return err
+}
+
+// compact rewrites the live commits into a fresh log and swaps it in place of
+// the current one. The swap is done with renames that recoverCompaction can
+// finish or roll back if the process crashes in between
+func (o *OffsetStore) compact() error {
+	tmpDir := o.Dir + compactingSuffix
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return err
+	}
+	compacted, err := log.NewLog(tmpDir)
+	if err != nil {
+		return err
+	}
+	for k, offset := range o.offsets {
+		if err = o.append(compacted, k, offset); err != nil {
+			return errors.Join(err, compacted.Remove())
+		}
+	}
+	if err = compacted.Sync(); err != nil {
+		return errors.Join(err, compacted.Remove())
+	}
+	if err = compacted.Close(); err != nil {
+		return errors.Join(err, os.RemoveAll(tmpDir))
+	}
+	// from here on every failure reopens whichever log is in place, so the store keeps working
+	if err = o.log.Close(); err != nil {
+		return errors.Join(err, os.RemoveAll(tmpDir), o.open())
+	}
+	if err = os.Rename(o.Dir, o.Dir+oldSuffix); err != nil {
+		return errors.Join(err, os.RemoveAll(tmpDir), o.open())
+	}
+	if err = os.Rename(tmpDir, o.Dir); err != nil {
+		return errors.Join(err, os.Rename(o.Dir+oldSuffix, o.Dir), o.open())
+	}
+	if err = o.open(); err != nil {
+		return err
+	}
+	// a leftover old log is removed by recoverCompaction on the next start
+	return os.RemoveAll(o.Dir + oldSuffix)
+}
+
+// recoverCompaction completes or discards a compaction that was interrupted
+func (o *OffsetStore) recoverCompaction() error {
+	tmpDir := o.Dir + compactingSuffix
+	if _, err := os.Stat(o.Dir); errors.Is(err, os.ErrNotExist) {
+		// the old log was moved aside, so the compacted log is complete
+		if _, err = os.Stat(tmpDir); err == nil {
+			if err = os.Rename(tmpDir, o.Dir); err != nil {
+				return err
+			}
+		} else if _, err = os.Stat(o.Dir + oldSuffix); err == nil {
+			if err = os.Rename(o.Dir+oldSuffix, o.Dir); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+	return os.RemoveAll(o.Dir + oldSuffix)
+}
@@ -0,0 +1,157 @@
+package group
+
+import (
+	"github.com/stretchr/testify/suite"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type OffsetStoreTestSuite struct {
+	suite.Suite
+	testDir string
+	store   *OffsetStore
+}
+
+func TestOffsetStoreTestSuite(t *testing.T) {
+	suite.Run(t, &OffsetStoreTestSuite{})
+}
+
+func (s *OffsetStoreTestSuite) SetupTest() {
+	dir, err := os.MkdirTemp("", "offset-store-test")
+	s.Require().NoError(err)
+	s.testDir = dir
+
+	s.store, err = NewOffsetStore(s.offsetsDir(), WithCompactionThreshold(4))
+	s.Require().NoError(err)
+}
+
+func (s *OffsetStoreTestSuite) TearDownTest() {
+	err := os.RemoveAll(s.testDir)
+	s.Require().NoError(err)
+}
+
+func (s *OffsetStoreTestSuite) TestCommitAndFetch() {
+	_, ok := s.store.Fetch("billing", "orders", 0)
+	s.Require().False(ok)
+
+	err := s.store.Commit("billing", "orders", 0, 10)
+	s.Require().NoError(err)
+	err = s.store.Commit("billing", "orders", 1, 3)
+	s.Require().NoError(err)
+	err = s.store.Commit("billing", "orders", 0, 12)
+	s.Require().NoError(err)
+
+	off, ok := s.store.Fetch("billing", "orders", 0)
+	s.Require().True(ok)
+	s.Require().Equal(uint64(12), off)
+	off, ok = s.store.Fetch("billing", "orders", 1)
+	s.Require().True(ok)
+	s.Require().Equal(uint64(3), off)
+	_, ok = s.store.Fetch("shipping", "orders", 0)
+	s.Require().False(ok)
+}
+
+func (s *OffsetStoreTestSuite) TestCommitEmptyGroupThenFail() {
+	err := s.store.Commit("", "orders", 0, 10)
+	s.Require().Error(err)
+}
+
+func (s *OffsetStoreTestSuite) TestReopenRestoresOffsets() {
+	err := s.store.Commit("billing", "orders", 0, 10)
+	s.Require().NoError(err)
+	err = s.store.Close()
+	s.Require().NoError(err)
+
+	s.store, err = NewOffsetStore(s.offsetsDir())
+	s.Require().NoError(err)
+	off, ok := s.store.Fetch("billing", "orders", 0)
+	s.Require().True(ok)
+	s.Require().Equal(uint64(10), off)
+}
+
+func (s *OffsetStoreTestSuite) TestRemoveTopic() {
+	for _, group := range []string{"billing", "shipping"} {
+		s.Require().NoError(s.store.Commit(group, "orders", 0, 10))
+		s.Require().NoError(s.store.Commit(group, "orders", 1, 3))
+	}
+	s.Require().NoError(s.store.Commit("billing", "payments", 0, 7))
+	s.Require().NoError(s.store.RemoveTopic("orders"))
+
+	// the removal outlives a restart
+	s.Require().NoError(s.store.Close())
+	var err error
+	s.store, err = NewOffsetStore(s.offsetsDir())
+	s.Require().NoError(err)
+	for _, group := range []string{"billing", "shipping"} {
+		_, ok := s.store.Fetch(group, "orders", 0)
+		s.Require().False(ok)
+	}
+	off, ok := s.store.Fetch("billing", "payments", 0)
+	s.Require().True(ok)
+	s.Require().Equal(uint64(7), off)
+}
+
+func (s *OffsetStoreTestSuite) TestCompaction() {
+	for i := uint64(0); i < 20; i++ {
+		err := s.store.Commit("billing", "orders", 0, i)
+		s.Require().NoError(err)
+	}
+	// compaction keeps the offsets log from growing with superseded commits
+	s.Require().Less(s.store.log.NextOffset()-s.store.log.LowestOffset(), uint64(5))
+	s.Require().NoDirExists(s.offsetsDir() + compactingSuffix)
+	s.Require().NoDirExists(s.offsetsDir() + oldSuffix)
+
+	err := s.store.Close()
+	s.Require().NoError(err)
+	s.store, err = NewOffsetStore(s.offsetsDir())
+	s.Require().NoError(err)
+	off, ok := s.store.Fetch("billing", "orders", 0)
+	s.Require().True(ok)
+	s.Require().Equal(uint64(19), off)
+}
+
+func (s *OffsetStoreTestSuite) TestFailedCompaction() {
+	// a non-empty directory where the old log is moved to makes the swap fail
+	blocker := filepath.Join(s.offsetsDir()+oldSuffix, "blocker")
+	s.Require().NoError(os.MkdirAll(blocker, 0755))
+
+	for i := uint64(0); i < 10; i++ {
+		err := s.store.Commit("billing", "orders", 0, i)
+		s.Require().NoError(err)
+	}
+	off, ok := s.store.Fetch("billing", "orders", 0)
+	s.Require().True(ok)
+	s.Require().Equal(uint64(9), off)
+	s.Require().Equal(uint64(10), s.store.log.NextOffset())
+
+	// the next commit compacts once the swap can succeed
+	s.Require().NoError(os.RemoveAll(s.offsetsDir() + oldSuffix))
+	err := s.store.Commit("billing", "orders", 0, 10)
+	s.Require().NoError(err)
+	s.Require().Less(s.store.log.NextOffset(), uint64(5))
+	off, ok = s.store.Fetch("billing", "orders", 0)
+	s.Require().True(ok)
+	s.Require().Equal(uint64(10), off)
+}
+
+func (s *OffsetStoreTestSuite) TestRecoverInterruptedCompaction() {
+	err := s.store.Commit("billing", "orders", 0, 7)
+	s.Require().NoError(err)
+	err = s.store.Close()
+	s.Require().NoError(err)
+
+	// simulate a crash after the old log was moved aside but before the compacted log replaced it
+	err = os.Rename(s.offsetsDir(), s.offsetsDir()+compactingSuffix)
+	s.Require().NoError(err)
+
+	s.store, err = NewOffsetStore(s.offsetsDir())
+	s.Require().NoError(err)
+	off, ok := s.store.Fetch("billing", "orders", 0)
+	s.Require().True(ok)
+	s.Require().Equal(uint64(7), off)
+}
+
+func (s *OffsetStoreTestSuite) offsetsDir() string {
+	return filepath.Join(s.testDir, "__consumer_offsets")
+}
@@ -1,13 +1,30 @@
 package log
 
+import (
+	"errors"
+	"log/slog"
+	"time"
+)
+
 type segmentOptions struct {
 	maxIndexSizeBytes *uint64
 	maxStoreSizeBytes *uint64
 	initialOffset     *uint64
 }
 
+type tieringOptions struct {
+	objectStore   ObjectStore
+	keyPrefix     string
+	threshold     time.Duration
+	cacheSegments int
+}
+
 type options struct {
 	segmentOptions segmentOptions
+	tiering        *tieringOptions
+	logger         *slog.Logger
+	observers      []Observer
+	producerWindow *int
 }
 
 type Options func(options *options) error
@@ -20,3 +37,50 @@ func WithSegmentParams(iSize uint64, sSize uint64, iOff uint64) Options {
 		return nil
 	}
 }
+
+// WithTieredStorage offloads sealed segments that have not been written to
+// for at least threshold into the given ObjectStore. Object keys are prefixed
+// with keyPrefix so that several logs can share one store. Remote segments are
+// fetched on demand into a local cache that holds at most cacheSegments segments.
+// The Log looks for segments to offload in the background, see Offload
+func WithTieredStorage(store ObjectStore, keyPrefix string, threshold time.Duration, cacheSegments int) Options {
+	return func(options *options) error {
+		if store == nil {
+			return errors.New("tiered storage requires an object store")
+		}
+		if cacheSegments < 1 {
+			return errors.New("tiered storage cache should hold at least one segment")
+		}
+		options.tiering = &tieringOptions{
+			objectStore:   store,
+			keyPrefix:     keyPrefix,
+			threshold:     threshold,
+			cacheSegments: cacheSegments,
+		}
+		return nil
+	}
+}
+
+// WithProducerWindow sets how many of its latest records are remembered per
+// idempotent producer, retries of older records are rejected
+func WithProducerWindow(n int) Options {
+	return func(options *options) error {
+		if n < 1 {
+			return errors.New("producer window should hold at least one record")
+		}
+		options.producerWindow = &n
+		return nil
+	}
+}
+
+// WithLogger logs segment creation and removal and recovery actions to logger
+// instead of slog.Default
+func WithLogger(logger *slog.Logger) Options {
+	return func(options *options) error {
+		if logger == nil {
+			return errors.New("logger should not be nil")
+		}
+		options.logger = logger
+		return nil
+	}
+}
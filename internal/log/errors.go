@@ -4,12 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 var (
 	ErrEndOfFile = errors.New("no record stored at this position")
 	ErrFileFull  = errors.New("cannot process this write operation without exceeding maximum size")
+
+	ErrObjectNotFound = errors.New("object does not exist in the object store")
 )
 
 type ErrOffsetOutOfRange struct {
@@ -39,3 +42,33 @@ func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
 func (e ErrOffsetOutOfRange) Error() string {
 	return e.GRPCStatus().Err().Error()
 }
+
+// ErrLogClosed is returned for reads and writes of a Log that was closed, such
+// as the partition of a topic deleted while it was still in use
+type ErrLogClosed struct{}
+
+func (e ErrLogClosed) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, "log is closed")
+}
+
+func (e ErrLogClosed) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrOutOfOrderSequence is returned for a record of an idempotent producer
+// whose sequence neither follows the latest one of the producer nor belongs
+// to a record that is still in the dedup window
+type ErrOutOfOrderSequence struct {
+	ProducerID string
+	Sequence   uint64
+	Expected   uint64
+}
+
+func (e ErrOutOfOrderSequence) GRPCStatus() *status.Status {
+	return status.New(codes.FailedPrecondition, fmt.Sprintf(
+		"out of order sequence %d of producer %q, expected %d", e.Sequence, e.ProducerID, e.Expected))
+}
+
+func (e ErrOutOfOrderSequence) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
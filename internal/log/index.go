@@ -91,16 +91,42 @@ func (i *index) Write(off uint32, pos uint64) error {
 	return nil
 }
 
+// truncate drops every entry past the first n entries and persists the new
+// end of the index
+func (i *index) truncate(n uint64) error {
+	size := n * totalEntrySizeBytes
+	if size >= i.size {
+		return nil
+	}
+	clear(i.mmap[size:i.size])
+	i.size = size
+	return i.sync()
+}
+
+// trim drops trailing entries that do not point into a store of the given
+// size. Those are left behind when the index was not closed cleanly, since
+// its file keeps the size of the memory mapping until Close
+func (i *index) trim(storeSize uint64) (trimmed uint64) {
+	i.size -= i.size % totalEntrySizeBytes
+	for i.size > 0 {
+		last := i.size/totalEntrySizeBytes - 1
+		off, pos, err := i.Read(int64(last))
+		if err == nil && uint64(off) == last && pos < storeSize {
+			return trimmed
+		}
+		i.size -= totalEntrySizeBytes
+		trimmed++
+	}
+	return trimmed
+}
+
 // Close initiates a graceful shutdown of the index by adjusting
 // file size to include actual file contents and not the maximum
 // index size that was originally configured for memory mapping
 // purposes. A similar adjustment is made to the memory mapping
 // structure.
 func (i *index) Close() error {
-	if err := i.mmap.Sync(gommap.MS_SYNC); err != nil {
-		return err
-	}
-	if err := i.file.Sync(); err != nil {
+	if err := i.sync(); err != nil {
 		return err
 	}
 	if err := i.file.Truncate(int64(i.size)); err != nil {
@@ -109,3 +135,11 @@ func (i *index) Close() error {
 
 	return i.file.Close()
 }
+
+// sync flushes the memory mapped entries of the index to its file
+func (i *index) sync() error {
+	if err := i.mmap.Sync(gommap.MS_SYNC); err != nil {
+		return err
+	}
+	return i.file.Sync()
+}
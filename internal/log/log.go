@@ -1,15 +1,24 @@
 package log
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	api "github.com/a-shakra/commit-log/api/v1"
+	"log/slog"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
+// truncateMarkerFile records the offset of a truncation until all of its segments were cut
+const truncateMarkerFile = "truncate"
+
 var (
 	defaultIndexSizeBytes uint64 = 1024
 	defaultStoreSizeBytes        = defaultIndexSizeBytes * 15
@@ -18,14 +27,33 @@ var (
 
 // Log represents the entire write-ahead log store in the given directory.
 // It maintains a references to all segments that contain data and has
-// access to the current active segment that data will be written to
+// access to the current active segment that data will be written to.
+// When tiered storage is enabled, sealed segments may only exist in the
+// object store and are read through a bounded local cache
 type Log struct {
 	mu sync.RWMutex
 
 	Dir           string
 	activeSegment *segment
 	segments      []*segment
+	remote        []remoteSegment
+	cache         *segmentCache
 	options       options
+	logger        *slog.Logger
+	producers     *producers
+	transactions  *transactions
+	// builtin record the metrics and logs of the Log
+	builtin []builtinObserver
+	// closed is set by Close, the files of the segments must not be touched afterwards
+	closed bool
+	// appended is closed and replaced on every append, and closed by Close
+	appended chan struct{}
+
+	// offloadMu serializes uploads to the object store, which run without mu
+	// held, with Close and TruncateAfter that change the files of sealed segments
+	offloadMu sync.Mutex
+	// stopOffload stops the background offload of tiered storage and waits for it
+	stopOffload func()
 }
 
 // NewLog returns an instance of a Log object that contains
@@ -49,37 +77,56 @@ func NewLog(dir string, opts ...Options) (*Log, error) {
 	if lOpts.segmentOptions.initialOffset == nil {
 		lOpts.segmentOptions.initialOffset = &defaultInitialOffset
 	}
+	if lOpts.logger == nil {
+		lOpts.logger = slog.Default()
+	}
+	if lOpts.producerWindow == nil {
+		lOpts.producerWindow = &defaultProducerWindow
+	}
 
 	l := &Log{
 		Dir:     dir,
 		options: lOpts,
+		logger:  lOpts.logger.With("dir", dir),
 	}
+	l.builtin = []builtinObserver{metricsObserver{}, loggingObserver{logger: l.logger}}
 
 	if err := l.setup(); err != nil {
+		l.stopObservers()
 		return nil, err
 	}
 	return l, nil
 }
 
 func (l *Log) setup() error {
+	l.appended = make(chan struct{})
+	// started first, so they observe the corruption found while opening segments
+	l.startObservers()
 	files, err := os.ReadDir(l.Dir)
 	if err != nil {
 		return err
 	}
+	seen := make(map[uint64]bool)
 	var baseOffsets []uint64
 	for _, file := range files {
-		offStr := strings.TrimSuffix(file.Name(), path.Ext(file.Name()))
-		off, _ := strconv.ParseUint(offStr, 10, 0)
+		ext := path.Ext(file.Name())
+		if file.IsDir() || (ext != storeExt && ext != indexExt) {
+			continue
+		}
+		offStr := strings.TrimSuffix(file.Name(), ext)
+		off, err := strconv.ParseUint(offStr, 10, 0)
+		if err != nil || seen[off] {
+			continue
+		}
+		seen[off] = true
 		baseOffsets = append(baseOffsets, off)
 	}
+	// directory entries are sorted by name, segments have to be sorted by offset
+	sort.Slice(baseOffsets, func(i, j int) bool { return baseOffsets[i] < baseOffsets[j] })
 
-	for i, off := range baseOffsets {
-		// record every other offset since segment obj exists in a pair of index and store files
-		if i%2 == 0 {
-			err = l.newSegment(off)
-			if err != nil {
-				return err
-			}
+	for _, off := range baseOffsets {
+		if err = l.newSegment(off); err != nil {
+			return err
 		}
 	}
 	if l.segments == nil {
@@ -87,6 +134,55 @@ func (l *Log) setup() error {
 			return err
 		}
 	}
+	if err = l.recoverTruncation(); err != nil {
+		return err
+	}
+	if err = l.loadRecordState(); err != nil {
+		return err
+	}
+	l.registerGauges()
+	if err = l.setupRemote(seen); err != nil {
+		return err
+	}
+	l.startOffloader()
+	return nil
+}
+
+// setupRemote registers the segments that were offloaded to the object store
+// and have no local copy. A remote segment ends where the following segment begins
+func (l *Log) setupRemote(local map[uint64]bool) error {
+	l.remote = nil
+	if l.options.tiering == nil {
+		return nil
+	}
+	cache, err := newSegmentCache(
+		filepath.Join(l.Dir, segmentCacheDir),
+		l.options.tiering,
+		&l.options.segmentOptions,
+	)
+	if err != nil {
+		return err
+	}
+	l.cache = cache
+
+	remoteBases, err := listRemoteBaseOffsets(l.options.tiering)
+	if err != nil {
+		return err
+	}
+	var bases []uint64
+	for off := range remoteBases {
+		if !local[off] && off < l.segments[0].baseOffset {
+			bases = append(bases, off)
+		}
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+	for i, off := range bases {
+		next := l.segments[0].baseOffset
+		if i+1 < len(bases) {
+			next = bases[i+1]
+		}
+		l.remote = append(l.remote, remoteSegment{baseOffset: off, nextOffset: next})
+	}
 	return nil
 }
 
@@ -95,31 +191,111 @@ func (l *Log) newSegment(off uint64) error {
 	if err != nil {
 		return err
 	}
+	l.logger.Debug("segment opened", "base_offset", s.baseOffset, "next_offset", s.nextOffset)
+	if s.trimmedEntries > 0 {
+		err = fmt.Errorf("dropped %d index entries of records that were not fully written", s.trimmedEntries)
+		l.emit(Event{Type: EventCorruption, BaseOffset: s.baseOffset, Offset: s.nextOffset, Err: err})
+	}
 	l.segments = append(l.segments, s)
 	l.activeSegment = s
 	return nil
 }
 
 // Append stores a record object into the next available offset in
-// the current active segment
-func (l *Log) Append(record *api.Record) (uint64, error) {
+// the current active segment. When the active segment is full a new
+// segment is rolled and the record is stored there instead. A record of an
+// idempotent producer that was stored before is not stored again, the
+// offset it was stored at is returned instead. Appended records are buffered
+// until Sync or Close
+func (l *Log) Append(record *api.Record) (off uint64, err error) {
+	return l.append(record, true)
+}
+
+// AppendReplica stores a record that the leader of a replicated log already
+// accepted. Its producer sequence is remembered but not checked, so a replica
+// never drops a record the leader stored
+func (l *Log) AppendReplica(record *api.Record) (uint64, error) {
+	return l.append(record, false)
+}
+
+func (l *Log) append(record *api.Record, dedup bool) (off uint64, err error) {
+	var duplicate bool
+	start := time.Now()
+	defer func() {
+		// stored records are observed through EventAppended
+		switch {
+		case err != nil:
+			l.emitOperation(operation{Event: Event{Type: eventAppendFailed, Err: err}, duration: time.Since(start)})
+		case duplicate:
+			l.emitOperation(operation{Event: Event{Type: eventDuplicateAppend, Offset: off}, duration: time.Since(start)})
+		}
+	}()
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrLogClosed{}
+	}
 
-	off, err := l.activeSegment.Append(record)
-	if err != nil {
-		if l.activeSegment.IsFull() {
-			err = l.newSegment(off + 1)
+	if dedup && record.ProducerId != "" {
+		if off, duplicate, err = l.producers.check(record); duplicate || err != nil {
+			return off, err
+		}
+	}
+	off, err = l.activeSegment.Append(record)
+	if err != nil && l.activeSegment.IsFull() {
+		// a sealed segment is synced once, Sync only syncs the active one
+		if err = l.activeSegment.sync(); err != nil {
+			return 0, err
+		}
+		if err = l.newSegment(l.activeSegment.nextOffset); err != nil {
+			l.logger.Error("segment roll failed", "base_offset", l.activeSegment.nextOffset, "error", err)
+			return 0, err
 		}
+		l.emit(Event{Type: EventSegmentRolled, BaseOffset: l.activeSegment.baseOffset})
+		off, err = l.activeSegment.Append(record)
+	}
+	if err == nil {
+		l.producers.add(record, off)
+		l.transactions.add(record, off)
+		l.emitOperation(operation{
+			Event:    Event{Type: EventAppended, BaseOffset: l.activeSegment.baseOffset, Offset: off},
+			size:     len(record.Value),
+			duration: time.Since(start),
+		})
+		close(l.appended)
+		l.appended = make(chan struct{})
 	}
 	return off, err
 }
 
-// Read returns the record that is stored in the log
-func (l *Log) Read(off uint64) (*api.Record, error) {
+// Appended returns a channel that is closed the next time a record is
+// appended, or when the Log is closed, so readers that caught up need not poll
+func (l *Log) Appended() <-chan struct{} {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
+	return l.appended
+}
 
+// Sync commits the appended records to stable storage
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrLogClosed{}
+	}
+	return l.activeSegment.sync()
+}
+
+// Read returns the record that is stored in the log
+func (l *Log) Read(off uint64) (rec *api.Record, err error) {
+	defer func(start time.Time) {
+		l.emitOperation(operation{Event: Event{Type: eventRead, Offset: off, Err: err}, duration: time.Since(start)})
+	}(time.Now())
+	l.mu.RLock()
+	if l.closed {
+		l.mu.RUnlock()
+		return nil, ErrLogClosed{}
+	}
 	var s *segment
 	for _, seg := range l.segments {
 		if seg.baseOffset <= off && off < seg.nextOffset {
@@ -129,47 +305,288 @@ func (l *Log) Read(off uint64) (*api.Record, error) {
 	}
 
 	if s == nil {
+		for _, rs := range l.remote {
+			if rs.baseOffset <= off && off < rs.nextOffset {
+				// the segment may be downloaded, which appends do not wait for
+				cache := l.cache
+				l.mu.RUnlock()
+				return cache.Read(rs, off)
+			}
+		}
+		l.mu.RUnlock()
 		return nil, ErrOffsetOutOfRange{Offset: off}
 	}
+	defer l.mu.RUnlock()
 
-	rec, err := s.Read(off)
+	rec, err = s.Read(off)
+	if err != nil {
+		// the offset is within the segment, so its record should have been readable
+		l.emit(Event{Type: EventCorruption, BaseOffset: s.baseOffset, Offset: off, Err: err})
+	}
 	return rec, err
 }
 
-// Close closes all consumed resources
+// LowestOffset returns the offset of the oldest record that can be read
+// from the log, including records that were offloaded to the object store
+func (l *Log) LowestOffset() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if len(l.remote) > 0 {
+		return l.remote[0].baseOffset
+	}
+	return l.segments[0].baseOffset
+}
+
+// NextOffset returns the offset that the next appended record will be stored at
+func (l *Log) NextOffset() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.activeSegment.nextOffset
+}
+
+// TruncateAfter removes every record with an offset greater than off, so the
+// next appended record is stored at off+1. Records that were offloaded to the
+// object store cannot be truncated. The offset is persisted before any data is
+// removed and a truncation interrupted by a crash is completed on the next setup
+func (l *Log) TruncateAfter(off uint64) error {
+	l.offloadMu.Lock()
+	defer l.offloadMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrLogClosed{}
+	}
+
+	if off+1 >= l.activeSegment.nextOffset {
+		return nil
+	}
+	if off+1 < l.segments[0].baseOffset {
+		return ErrOffsetOutOfRange{Offset: off}
+	}
+	if err := l.writeTruncateMarker(off); err != nil {
+		return err
+	}
+	if err := l.truncateAfter(off); err != nil {
+		return err
+	}
+	// the latest records of producers and transactions may be gone
+	if err := l.loadRecordState(); err != nil {
+		return err
+	}
+	l.logger.Info("log truncated", "offset", off)
+	return os.Remove(filepath.Join(l.Dir, truncateMarkerFile))
+}
+
+// truncateAfter deletes the segments that only hold records past off and
+// cuts the segment that holds off, which becomes the active segment
+func (l *Log) truncateAfter(off uint64) error {
+	keep := 0
+	for i, seg := range l.segments {
+		if seg.baseOffset <= off {
+			keep = i
+		}
+	}
+	for i := len(l.segments) - 1; i > keep; i-- {
+		if err := l.segments[i].Remove(); err != nil {
+			return err
+		}
+		l.emit(Event{Type: EventSegmentRemoved, BaseOffset: l.segments[i].baseOffset})
+	}
+	l.segments = l.segments[:keep+1]
+	l.activeSegment = l.segments[keep]
+	return l.activeSegment.truncateAfter(off)
+}
+
+func (l *Log) writeTruncateMarker(off uint64) error {
+	f, err := os.Create(filepath.Join(l.Dir, truncateMarkerFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = binary.Write(f, encoding, off); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	dir, err := os.Open(l.Dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// recoverTruncation completes a truncation that was interrupted before its marker was removed
+func (l *Log) recoverTruncation() error {
+	b, err := os.ReadFile(filepath.Join(l.Dir, truncateMarkerFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var off uint64
+	if len(b) == binary.Size(off) {
+		off = encoding.Uint64(b)
+		if off+1 < l.activeSegment.nextOffset && off+1 >= l.segments[0].baseOffset {
+			l.logger.Warn("completing interrupted truncation", "offset", off)
+			if err = l.truncateAfter(off); err != nil {
+				return err
+			}
+		}
+	}
+	// a marker that was not fully written belongs to a truncation that did not start
+	return os.Remove(filepath.Join(l.Dir, truncateMarkerFile))
+}
+
+// loadRecordState rebuilds the dedup window of producers and the open and
+// aborted transactions from the records of the local segments. Producers and
+// transactions whose records were all offloaded are not known anymore
+func (l *Log) loadRecordState() error {
+	p := newProducers(*l.options.producerWindow)
+	t := newTransactions()
+	for _, s := range l.segments {
+		for off := s.baseOffset; off < s.nextOffset; off++ {
+			rec, err := s.Read(off)
+			if err != nil {
+				return err
+			}
+			p.add(rec, off)
+			t.add(rec, off)
+		}
+	}
+	l.producers = p
+	l.transactions = t
+	return nil
+}
+
+// Offload uploads every sealed segment that has not been written to for the
+// configured tiering threshold into the object store and removes its local
+// files. A Log with tiered storage calls it in the background, calling it
+// directly offloads right away. It does nothing when tiered storage is not enabled
+func (l *Log) Offload() error {
+	if l.options.tiering == nil {
+		return nil
+	}
+	l.offloadMu.Lock()
+	defer l.offloadMu.Unlock()
+
+	for {
+		seg, err := l.nextOffload()
+		if err != nil || seg == nil {
+			return err
+		}
+		// the segment is sealed, so appends and reads go on while it is uploaded
+		if err = seg.upload(l.options.tiering); err != nil {
+			return err
+		}
+		l.mu.Lock()
+		l.remote = append(l.remote, remoteSegment{
+			baseOffset: seg.baseOffset,
+			nextOffset: seg.nextOffset,
+		})
+		l.segments = l.segments[1:]
+		l.mu.Unlock()
+		if err = seg.Remove(); err != nil {
+			return err
+		}
+		l.emit(Event{Type: EventSegmentRemoved, BaseOffset: seg.baseOffset, Offloaded: true})
+	}
+}
+
+// nextOffload returns the oldest sealed segment when it is due to be offloaded
+func (l *Log) nextOffload() (*segment, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return nil, ErrLogClosed{}
+	}
+	if len(l.segments) <= 1 {
+		return nil, nil
+	}
+	seg := l.segments[0]
+	old, err := seg.sealedBefore(l.options.tiering.threshold)
+	if err != nil || !old {
+		return nil, err
+	}
+	return seg, nil
+}
+
+// Close closes all consumed resources. Reads and writes that wait for the Log
+// while it closes, and the ones after, return ErrLogClosed
 func (l *Log) Close() error {
+	l.unregisterGauges()
+	l.stopOffloader()
+	// asynchronous observers may read the Log until their queue is drained
+	l.stopObservers()
+	l.offloadMu.Lock()
+	defer l.offloadMu.Unlock()
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.appended)
 	for _, seg := range l.segments {
 		if err := seg.Close(); err != nil {
 			return err
 		}
 	}
+	if l.cache != nil {
+		return l.cache.Close()
+	}
 	return nil
 }
 
-// Remove closes all consumed resources and deletes all Log data files
+// Remove closes all consumed resources and deletes all Log data files,
+// including the segments that were offloaded to the object store
 func (l *Log) Remove() error {
 	if err := l.Close(); err != nil {
 		return err
 	}
+	if err := l.removeRemote(); err != nil {
+		return err
+	}
 	return os.RemoveAll(l.Dir)
 }
 
+func (l *Log) removeRemote() error {
+	if l.options.tiering == nil {
+		return nil
+	}
+	var errs []error
+	for _, rs := range l.remote {
+		for _, ext := range []string{indexExt, storeExt} {
+			key := segmentKey(l.options.tiering.keyPrefix, rs.baseOffset, ext)
+			errs = append(errs, l.options.tiering.objectStore.Delete(key))
+		}
+	}
+	l.remote = nil
+	return errors.Join(errs...)
+}
+
 // Reset closes all consumed resources, deletes all Log data files
 // then restores the Log to a new empty state
 func (l *Log) Reset() error {
 	if err := l.Remove(); err != nil {
 		return err
 	}
-	err := os.MkdirAll(l.Dir, 0644)
+	err := os.MkdirAll(l.Dir, 0755)
 	if err != nil {
 		return err
 	}
 
+	l.segments = nil
+	l.activeSegment = nil
+	l.closed = false
 	err = l.setup()
 	if err != nil {
 		return err
 	}
+	l.logger.Info("log reset")
 	return nil
 }
@@ -1,9 +1,15 @@
 package log
 
 import (
+	"bytes"
+	api "github.com/a-shakra/commit-log/api/v1"
 	"github.com/stretchr/testify/suite"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 )
 
 type LogTestSuite struct {
@@ -69,6 +75,18 @@ func (s *LogTestSuite) TestClose() {
 	s.Require().NoError(err)
 }
 
+func (s *LogTestSuite) TestClosedLog() {
+	off, err := s.log.Append(testProtoRecord)
+	s.Require().NoError(err)
+	s.Require().NoError(s.log.Close())
+	_, err = s.log.Append(testProtoRecord)
+	s.Require().ErrorIs(err, ErrLogClosed{})
+	_, err = s.log.Read(off)
+	s.Require().ErrorIs(err, ErrLogClosed{})
+	s.Require().ErrorIs(s.log.TruncateAfter(0), ErrLogClosed{})
+	s.Require().NoError(s.log.Close())
+}
+
 func (s *LogTestSuite) TestRemove() {
 	err := s.log.Remove()
 	s.Require().NoError(err)
@@ -76,7 +94,475 @@ func (s *LogTestSuite) TestRemove() {
 }
 
 func (s *LogTestSuite) TestReset() {
+	s.appendRecords(3)
 	err := s.log.Reset()
 	s.Require().NoError(err)
 	s.Require().DirExists(s.testDir)
+	s.Require().Equal(1, len(s.log.segments))
+
+	off, err := s.log.Append(&api.Record{Value: testProtoRecord.Value})
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(0), off)
+	ret, err := s.log.Read(off)
+	s.Require().NoError(err)
+	s.Require().Equal(testProtoRecord.Value, ret.Value)
+}
+
+func (s *LogTestSuite) TestAppendRollsFullSegment() {
+	// test segments fit two index entries, so appending five records needs three segments
+	for i := 0; i < 5; i++ {
+		off, err := s.log.Append(&api.Record{Value: testProtoRecord.Value})
+		s.Require().NoError(err)
+		s.Require().Equal(uint64(i), off)
+	}
+	s.Require().Equal(3, len(s.log.segments))
+	for i := uint64(0); i < 5; i++ {
+		ret, err := s.log.Read(i)
+		s.Require().NoError(err)
+		s.Require().Equal(i, ret.Offset)
+	}
+}
+
+func (s *LogTestSuite) TestLowestAndNextOffset() {
+	s.Require().Equal(uint64(0), s.log.LowestOffset())
+	s.Require().Equal(uint64(0), s.log.NextOffset())
+	s.appendRecords(5)
+	s.Require().Equal(uint64(0), s.log.LowestOffset())
+	s.Require().Equal(uint64(5), s.log.NextOffset())
+}
+
+func (s *LogTestSuite) TestOffloadAndReadRemote() {
+	remoteDir := s.setupTieredLog(1)
+	defer os.RemoveAll(remoteDir)
+
+	s.appendRecords(5)
+	err := s.log.Offload()
+	s.Require().NoError(err)
+	// only the active segment stays local
+	s.Require().Equal(1, len(s.log.segments))
+	s.Require().Equal(2, len(s.log.remote))
+	s.Require().NoFileExists(filepath.Join(s.testDir, "0.store"))
+
+	for i := uint64(0); i < 5; i++ {
+		ret, err := s.log.Read(i)
+		s.Require().NoError(err)
+		s.Require().Equal(i, ret.Offset)
+	}
+	// the cache is bounded to a single remote segment
+	s.Require().Equal(1, s.log.cache.lru.Len())
+}
+
+func (s *LogTestSuite) TestConcurrentRemoteReadsAndClose() {
+	remoteDir := s.setupTieredLog(1)
+	defer os.RemoveAll(remoteDir)
+
+	s.appendRecords(5)
+	s.Require().NoError(s.log.Offload())
+
+	// readers of different remote segments share a cache of a single segment
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(off uint64) {
+			defer wg.Done()
+			ret, err := s.log.Read(off)
+			s.Require().NoError(err)
+			s.Require().Equal(off, ret.Offset)
+		}(uint64(i % 4))
+	}
+	wg.Wait()
+
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Require().NoError(s.log.Close())
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *LogTestSuite) TestOffloadRespectsThreshold() {
+	remoteDir, err := os.MkdirTemp("", "log-test-remote")
+	s.Require().NoError(err)
+	defer os.RemoveAll(remoteDir)
+	store, err := NewDirObjectStore(remoteDir)
+	s.Require().NoError(err)
+	err = s.log.Close()
+	s.Require().NoError(err)
+	s.log, err = NewLog(s.testDir,
+		WithSegmentParams(testIndexSize, testStoreSize, testInitialOffset),
+		WithTieredStorage(store, "", time.Hour, 1),
+	)
+	s.Require().NoError(err)
+
+	s.appendRecords(5)
+	err = s.log.Offload()
+	s.Require().NoError(err)
+	s.Require().Equal(3, len(s.log.segments))
+	s.Require().Empty(s.log.remote)
+}
+
+func (s *LogTestSuite) TestOffloadInBackground() {
+	defer func(interval time.Duration) { offloadInterval = interval }(offloadInterval)
+	offloadInterval = 10 * time.Millisecond
+	remoteDir := s.setupTieredLog(1)
+	defer os.RemoveAll(remoteDir)
+
+	s.appendRecords(5)
+	s.Require().Eventually(func() bool {
+		_, err := os.Stat(filepath.Join(s.testDir, "0.store"))
+		return os.IsNotExist(err)
+	}, time.Second, 10*time.Millisecond)
+	ret, err := s.log.Read(0)
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(0), ret.Offset)
+	s.Require().NoError(s.log.Close())
+}
+
+func (s *LogTestSuite) TestReopenWithRemoteSegments() {
+	remoteDir := s.setupTieredLog(2)
+	defer os.RemoveAll(remoteDir)
+
+	s.appendRecords(5)
+	err := s.log.Offload()
+	s.Require().NoError(err)
+	err = s.log.Close()
+	s.Require().NoError(err)
+
+	store, err := NewDirObjectStore(remoteDir)
+	s.Require().NoError(err)
+	s.log, err = NewLog(s.testDir,
+		WithSegmentParams(testIndexSize, testStoreSize, testInitialOffset),
+		WithTieredStorage(store, "topic-", 0, 2),
+	)
+	s.Require().NoError(err)
+	s.Require().Equal([]remoteSegment{{0, 2}, {2, 4}}, s.log.remote)
+	for i := uint64(0); i < 5; i++ {
+		ret, err := s.log.Read(i)
+		s.Require().NoError(err)
+		s.Require().Equal(i, ret.Offset)
+	}
+	off, err := s.log.Append(&api.Record{Value: testProtoRecord.Value})
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(5), off)
+}
+
+func (s *LogTestSuite) TestRemoveDeletesRemoteSegments() {
+	remoteDir := s.setupTieredLog(1)
+	defer os.RemoveAll(remoteDir)
+
+	s.appendRecords(5)
+	err := s.log.Offload()
+	s.Require().NoError(err)
+	err = s.log.Remove()
+	s.Require().NoError(err)
+
+	keys, err := s.log.options.tiering.objectStore.List("")
+	s.Require().NoError(err)
+	s.Require().Empty(keys)
+}
+
+func (s *LogTestSuite) TestTruncateAfter() {
+	s.appendRecords(5)
+	err := s.log.TruncateAfter(2)
+	s.Require().NoError(err)
+	s.Require().Equal(2, len(s.log.segments))
+	s.Require().Equal(uint64(3), s.log.NextOffset())
+	_, err = s.log.Read(3)
+	s.Require().Error(err)
+
+	off, err := s.log.Append(&api.Record{Value: []byte("after truncation")})
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(3), off)
+
+	// truncated records stay gone after reopening the log
+	err = s.log.Close()
+	s.Require().NoError(err)
+	s.log, err = NewLog(s.testDir, WithSegmentParams(testIndexSize, testStoreSize, testInitialOffset))
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(4), s.log.NextOffset())
+	ret, err := s.log.Read(3)
+	s.Require().NoError(err)
+	s.Require().Equal([]byte("after truncation"), ret.Value)
+	s.Require().NoFileExists(filepath.Join(s.testDir, truncateMarkerFile))
+}
+
+func (s *LogTestSuite) TestTruncateAfterPastEndIsNoop() {
+	s.appendRecords(2)
+	err := s.log.TruncateAfter(5)
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(2), s.log.NextOffset())
+}
+
+func (s *LogTestSuite) TestTruncateAfterCompletesOnSetup() {
+	s.appendRecords(5)
+	// a crash right after the marker was written leaves every record in place
+	err := s.log.writeTruncateMarker(0)
+	s.Require().NoError(err)
+	err = s.log.Close()
+	s.Require().NoError(err)
+
+	s.log, err = NewLog(s.testDir, WithSegmentParams(testIndexSize, testStoreSize, testInitialOffset))
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(1), s.log.NextOffset())
+	s.Require().Equal(1, len(s.log.segments))
+	_, err = s.log.Read(1)
+	s.Require().Error(err)
+	s.Require().NoFileExists(filepath.Join(s.testDir, truncateMarkerFile))
+}
+
+func (s *LogTestSuite) TestLogger() {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	err := s.log.Close()
+	s.Require().NoError(err)
+	s.log, err = NewLog(s.testDir, WithSegmentParams(testIndexSize, testStoreSize, testInitialOffset), WithLogger(logger))
+	s.Require().NoError(err)
+	s.appendRecords(5)
+	err = s.log.writeTruncateMarker(0)
+	s.Require().NoError(err)
+	err = s.log.Close()
+	s.Require().NoError(err)
+
+	_, err = NewLog(s.testDir, WithSegmentParams(testIndexSize, testStoreSize, testInitialOffset), WithLogger(logger))
+	s.Require().NoError(err)
+	for _, msg := range []string{
+		`msg="segment opened"`,
+		`msg="segment rolled"`,
+		`msg="completing interrupted truncation" dir=` + s.testDir + ` offset=0`,
+		`msg="segment removed"`,
+	} {
+		s.Require().Contains(buf.String(), msg)
+	}
+}
+
+func (s *LogTestSuite) TestTruncateAfterOffloadedRecords() {
+	s.setupTieredLog(1)
+	s.appendRecords(5)
+	err := s.log.Offload()
+	s.Require().NoError(err)
+
+	err = s.log.TruncateAfter(0)
+	s.Require().ErrorAs(err, &ErrOffsetOutOfRange{})
+	s.Require().Equal(uint64(5), s.log.NextOffset())
+}
+
+// setupTieredLog replaces the suite log with one that offloads sealed segments
+// immediately and returns the directory that backs the object store
+func (s *LogTestSuite) setupTieredLog(cacheSegments int) string {
+	remoteDir, err := os.MkdirTemp("", "log-test-remote")
+	s.Require().NoError(err)
+	store, err := NewDirObjectStore(remoteDir)
+	s.Require().NoError(err)
+
+	err = s.log.Close()
+	s.Require().NoError(err)
+	s.log, err = NewLog(s.testDir,
+		WithSegmentParams(testIndexSize, testStoreSize, testInitialOffset),
+		WithTieredStorage(store, "topic-", 0, cacheSegments),
+	)
+	s.Require().NoError(err)
+	return remoteDir
+}
+
+func (s *LogTestSuite) appendRecords(n int) {
+	for i := 0; i < n; i++ {
+		_, err := s.log.Append(&api.Record{Value: testProtoRecord.Value})
+		s.Require().NoError(err)
+	}
+}
+
+func (s *LogTestSuite) TestObserver() {
+	var events []Event
+	err := s.log.Close()
+	s.Require().NoError(err)
+	s.log, err = NewLog(s.testDir, WithSegmentParams(testIndexSize, testStoreSize, testInitialOffset),
+		WithObserver(ObserverFunc(func(e Event) {
+			events = append(events, e)
+		})))
+	s.Require().NoError(err)
+
+	s.appendRecords(3)
+	err = s.log.TruncateAfter(1)
+	s.Require().NoError(err)
+	// reads are only seen by the built-in observers
+	_, err = s.log.Read(0)
+	s.Require().NoError(err)
+	s.Require().Equal([]Event{
+		{Type: EventAppended, Dir: s.testDir, BaseOffset: 0, Offset: 0},
+		{Type: EventAppended, Dir: s.testDir, BaseOffset: 0, Offset: 1},
+		{Type: EventSegmentRolled, Dir: s.testDir, BaseOffset: 2},
+		{Type: EventAppended, Dir: s.testDir, BaseOffset: 2, Offset: 2},
+		{Type: EventSegmentRemoved, Dir: s.testDir, BaseOffset: 2},
+	}, events)
+}
+
+func (s *LogTestSuite) TestObserverCorruption() {
+	s.appendRecords(1)
+	firstRecordEnd := s.log.activeSegment.store.size
+	s.appendRecords(1)
+	err := s.log.Close()
+	s.Require().NoError(err)
+	// a crash before the last record reached the store leaves only its index entry
+	err = os.Truncate(filepath.Join(s.testDir, "0"+storeExt), int64(firstRecordEnd))
+	s.Require().NoError(err)
+
+	var events []Event
+	s.log, err = NewLog(s.testDir, WithSegmentParams(testIndexSize, testStoreSize, testInitialOffset),
+		WithObserver(ObserverFunc(func(e Event) {
+			events = append(events, e)
+		})))
+	s.Require().NoError(err)
+	s.Require().Len(events, 1)
+	s.Require().Equal(EventCorruption, events[0].Type)
+	s.Require().Equal(uint64(1), events[0].Offset)
+	s.Require().Error(events[0].Err)
+	s.Require().Equal(uint64(1), s.log.NextOffset())
+}
+
+func (s *LogTestSuite) TestAsyncObserver() {
+	events := make(chan Event, 10)
+	err := s.log.Close()
+	s.Require().NoError(err)
+	// the observer reads the log back, which a synchronous observer may not do
+	var reads []uint64
+	var observed *Log
+	s.log, err = NewLog(s.testDir, WithSegmentParams(testIndexSize, testStoreSize, testInitialOffset),
+		WithAsyncObserver(ObserverFunc(func(e Event) {
+			if e.Type == EventAppended {
+				rec, err := observed.Read(e.Offset)
+				if err == nil {
+					reads = append(reads, rec.Offset)
+				}
+			}
+			events <- e
+		})))
+	s.Require().NoError(err)
+	observed = s.log
+
+	s.appendRecords(3)
+	err = s.log.Close()
+	s.Require().NoError(err)
+	// every queued event was observed once Close returned
+	s.Require().Len(events, 4)
+	s.Require().Equal([]uint64{0, 1, 2}, reads)
+}
+
+func (s *LogTestSuite) TestIdempotentAppend() {
+	appendSeq := func(l *Log, sequence uint64) (uint64, error) {
+		return l.Append(&api.Record{Value: testProtoRecord.Value, ProducerId: "producer", Sequence: sequence})
+	}
+	for i, sequence := range []uint64{5, 6, 7} {
+		off, err := appendSeq(s.log, sequence)
+		s.Require().NoError(err)
+		s.Require().Equal(uint64(i), off)
+	}
+	// a retry returns the offset of the record stored first
+	off, err := appendSeq(s.log, 6)
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(1), off)
+	s.Require().Equal(uint64(3), s.log.NextOffset())
+
+	_, err = appendSeq(s.log, 9)
+	s.Require().ErrorIs(err, ErrOutOfOrderSequence{ProducerID: "producer", Sequence: 9, Expected: 8})
+	// records without a producer are never deduplicated
+	_, err = s.log.Append(&api.Record{Value: testProtoRecord.Value})
+	s.Require().NoError(err)
+
+	// the window is rebuilt from the records when the log is opened
+	err = s.log.Close()
+	s.Require().NoError(err)
+	s.log, err = NewLog(s.testDir, WithSegmentParams(testIndexSize, testStoreSize, testInitialOffset),
+		WithProducerWindow(2))
+	s.Require().NoError(err)
+	off, err = appendSeq(s.log, 7)
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(2), off)
+	// sequence 5 fell out of the window of two records
+	_, err = appendSeq(s.log, 5)
+	s.Require().ErrorAs(err, &ErrOutOfOrderSequence{})
+
+	// truncated records are stored again when they are retried
+	err = s.log.TruncateAfter(1)
+	s.Require().NoError(err)
+	off, err = appendSeq(s.log, 7)
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(2), off)
+	s.Require().Equal(uint64(3), s.log.NextOffset())
+}
+
+func (s *LogTestSuite) TestAppendReplica() {
+	record := &api.Record{Value: testProtoRecord.Value, ProducerId: "producer", Sequence: 1}
+	first, err := s.log.Append(record)
+	s.Require().NoError(err)
+	// a replica stores what the leader accepted, even a record it would deduplicate
+	second, err := s.log.AppendReplica(record)
+	s.Require().NoError(err)
+	s.Require().Equal(first+1, second)
+	_, err = s.log.AppendReplica(&api.Record{Value: testProtoRecord.Value, ProducerId: "producer", Sequence: 9})
+	s.Require().NoError(err)
+}
+
+func (s *LogTestSuite) TestAppended() {
+	appended := s.log.Appended()
+	select {
+	case <-appended:
+		s.Fail("appended before any append")
+	default:
+	}
+	s.appendRecords(1)
+	<-appended
+
+	appended = s.log.Appended()
+	s.Require().NoError(s.log.Close())
+	<-appended
+}
+
+func (s *LogTestSuite) TestSync() {
+	off, err := s.log.Append(testProtoRecord)
+	s.Require().NoError(err)
+	store := s.log.activeSegment.store
+	fi, err := os.Stat(store.Name())
+	s.Require().NoError(err)
+	s.Require().Zero(fi.Size())
+
+	s.Require().NoError(s.log.Sync())
+	fi, err = os.Stat(store.Name())
+	s.Require().NoError(err)
+	s.Require().Equal(int64(store.size), fi.Size())
+	rec, err := s.log.Read(off)
+	s.Require().NoError(err)
+	s.Require().Equal(testProtoRecord.Value, rec.Value)
+}
+
+func (s *LogTestSuite) TestTransactions() {
+	appendTxn := func(id string, typ api.RecordType) uint64 {
+		off, err := s.log.Append(&api.Record{Value: testProtoRecord.Value, TransactionId: id, Type: typ})
+		s.Require().NoError(err)
+		return off
+	}
+	s.appendRecords(1)
+	s.Require().Equal(uint64(1), s.log.LastStableOffset())
+	appendTxn("committed", api.RecordType_RECORD_TYPE_DATA)
+	appendTxn("aborted", api.RecordType_RECORD_TYPE_DATA)
+	appendTxn("committed", api.RecordType_RECORD_TYPE_DATA)
+	// the oldest open transaction holds the stable offset back
+	s.Require().Equal(uint64(1), s.log.LastStableOffset())
+
+	appendTxn("committed", api.RecordType_RECORD_TYPE_COMMIT)
+	s.Require().Equal(uint64(2), s.log.LastStableOffset())
+	appendTxn("aborted", api.RecordType_RECORD_TYPE_ABORT)
+	s.Require().Equal(s.log.NextOffset(), s.log.LastStableOffset())
+	s.Require().True(s.log.Aborted("aborted"))
+	s.Require().False(s.log.Aborted("committed"))
+
+	// the transactions are rebuilt from the records when the log is opened
+	appendTxn("open", api.RecordType_RECORD_TYPE_DATA)
+	err := s.log.Close()
+	s.Require().NoError(err)
+	s.log, err = NewLog(s.testDir, WithSegmentParams(testIndexSize, testStoreSize, testInitialOffset))
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(6), s.log.LastStableOffset())
+	s.Require().True(s.log.Aborted("aborted"))
 }
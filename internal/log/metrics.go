@@ -0,0 +1,76 @@
+package log
+
+import (
+	"github.com/a-shakra/commit-log/internal/metrics"
+)
+
+// logBuckets span the latencies of appends and reads, which mostly hit the page cache
+var logBuckets = metrics.ExponentialBuckets(0.00001, 4, 10)
+
+var (
+	appendSeconds = metrics.Default.NewHistogram(
+		"commitlog_log_append_seconds", "Latency of appends to a log.", logBuckets)
+	appendErrors = metrics.Default.NewCounter(
+		"commitlog_log_append_errors_total", "Appends to a log that failed.")
+	appendedBytes = metrics.Default.NewCounter(
+		"commitlog_log_appended_bytes_total", "Bytes of record values appended to logs.")
+	duplicateAppends = metrics.Default.NewCounter(
+		"commitlog_log_duplicate_appends_total", "Retried appends of idempotent producers that were not stored again.")
+	readSeconds = metrics.Default.NewHistogram(
+		"commitlog_log_read_seconds", "Latency of reads from a log.", logBuckets)
+	readErrors = metrics.Default.NewCounter(
+		"commitlog_log_read_errors_total", "Reads from a log that failed, including offsets out of range.")
+	segmentRolls = metrics.Default.NewCounter(
+		"commitlog_log_segment_rolls_total", "Segments rolled because the active segment was full.")
+	segmentCount = metrics.Default.NewGauge(
+		"commitlog_log_segments", "Local segments of a log.", "dir")
+	sizeBytes = metrics.Default.NewGauge(
+		"commitlog_log_size_bytes", "Bytes of the local store and index files of a log.", "dir")
+)
+
+// metricsObserver records the metrics of every Log
+type metricsObserver struct{}
+
+func (metricsObserver) observe(e operation) {
+	switch e.Type {
+	case EventAppended:
+		appendSeconds.Observe(e.duration.Seconds())
+		appendedBytes.Add(float64(e.size))
+	case eventDuplicateAppend:
+		appendSeconds.Observe(e.duration.Seconds())
+		duplicateAppends.Inc()
+	case eventAppendFailed:
+		appendSeconds.Observe(e.duration.Seconds())
+		appendErrors.Inc()
+	case eventRead:
+		readSeconds.Observe(e.duration.Seconds())
+		if e.Err != nil {
+			readErrors.Inc()
+		}
+	case EventSegmentRolled:
+		segmentRolls.Inc()
+	}
+}
+
+// registerGauges reports the segments and size of the log when metrics are scraped
+func (l *Log) registerGauges() {
+	segmentCount.SetFunc(func() float64 {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		return float64(len(l.segments))
+	}, l.Dir)
+	sizeBytes.SetFunc(func() float64 {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		var size uint64
+		for _, s := range l.segments {
+			size += s.store.size + s.index.size
+		}
+		return float64(size)
+	}, l.Dir)
+}
+
+func (l *Log) unregisterGauges() {
+	segmentCount.Delete(l.Dir)
+	sizeBytes.Delete(l.Dir)
+}
@@ -0,0 +1,121 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ObjectStore is the minimal interface of a blob store that sealed
+// segments can be offloaded to. Keys are flat names without any
+// directory component.
+type ObjectStore interface {
+	Put(key string, r io.Reader) error
+	Get(key string) (io.ReadCloser, error)
+	List(prefix string) ([]string, error)
+	Delete(key string) error
+}
+
+// guarantee *DirObjectStore meets ObjectStore interface at compile time
+var _ ObjectStore = &DirObjectStore{}
+
+const tmpObjectSuffix = ".tmp"
+
+// DirObjectStore is an ObjectStore that keeps every object as a file in a
+// local directory. It is mainly meant for testing and single host setups
+type DirObjectStore struct {
+	dir string
+}
+
+// NewDirObjectStore returns an ObjectStore backed by the given directory,
+// creating the directory if it does not exist yet
+func NewDirObjectStore(dir string) (*DirObjectStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DirObjectStore{dir: dir}, nil
+}
+
+// Put stores the content of r under key. The object is written to a
+// temporary file first and renamed so that readers never see partial objects
+func (d *DirObjectStore) Put(key string, r io.Reader) error {
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(d.dir, key+"-*"+tmpObjectSuffix)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// Get returns a reader over the object stored under key
+func (d *DirObjectStore) Get(key string) (io.ReadCloser, error) {
+	p, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", key, ErrObjectNotFound)
+	}
+	return f, err
+}
+
+// List returns the sorted keys of all objects that start with prefix
+func (d *DirObjectStore) List(prefix string) ([]string, error) {
+	files, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || strings.HasSuffix(name, tmpObjectSuffix) {
+			continue
+		}
+		if strings.HasPrefix(name, prefix) {
+			keys = append(keys, name)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Delete removes the object stored under key. Deleting a missing object is not an error
+func (d *DirObjectStore) Delete(key string) error {
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (d *DirObjectStore) path(key string) (string, error) {
+	if key == "" || key != filepath.Base(key) || key == "." || key == ".." {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(d.dir, key), nil
+}
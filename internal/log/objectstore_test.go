@@ -0,0 +1,76 @@
+package log
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"os"
+	"testing"
+)
+
+type ObjectStoreTestSuite struct {
+	suite.Suite
+	testDir string
+	store   *DirObjectStore
+}
+
+func TestObjectStoreTestSuite(t *testing.T) {
+	suite.Run(t, &ObjectStoreTestSuite{})
+}
+
+func (s *ObjectStoreTestSuite) SetupTest() {
+	dir, err := os.MkdirTemp("", "object-store-test")
+	s.Require().NoError(err)
+	s.testDir = dir
+	s.store, err = NewDirObjectStore(dir)
+	s.Require().NoError(err)
+}
+
+func (s *ObjectStoreTestSuite) TearDownTest() {
+	err := os.RemoveAll(s.testDir)
+	s.Require().NoError(err)
+}
+
+func (s *ObjectStoreTestSuite) TestPutGet() {
+	err := s.store.Put("0.store", bytes.NewReader(testRecord))
+	s.Require().NoError(err)
+
+	r, err := s.store.Get("0.store")
+	s.Require().NoError(err)
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	s.Require().NoError(err)
+	s.Require().Equal(testRecord, b)
+}
+
+func (s *ObjectStoreTestSuite) TestGetMissingThenFail() {
+	_, err := s.store.Get("missing")
+	s.Require().ErrorIs(err, ErrObjectNotFound)
+}
+
+func (s *ObjectStoreTestSuite) TestList() {
+	for _, key := range []string{"a-1", "a-0", "b-0"} {
+		err := s.store.Put(key, bytes.NewReader(testRecord))
+		s.Require().NoError(err)
+	}
+	keys, err := s.store.List("a-")
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"a-0", "a-1"}, keys)
+}
+
+func (s *ObjectStoreTestSuite) TestDelete() {
+	err := s.store.Put("0.index", bytes.NewReader(testRecord))
+	s.Require().NoError(err)
+	err = s.store.Delete("0.index")
+	s.Require().NoError(err)
+	_, err = s.store.Get("0.index")
+	s.Require().ErrorIs(err, ErrObjectNotFound)
+	// deleting twice is not an error
+	err = s.store.Delete("0.index")
+	s.Require().NoError(err)
+}
+
+func (s *ObjectStoreTestSuite) TestInvalidKeyThenFail() {
+	err := s.store.Put("../escape", bytes.NewReader(testRecord))
+	s.Require().Error(err)
+}
@@ -0,0 +1,242 @@
+package log
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// EventType identifies what happened inside a Log
+type EventType int
+
+const (
+	// EventSegmentRolled is emitted when a new active segment replaced a full one
+	EventSegmentRolled EventType = iota
+	// EventSegmentRemoved is emitted when a segment was deleted by a truncation
+	// or after it was offloaded to the object store
+	EventSegmentRemoved
+	// EventAppended is emitted for every record once it was stored
+	EventAppended
+	// EventCorruption is emitted when data that cannot be read was found, such as
+	// index entries of records that were not fully written before a crash
+	EventCorruption
+)
+
+// operations of the Log that only its built-in observers see, as they are
+// emitted for every call rather than for what changed in the Log
+const (
+	eventAppendFailed EventType = -1 - iota
+	eventDuplicateAppend
+	eventRead
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSegmentRolled:
+		return "segment_rolled"
+	case EventSegmentRemoved:
+		return "segment_removed"
+	case EventAppended:
+		return "appended"
+	case EventCorruption:
+		return "corruption"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes what happened inside the Log stored in Dir
+type Event struct {
+	Type EventType
+	Dir  string
+	// BaseOffset is the base offset of the segment the event happened in
+	BaseOffset uint64
+	// Offset is the offset of the appended record, or of the corrupted record when it is known
+	Offset uint64
+	// Offloaded is set when the removed segment is kept in the object store
+	Offloaded bool
+	// Err describes a corruption
+	Err error
+}
+
+// operation is an Event along with what the built-in observers measure of it
+type operation struct {
+	Event
+	// size is the size of the appended value
+	size     int
+	duration time.Duration
+}
+
+// builtinObserver is notified of every operation of a Log, including the
+// ones emitted for every call that the observers of users do not see
+type builtinObserver interface {
+	observe(op operation)
+}
+
+// Observer is notified of the events of a Log it was registered with through
+// WithObserver or WithAsyncObserver
+type Observer interface {
+	Observe(e Event)
+}
+
+// ObserverFunc lets a function be used as an Observer
+type ObserverFunc func(e Event)
+
+func (f ObserverFunc) Observe(e Event) {
+	f(e)
+}
+
+// asyncObserver hands events to its Observer from a goroutine of its own. Its
+// queue is unbounded, since waiting for room while the Log is locked would
+// deadlock an observer that reads the Log
+type asyncObserver struct {
+	observer Observer
+
+	mu      sync.Mutex
+	queue   []Event
+	running bool
+	// wake is signalled when events were queued or the observer stopped
+	wake chan struct{}
+	done chan struct{}
+}
+
+func (o *asyncObserver) Observe(e Event) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.running {
+		return
+	}
+	o.queue = append(o.queue, e)
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *asyncObserver) start() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.running {
+		return
+	}
+	o.running = true
+	o.wake = make(chan struct{}, 1)
+	o.done = make(chan struct{})
+	go o.run(o.wake, o.done)
+}
+
+func (o *asyncObserver) run(wake <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	for {
+		o.mu.Lock()
+		events, running := o.queue, o.running
+		o.queue = nil
+		o.mu.Unlock()
+		for _, e := range events {
+			o.observer.Observe(e)
+		}
+		if !running && len(events) == 0 {
+			return
+		}
+		if len(events) == 0 {
+			<-wake
+		}
+	}
+}
+
+// stop waits until every queued event was observed, events emitted afterwards are dropped
+func (o *asyncObserver) stop() {
+	o.mu.Lock()
+	if !o.running {
+		o.mu.Unlock()
+		return
+	}
+	o.running = false
+	done := o.done
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	o.mu.Unlock()
+	<-done
+}
+
+// WithObserver calls o synchronously for every event of the Log, while the
+// Log is locked. Corruption found by concurrent reads is observed concurrently.
+// Observers must not call the Log back
+func WithObserver(o Observer) Options {
+	return func(options *options) error {
+		if o == nil {
+			return errors.New("observer should not be nil")
+		}
+		options.observers = append(options.observers, o)
+		return nil
+	}
+}
+
+// WithAsyncObserver calls o for every event of the Log, in order, from a
+// goroutine of its own, so it may read the Log, such as to archive appended
+// records. Events queue up while o is busy and are all observed before Close returns
+func WithAsyncObserver(o Observer) Options {
+	return func(options *options) error {
+		if o == nil {
+			return errors.New("observer should not be nil")
+		}
+		options.observers = append(options.observers, &asyncObserver{observer: o})
+		return nil
+	}
+}
+
+func (l *Log) emit(e Event) {
+	l.emitOperation(operation{Event: e})
+}
+
+func (l *Log) emitOperation(op operation) {
+	op.Dir = l.Dir
+	for _, o := range l.builtin {
+		o.observe(op)
+	}
+	if op.Type < 0 {
+		return
+	}
+	for _, o := range l.options.observers {
+		o.Observe(op.Event)
+	}
+}
+
+// loggingObserver logs the changes of a Log
+type loggingObserver struct {
+	logger *slog.Logger
+}
+
+func (o loggingObserver) observe(e operation) {
+	switch e.Type {
+	case EventSegmentRolled:
+		o.logger.Info("segment rolled", "base_offset", e.BaseOffset)
+	case EventSegmentRemoved:
+		if e.Offloaded {
+			o.logger.Info("segment offloaded", "base_offset", e.BaseOffset)
+		} else {
+			o.logger.Info("segment removed", "base_offset", e.BaseOffset)
+		}
+	case EventCorruption:
+		o.logger.Warn("corruption found", "base_offset", e.BaseOffset, "offset", e.Offset, "error", e.Err)
+	}
+}
+
+func (l *Log) startObservers() {
+	for _, o := range l.options.observers {
+		if o, ok := o.(*asyncObserver); ok {
+			o.start()
+		}
+	}
+}
+
+func (l *Log) stopObservers() {
+	for _, o := range l.options.observers {
+		if o, ok := o.(*asyncObserver); ok {
+			o.stop()
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package log
+
+import (
+	api "github.com/a-shakra/commit-log/api/v1"
+)
+
+// defaultProducerWindow is how many of its latest records are remembered per producer
+var defaultProducerWindow = 16
+
+type producerEntry struct {
+	sequence uint64
+	offset   uint64
+}
+
+// producers is the dedup window of the idempotent producers of a Log. It lives
+// in memory only, the producer id and sequence of every record are stored in
+// the record itself so the window is rebuilt by scanning the log
+type producers struct {
+	window int
+	// latest holds the latest records of every producer, oldest first
+	latest map[string][]producerEntry
+}
+
+func newProducers(window int) *producers {
+	return &producers{window: window, latest: make(map[string][]producerEntry)}
+}
+
+// check returns the offset of the record when it was appended before, ok is
+// false when the record is new and should be appended
+func (p *producers) check(record *api.Record) (off uint64, ok bool, err error) {
+	entries := p.latest[record.ProducerId]
+	if len(entries) == 0 {
+		// producers start their sequences anywhere
+		return 0, false, nil
+	}
+	last := entries[len(entries)-1]
+	if record.Sequence == last.sequence+1 {
+		return 0, false, nil
+	}
+	if record.Sequence <= last.sequence {
+		for _, e := range entries {
+			if e.sequence == record.Sequence {
+				return e.offset, true, nil
+			}
+		}
+	}
+	return 0, false, ErrOutOfOrderSequence{
+		ProducerID: record.ProducerId,
+		Sequence:   record.Sequence,
+		Expected:   last.sequence + 1,
+	}
+}
+
+func (p *producers) add(record *api.Record, off uint64) {
+	if record.ProducerId == "" {
+		return
+	}
+	entries := append(p.latest[record.ProducerId], producerEntry{sequence: record.Sequence, offset: off})
+	if len(entries) > p.window {
+		entries = entries[len(entries)-p.window:]
+	}
+	p.latest[record.ProducerId] = entries
+}
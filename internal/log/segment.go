@@ -9,6 +9,11 @@ import (
 	"path"
 )
 
+const (
+	storeExt = ".store"
+	indexExt = ".index"
+)
+
 type segment struct {
 	store             *store
 	index             *index
@@ -17,6 +22,8 @@ type segment struct {
 	maxIndexSizeBytes uint64
 	maxStoreSizeBytes uint64
 	isFull            bool
+	// trimmedEntries counts the index entries dropped on open because their record was not fully written
+	trimmedEntries uint64
 }
 
 func newSegment(dir string, baseOffset uint64, opts *segmentOptions) (*segment, error) {
@@ -43,7 +50,7 @@ func newSegment(dir string, baseOffset uint64, opts *segmentOptions) (*segment,
 
 	// initialize store
 	sFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".store")),
+		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, storeExt)),
 		os.O_RDWR|os.O_CREATE|os.O_APPEND, // O_APPEND sets the file pointer to end of file to facilitate append operation
 		0644,
 	)
@@ -57,7 +64,7 @@ func newSegment(dir string, baseOffset uint64, opts *segmentOptions) (*segment,
 
 	// initialize index
 	iFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".index")),
+		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, indexExt)),
 		os.O_RDWR|os.O_CREATE,
 		0644,
 	)
@@ -68,6 +75,7 @@ func newSegment(dir string, baseOffset uint64, opts *segmentOptions) (*segment,
 	if err != nil {
 		return nil, err
 	}
+	s.trimmedEntries = s.index.trim(s.store.size)
 
 	// get last offset if existing file, otherwise next offset is the base offset
 	if off, _, err := s.index.Read(-1); err != nil {
@@ -127,6 +135,37 @@ func (s *segment) Read(off uint64) (*api.Record, error) {
 	return &record, nil
 }
 
+// truncateAfter removes every record of the segment with an offset greater than off.
+// The store is cut before the index so that no index entry outlives its record
+func (s *segment) truncateAfter(off uint64) error {
+	keep := off + 1 - s.baseOffset
+	if s.baseOffset+keep >= s.nextOffset {
+		return nil
+	}
+	_, pos, err := s.index.Read(int64(keep))
+	if err != nil {
+		return err
+	}
+	if err = s.store.truncate(pos); err != nil {
+		return err
+	}
+	if err = s.index.truncate(keep); err != nil {
+		return err
+	}
+	s.nextOffset = s.baseOffset + keep
+	s.isFull = false
+	return nil
+}
+
+// sync commits the records of the segment to stable storage, the store
+// before the index so that no index entry outlives its record
+func (s *segment) sync() error {
+	if err := s.store.sync(); err != nil {
+		return err
+	}
+	return s.index.sync()
+}
+
 // Close closes the open resources consumed by the index and store objects of the segment
 func (s *segment) Close() error {
 	if err := s.index.Close(); err != nil {
@@ -83,3 +83,40 @@ func (s *SegmentTestSuite) TestAppendExceededStoreSize() {
 	s.Require().ErrorIs(err, ErrFileFull)
 	s.Require().Equal(true, s.seg.IsFull())
 }
+
+func (s *SegmentTestSuite) TestTruncateAfter() {
+	for i := 0; i < 2; i++ {
+		_, err := s.seg.Append(testProtoRecord)
+		s.Require().NoError(err)
+	}
+	err := s.seg.truncateAfter(testBaseOffset)
+	s.Require().NoError(err)
+	s.Require().Equal(testBaseOffset+1, s.seg.nextOffset)
+	_, err = s.seg.Read(testBaseOffset + 1)
+	s.Require().Error(err)
+
+	off, err := s.seg.Append(testProtoRecord)
+	s.Require().NoError(err)
+	s.Require().Equal(testBaseOffset+1, off)
+}
+
+func (s *SegmentTestSuite) TestReopenTrimsEntriesPastStore() {
+	for i := 0; i < 2; i++ {
+		_, err := s.seg.Append(testProtoRecord)
+		s.Require().NoError(err)
+	}
+	// a crash after the store was cut leaves index entries without records
+	_, pos, err := s.seg.index.Read(1)
+	s.Require().NoError(err)
+	err = s.seg.store.truncate(pos)
+	s.Require().NoError(err)
+	err = s.seg.Close()
+	s.Require().NoError(err)
+
+	s.seg, err = newSegment(s.testDir,
+		testBaseOffset,
+		&segmentOptions{maxIndexSizeBytes: &testIndexSize, maxStoreSizeBytes: &testStoreSize},
+	)
+	s.Require().NoError(err)
+	s.Require().Equal(testBaseOffset+1, s.seg.nextOffset)
+}
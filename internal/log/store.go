@@ -112,6 +112,46 @@ func (s *store) ReadAt(p []byte, position int64) (int, error) {
 	return n, nil
 }
 
+// truncate discards every byte of the store from the given position onwards
+func (s *store) truncate(position uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if position >= s.size {
+		return nil
+	}
+	if err := s.file.Truncate(int64(position)); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.size = position
+	return nil
+}
+
+// flush writes any buffered records to the store file
+func (s *store) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.buf.Flush()
+}
+
+// sync writes any buffered records to the store file and commits the file to stable storage
+func (s *store) sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
 // Close makes sure that the buffer has flushed data to file before closing the file
 func (s *store) Close() error {
 	s.mu.Lock()
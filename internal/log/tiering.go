@@ -0,0 +1,273 @@
+package log
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const segmentCacheDir = "cache"
+
+// offloadInterval is how often a Log with tiered storage offloads its segments in the background
+var offloadInterval = 10 * time.Second
+
+// remoteSegment describes a sealed segment whose files only exist in the object store
+type remoteSegment struct {
+	baseOffset uint64
+	nextOffset uint64
+}
+
+// segmentKey returns the object key of a segment file with the given extension
+func segmentKey(prefix string, baseOffset uint64, ext string) string {
+	return fmt.Sprintf("%s%d%s", prefix, baseOffset, ext)
+}
+
+// listRemoteBaseOffsets returns the base offsets of all segments that were
+// completely uploaded to the object store, i.e. both index and store exist
+func listRemoteBaseOffsets(opts *tieringOptions) (map[uint64]bool, error) {
+	keys, err := opts.objectStore.List(opts.keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[uint64]int)
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, opts.keyPrefix)
+		ext := path.Ext(name)
+		if ext != storeExt && ext != indexExt {
+			continue
+		}
+		off, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+		if err != nil {
+			continue
+		}
+		found[off]++
+	}
+	bases := make(map[uint64]bool)
+	for off, n := range found {
+		if n == 2 {
+			bases[off] = true
+		}
+	}
+	return bases, nil
+}
+
+// sealedBefore reports whether the segment was last written to before the threshold
+func (s *segment) sealedBefore(threshold time.Duration) (bool, error) {
+	fInfo, err := os.Stat(s.store.Name())
+	if err != nil {
+		return false, err
+	}
+	return time.Since(fInfo.ModTime()) >= threshold, nil
+}
+
+// upload copies the index and store of the segment into the object store.
+// The index is uploaded first and the store last so that a segment is only
+// listed as remote once its data is complete
+func (s *segment) upload(opts *tieringOptions) error {
+	if err := s.index.sync(); err != nil {
+		return err
+	}
+	if err := opts.objectStore.Put(
+		segmentKey(opts.keyPrefix, s.baseOffset, indexExt),
+		bytes.NewReader(s.index.mmap[:s.index.size]),
+	); err != nil {
+		return err
+	}
+	if err := s.store.flush(); err != nil {
+		return err
+	}
+	return opts.objectStore.Put(
+		segmentKey(opts.keyPrefix, s.baseOffset, storeExt),
+		io.NewSectionReader(s.store.file, 0, int64(s.store.size)),
+	)
+}
+
+// segmentCache keeps a bounded number of remote segments available locally.
+// Least recently read segments are evicted first. Segments are downloaded
+// without the lock held, so reads of cached segments are not blocked by the object store
+type segmentCache struct {
+	mu       sync.Mutex
+	dir      string
+	opts     *tieringOptions
+	segOpts  *segmentOptions
+	lru      *list.List
+	segments map[uint64]*list.Element
+	// downloads holds the segments being downloaded, concurrent reads of one wait for it
+	downloads map[uint64]*download
+	closed    bool
+}
+
+// download is closed once the segment is cached or failed with err
+type download struct {
+	done chan struct{}
+	err  error
+}
+
+func newSegmentCache(dir string, opts *tieringOptions, segOpts *segmentOptions) (*segmentCache, error) {
+	// the cache only ever holds copies of remote data so stale files are dropped
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &segmentCache{
+		dir:       dir,
+		opts:      opts,
+		segOpts:   segOpts,
+		lru:       list.New(),
+		segments:  make(map[uint64]*list.Element),
+		downloads: make(map[uint64]*download),
+	}, nil
+}
+
+// Read returns the record at the given offset of a remote segment,
+// downloading the segment into the cache if it is not present
+func (c *segmentCache) Read(rs remoteSegment, off uint64) (*api.Record, error) {
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return nil, ErrLogClosed{}
+		}
+		if e, ok := c.segments[rs.baseOffset]; ok {
+			c.lru.MoveToFront(e)
+			rec, err := e.Value.(*segment).Read(off)
+			c.mu.Unlock()
+			return rec, err
+		}
+		if d, ok := c.downloads[rs.baseOffset]; ok {
+			c.mu.Unlock()
+			<-d.done
+			if d.err != nil {
+				return nil, d.err
+			}
+			continue
+		}
+		d := &download{done: make(chan struct{})}
+		c.downloads[rs.baseOffset] = d
+		c.mu.Unlock()
+
+		seg, err := c.fetch(rs)
+		c.mu.Lock()
+		if err == nil {
+			err = c.add(seg)
+		}
+		delete(c.downloads, rs.baseOffset)
+		d.err = err
+		close(d.done)
+		c.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fetch downloads the files of a remote segment and opens it
+func (c *segmentCache) fetch(rs remoteSegment) (*segment, error) {
+	for _, ext := range []string{indexExt, storeExt} {
+		if err := c.download(segmentKey(c.opts.keyPrefix, rs.baseOffset, ext),
+			filepath.Join(c.dir, fmt.Sprintf("%d%s", rs.baseOffset, ext))); err != nil {
+			return nil, err
+		}
+	}
+	return newSegment(c.dir, rs.baseOffset, c.segOpts)
+}
+
+// add must be called with the lock held, it caches seg and evicts the least recently read segments
+func (c *segmentCache) add(seg *segment) error {
+	if c.closed {
+		return errors.Join(ErrLogClosed{}, seg.Remove())
+	}
+	c.segments[seg.baseOffset] = c.lru.PushFront(seg)
+	for c.lru.Len() > c.opts.cacheSegments {
+		if err := c.evict(c.lru.Back()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *segmentCache) download(key, dst string) error {
+	r, err := c.opts.objectStore.Get(key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (c *segmentCache) evict(e *list.Element) error {
+	seg := c.lru.Remove(e).(*segment)
+	delete(c.segments, seg.baseOffset)
+	return seg.Remove()
+}
+
+// Close evicts every cached segment and removes the cache directory
+func (c *segmentCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for c.lru.Len() > 0 {
+		if err := c.evict(c.lru.Back()); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(c.dir)
+}
+
+// startOffloader calls Offload periodically until the Log is closed, when tiered storage is enabled
+func (l *Log) startOffloader() {
+	if l.options.tiering == nil {
+		return
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go l.offload(offloadInterval, stop, done)
+	// Close may be called concurrently, every caller waits for the offloader to stop
+	l.stopOffload = sync.OnceFunc(func() {
+		close(stop)
+		<-done
+	})
+}
+
+func (l *Log) offload(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if err := l.Offload(); err != nil {
+			l.logger.Error("segment offload failed", "error", err)
+		}
+	}
+}
+
+// stopOffloader waits for a running Offload to finish, it must be called without the lock held
+func (l *Log) stopOffloader() {
+	if l.stopOffload != nil {
+		l.stopOffload()
+	}
+}
@@ -0,0 +1,71 @@
+package log
+
+import (
+	api "github.com/a-shakra/commit-log/api/v1"
+)
+
+// transactions tracks the transactions that wrote to a Log. Like the dedup
+// window of producers it is rebuilt by scanning the log, from the transaction
+// ids of data records and from the commit and abort markers
+type transactions struct {
+	// open maps the id of every transaction without a marker to the offset of its first record
+	open map[string]uint64
+	// aborted holds the ids of the transactions that were aborted after writing to the log
+	aborted map[string]bool
+}
+
+func newTransactions() *transactions {
+	return &transactions{open: make(map[string]uint64), aborted: make(map[string]bool)}
+}
+
+func (t *transactions) add(record *api.Record, off uint64) {
+	id := record.TransactionId
+	if id == "" {
+		return
+	}
+	switch record.Type {
+	case api.RecordType_RECORD_TYPE_DATA:
+		if _, ok := t.open[id]; !ok && !t.aborted[id] {
+			t.open[id] = off
+		}
+	case api.RecordType_RECORD_TYPE_COMMIT:
+		delete(t.open, id)
+	case api.RecordType_RECORD_TYPE_ABORT:
+		// markers of transactions that did not write to the log are not remembered
+		if _, ok := t.open[id]; ok {
+			delete(t.open, id)
+			t.aborted[id] = true
+		}
+	}
+}
+
+// LastStableOffset returns the offset of the first record of the oldest open
+// transaction, or the next offset when no transaction is open. Every record
+// before it is either outside of a transaction or its transaction was committed
+// or aborted
+func (l *Log) LastStableOffset() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	stable := l.activeSegment.nextOffset
+	for _, off := range l.transactions.open {
+		stable = min(stable, off)
+	}
+	return stable
+}
+
+// Aborted reports whether the transaction with the given id was aborted
+func (l *Log) Aborted(transactionID string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.transactions.aborted[transactionID]
+}
+
+// Ongoing reports whether the transaction with the given id wrote records to
+// the log that no marker ended yet
+func (l *Log) Ongoing(transactionID string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.transactions.open[transactionID]
+	return ok
+}
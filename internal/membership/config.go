@@ -0,0 +1,89 @@
+package membership
+
+import (
+	"errors"
+	"google.golang.org/grpc"
+	"time"
+)
+
+var (
+	defaultHeartbeatInterval = time.Second
+	defaultSuspectTimeout    = 3 * time.Second
+	defaultDeadTimeout       = 10 * time.Second
+	defaultEventBuffer       = 64
+)
+
+type options struct {
+	heartbeatInterval *time.Duration
+	suspectTimeout    *time.Duration
+	deadTimeout       *time.Duration
+	eventBuffer       *int
+	isLeader          func() bool
+	dialOptions       []grpc.DialOption
+}
+
+type Options func(options *options) error
+
+// WithTimeouts sets how often heartbeats are sent to the other members and
+// how long a member may stay silent before it is suspected and then declared dead
+func WithTimeouts(heartbeatInterval time.Duration, suspectTimeout time.Duration, deadTimeout time.Duration) Options {
+	return func(options *options) error {
+		if heartbeatInterval <= 0 || suspectTimeout <= heartbeatInterval || deadTimeout <= suspectTimeout {
+			return errors.New("timeouts should grow from a positive heartbeat interval to the dead timeout")
+		}
+		options.heartbeatInterval = &heartbeatInterval
+		options.suspectTimeout = &suspectTimeout
+		options.deadTimeout = &deadTimeout
+		return nil
+	}
+}
+
+// WithEventBuffer sets how many events may be queued for a subscriber
+// before further events are dropped for it
+func WithEventBuffer(n int) Options {
+	return func(options *options) error {
+		if n < 1 {
+			return errors.New("event buffer should hold at least one event")
+		}
+		options.eventBuffer = &n
+		return nil
+	}
+}
+
+// WithLeaderFunc sets how the member finds out whether it currently leads
+// the cluster. Members are followers when it is not set
+func WithLeaderFunc(fn func() bool) Options {
+	return func(options *options) error {
+		options.isLeader = fn
+		return nil
+	}
+}
+
+// WithDialOptions sets the options the other members are dialed with
+func WithDialOptions(opts ...grpc.DialOption) Options {
+	return func(options *options) error {
+		options.dialOptions = append(options.dialOptions, opts...)
+		return nil
+	}
+}
+
+func newOptions(opts []Options) (options, error) {
+	var mOpts options
+	for _, opt := range opts {
+		if err := opt(&mOpts); err != nil {
+			return mOpts, err
+		}
+	}
+	if mOpts.heartbeatInterval == nil {
+		mOpts.heartbeatInterval = &defaultHeartbeatInterval
+		mOpts.suspectTimeout = &defaultSuspectTimeout
+		mOpts.deadTimeout = &defaultDeadTimeout
+	}
+	if mOpts.eventBuffer == nil {
+		mOpts.eventBuffer = &defaultEventBuffer
+	}
+	if mOpts.isLeader == nil {
+		mOpts.isLeader = func() bool { return false }
+	}
+	return mOpts, nil
+}
@@ -0,0 +1,16 @@
+package membership
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type ErrMissingSender struct{}
+
+func (e ErrMissingSender) GRPCStatus() *status.Status {
+	return status.New(codes.InvalidArgument, "heartbeat should name the sending server")
+}
+
+func (e ErrMissingSender) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
@@ -0,0 +1,347 @@
+package membership
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"google.golang.org/grpc"
+	"sort"
+	"sync"
+	"time"
+)
+
+type EventType int
+
+const (
+	// EventJoin is emitted the first time a member is heard from
+	EventJoin EventType = iota
+	// EventUpdate is emitted when a member changes its address or role
+	EventUpdate
+	// EventSuspect is emitted when a member missed heartbeats for the suspect timeout
+	EventSuspect
+	// EventDead is emitted when a member missed heartbeats for the dead timeout
+	EventDead
+	// EventRecover is emitted when a suspect or dead member is heard from again
+	EventRecover
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventJoin:
+		return "join"
+	case EventUpdate:
+		return "update"
+	case EventSuspect:
+		return "suspect"
+	case EventDead:
+		return "dead"
+	default:
+		return "recover"
+	}
+}
+
+// Member is a server of the cluster as last seen by this member
+type Member struct {
+	ID       string
+	RPCAddr  string
+	IsLeader bool
+	State    api.ServerState
+	LastSeen time.Time
+}
+
+// Event reports a change of a member, Member holds its state after the change
+type Event struct {
+	Type   EventType
+	Member Member
+}
+
+// guarantee *Membership meets MembershipServer interface at compile time
+var _ api.MembershipServer = &Membership{}
+
+// Membership keeps track of the servers of a statically configured cluster.
+// Every member sends heartbeats to the seeds it was started with and to every
+// member it heard from, and marks members that stay silent as suspect and
+// then dead. Dead members are kept and recover once they are heard from again
+type Membership struct {
+	api.UnimplementedMembershipServer
+	mu sync.Mutex
+
+	ID          string
+	RPCAddr     string
+	seeds       []string
+	members     map[string]*Member
+	conns       map[string]*grpc.ClientConn
+	subscribers map[chan Event]struct{}
+	options     options
+	closed      chan struct{}
+	wg          sync.WaitGroup
+}
+
+// NewMembership starts sending heartbeats from the member with the given id,
+// served at rpcAddr, to the members at the seed addresses
+func NewMembership(id string, rpcAddr string, seeds []string, opts ...Options) (*Membership, error) {
+	mOpts, err := newOptions(opts)
+	if err != nil {
+		return nil, fmt.Errorf("error on membership creation: %v", err)
+	}
+	if id == "" {
+		return nil, errors.New("member id should not be empty")
+	}
+	m := &Membership{
+		ID:          id,
+		RPCAddr:     rpcAddr,
+		seeds:       seeds,
+		members:     make(map[string]*Member),
+		conns:       make(map[string]*grpc.ClientConn),
+		subscribers: make(map[chan Event]struct{}),
+		options:     mOpts,
+		closed:      make(chan struct{}),
+	}
+	m.wg.Add(1)
+	go m.run()
+	return m, nil
+}
+
+// Heartbeat records that the sending member is alive and answers with this member
+func (m *Membership) Heartbeat(_ context.Context, req *api.HeartbeatRequest) (*api.HeartbeatResponse, error) {
+	if req.Server == nil || req.Server.Id == "" {
+		return nil, ErrMissingSender{}
+	}
+	m.observe(req.Server)
+	return &api.HeartbeatResponse{Server: m.self()}, nil
+}
+
+// Members returns every member of the cluster sorted by id, including this one
+func (m *Membership) Members() []Member {
+	self := m.self()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := []Member{{
+		ID:       self.Id,
+		RPCAddr:  self.RpcAddr,
+		IsLeader: self.IsLeader,
+		State:    api.ServerState_SERVER_STATE_ALIVE,
+		LastSeen: time.Now(),
+	}}
+	for _, member := range m.members {
+		members = append(members, *member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+// GetServers returns every member of the cluster as described to clients
+func (m *Membership) GetServers() []*api.Server {
+	var servers []*api.Server
+	for _, member := range m.Members() {
+		servers = append(servers, &api.Server{
+			Id:       member.ID,
+			RpcAddr:  member.RPCAddr,
+			IsLeader: member.IsLeader,
+			State:    member.State,
+		})
+	}
+	return servers
+}
+
+// Subscribe returns a channel that receives every following membership event
+// and a function that cancels the subscription. Events are dropped for
+// subscribers that fall behind by more than the event buffer, Members returns
+// the current state to catch up from
+func (m *Membership) Subscribe() (<-chan Event, func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch := make(chan Event, *m.options.eventBuffer)
+	if m.isClosed() {
+		close(ch)
+		return ch, func() {}
+	}
+	m.subscribers[ch] = struct{}{}
+	return ch, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := m.subscribers[ch]; ok {
+			delete(m.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close stops sending heartbeats, closes the connections to the other
+// members and the channels of every subscriber
+func (m *Membership) Close() error {
+	m.mu.Lock()
+	if m.isClosed() {
+		m.mu.Unlock()
+		return nil
+	}
+	close(m.closed)
+	m.mu.Unlock()
+	m.wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for ch := range m.subscribers {
+		delete(m.subscribers, ch)
+		close(ch)
+	}
+	var errs []error
+	for addr, conn := range m.conns {
+		errs = append(errs, conn.Close())
+		delete(m.conns, addr)
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Membership) run() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(*m.options.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		m.heartbeat()
+		m.sweep()
+		select {
+		case <-ticker.C:
+		case <-m.closed:
+			return
+		}
+	}
+}
+
+// heartbeat sends a heartbeat to every seed and known member and waits for all of them
+func (m *Membership) heartbeat() {
+	self := m.self()
+	var wg sync.WaitGroup
+	for _, addr := range m.targets() {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			client, err := m.client(addr)
+			if err != nil {
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), *m.options.heartbeatInterval)
+			defer cancel()
+			res, err := client.Heartbeat(ctx, &api.HeartbeatRequest{Server: self})
+			if err != nil || res.Server == nil {
+				return
+			}
+			m.observe(res.Server)
+		}(addr)
+	}
+	wg.Wait()
+}
+
+// sweep moves members that stayed silent for too long to the suspect and dead states
+func (m *Membership) sweep() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, member := range m.members {
+		silent := time.Since(member.LastSeen)
+		switch {
+		case silent >= *m.options.deadTimeout && member.State != api.ServerState_SERVER_STATE_DEAD:
+			member.State = api.ServerState_SERVER_STATE_DEAD
+			m.emit(EventDead, member)
+		case silent >= *m.options.suspectTimeout && member.State == api.ServerState_SERVER_STATE_ALIVE:
+			member.State = api.ServerState_SERVER_STATE_SUSPECT
+			m.emit(EventSuspect, member)
+		}
+	}
+}
+
+// observe records that the given server was just heard from
+func (m *Membership) observe(server *api.Server) {
+	if server.Id == m.ID {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	member, ok := m.members[server.Id]
+	if !ok {
+		member = &Member{
+			ID:       server.Id,
+			RPCAddr:  server.RpcAddr,
+			IsLeader: server.IsLeader,
+			State:    api.ServerState_SERVER_STATE_ALIVE,
+			LastSeen: time.Now(),
+		}
+		m.members[server.Id] = member
+		m.emit(EventJoin, member)
+		return
+	}
+	member.LastSeen = time.Now()
+	if member.State != api.ServerState_SERVER_STATE_ALIVE {
+		member.State = api.ServerState_SERVER_STATE_ALIVE
+		m.emit(EventRecover, member)
+	}
+	if member.RPCAddr != server.RpcAddr || member.IsLeader != server.IsLeader {
+		member.RPCAddr = server.RpcAddr
+		member.IsLeader = server.IsLeader
+		m.emit(EventUpdate, member)
+	}
+}
+
+// emit must be called with the lock held
+func (m *Membership) emit(t EventType, member *Member) {
+	ev := Event{Type: t, Member: *member}
+	for ch := range m.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// targets returns the addresses of the seeds and of every known member except this one
+func (m *Membership) targets() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := map[string]bool{m.RPCAddr: true}
+	var addrs []string
+	for _, addr := range m.seeds {
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	for _, member := range m.members {
+		if !seen[member.RPCAddr] {
+			seen[member.RPCAddr] = true
+			addrs = append(addrs, member.RPCAddr)
+		}
+	}
+	return addrs
+}
+
+func (m *Membership) client(addr string) (api.MembershipClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	conn, ok := m.conns[addr]
+	if !ok {
+		var err error
+		if conn, err = grpc.Dial(addr, m.options.dialOptions...); err != nil {
+			return nil, err
+		}
+		m.conns[addr] = conn
+	}
+	return api.NewMembershipClient(conn), nil
+}
+
+func (m *Membership) self() *api.Server {
+	return &api.Server{
+		Id:       m.ID,
+		RpcAddr:  m.RPCAddr,
+		IsLeader: m.options.isLeader(),
+		State:    api.ServerState_SERVER_STATE_ALIVE,
+	}
+}
+
+func (m *Membership) isClosed() bool {
+	select {
+	case <-m.closed:
+		return true
+	default:
+		return false
+	}
+}
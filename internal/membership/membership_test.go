@@ -0,0 +1,165 @@
+package membership
+
+import (
+	"context"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testMember struct {
+	membership *Membership
+	server     *grpc.Server
+}
+
+type MembershipTestSuite struct {
+	suite.Suite
+	members  []*testMember
+	events   <-chan Event
+	leaderID atomic.Value
+}
+
+func TestMembershipTestSuite(t *testing.T) {
+	suite.Run(t, &MembershipTestSuite{})
+}
+
+func (s *MembershipTestSuite) SetupTest() {
+	s.members = nil
+	var listeners []net.Listener
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		s.Require().NoError(err)
+		listeners = append(listeners, listener)
+		addrs = append(addrs, listener.Addr().String())
+	}
+	s.leaderID.Store("member-0")
+
+	for i, listener := range listeners {
+		id := fmt.Sprintf("member-%d", i)
+		m, err := NewMembership(id, addrs[i], addrs,
+			WithTimeouts(20*time.Millisecond, 100*time.Millisecond, 200*time.Millisecond),
+			WithLeaderFunc(func() bool { return id == s.leaderID.Load() }),
+			WithDialOptions(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		)
+		s.Require().NoError(err)
+		if i == 0 {
+			s.events, _ = m.Subscribe()
+		}
+		server := grpc.NewServer()
+		api.RegisterMembershipServer(server, m)
+		go func(listener net.Listener) {
+			_ = server.Serve(listener)
+		}(listener)
+		s.members = append(s.members, &testMember{membership: m, server: server})
+	}
+}
+
+func (s *MembershipTestSuite) TearDownTest() {
+	for _, member := range s.members {
+		s.stopMember(member)
+	}
+}
+
+func (s *MembershipTestSuite) TestMembersDiscoverEachOther() {
+	for _, member := range s.members {
+		s.Require().Eventually(func() bool {
+			return s.countState(member.membership, api.ServerState_SERVER_STATE_ALIVE) == 3
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	servers := s.members[2].membership.GetServers()
+	s.Require().Equal(3, len(servers))
+	for i, server := range servers {
+		s.Require().Equal(fmt.Sprintf("member-%d", i), server.Id)
+		s.Require().NotEmpty(server.RpcAddr)
+		s.Require().Equal(i == 0, server.IsLeader)
+	}
+
+	joined := make(map[string]bool)
+	for len(joined) < 2 {
+		ev := s.nextEvent()
+		if ev.Type == EventJoin {
+			joined[ev.Member.ID] = true
+		}
+	}
+	s.Require().True(joined["member-1"])
+	s.Require().True(joined["member-2"])
+}
+
+func (s *MembershipTestSuite) TestRoleChangeEmitsUpdate() {
+	s.waitForAlive(3)
+	s.leaderID.Store("member-1")
+	for {
+		ev := s.nextEvent()
+		if ev.Type == EventUpdate && ev.Member.ID == "member-1" {
+			s.Require().True(ev.Member.IsLeader)
+			return
+		}
+	}
+}
+
+func (s *MembershipTestSuite) TestSilentMemberIsSuspectedThenDead() {
+	s.waitForAlive(3)
+	s.stopMember(s.members[2])
+	s.members = s.members[:2]
+
+	var states []EventType
+	for len(states) < 2 {
+		ev := s.nextEvent()
+		if ev.Member.ID == "member-2" && (ev.Type == EventSuspect || ev.Type == EventDead) {
+			states = append(states, ev.Type)
+		}
+	}
+	s.Require().Equal([]EventType{EventSuspect, EventDead}, states)
+
+	// dead members are still reported so clients know the whole cluster
+	servers := s.members[0].membership.GetServers()
+	s.Require().Equal(3, len(servers))
+	s.Require().Equal(api.ServerState_SERVER_STATE_DEAD, servers[2].State)
+}
+
+func (s *MembershipTestSuite) TestHeartbeatRequiresSender() {
+	_, err := s.members[0].membership.Heartbeat(context.Background(), &api.HeartbeatRequest{})
+	s.Require().Equal(ErrMissingSender{}, err)
+	s.Require().Equal(codes.InvalidArgument, status.Code(err))
+}
+
+func (s *MembershipTestSuite) waitForAlive(n int) {
+	s.Require().Eventually(func() bool {
+		return s.countState(s.members[0].membership, api.ServerState_SERVER_STATE_ALIVE) == n
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func (s *MembershipTestSuite) countState(m *Membership, state api.ServerState) int {
+	n := 0
+	for _, member := range m.Members() {
+		if member.State == state {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *MembershipTestSuite) nextEvent() Event {
+	select {
+	case ev := <-s.events:
+		return ev
+	case <-time.After(5 * time.Second):
+		s.FailNow("timed out waiting for a membership event")
+		return Event{}
+	}
+}
+
+func (s *MembershipTestSuite) stopMember(member *testMember) {
+	member.server.Stop()
+	s.Require().NoError(member.membership.Close())
+}
@@ -0,0 +1,374 @@
+// Package metrics records counters, gauges and histograms and exposes them in
+// the Prometheus text exposition format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the registry the packages of the server record their metrics in
+var Default = NewRegistry()
+
+// DefaultBuckets are the upper bounds of histogram buckets in seconds, suited to RPC latencies
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ExponentialBuckets returns count upper bounds starting at start, each factor times the previous one
+func ExponentialBuckets(start float64, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics by name and serves them over HTTP
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// NewCounter registers a counter, which only goes up, partitioned by the given labels
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{vec: newVec(name, help, "counter", labels)}
+	r.register(name, c)
+	return c
+}
+
+// NewGauge registers a gauge, which may go up and down, partitioned by the given labels
+func (r *Registry) NewGauge(name string, help string, labels ...string) *Gauge {
+	g := &Gauge{vec: newVec(name, help, "gauge", labels), funcs: make(map[string]func() float64)}
+	r.register(name, g)
+	return g
+}
+
+// NewHistogram registers a histogram counting observations into buckets with
+// the given sorted upper bounds, partitioned by the given labels
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		vec:       newVec(name, help, "histogram", labels),
+		buckets:   buckets,
+		histories: make(map[string]*histogram),
+	}
+	r.register(name, h)
+	return h
+}
+
+// register panics when the name is taken, since that is a programming error
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic(fmt.Sprintf("metric %q is already registered", name))
+	}
+	r.metrics[name] = m
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format, sorted by name
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make([]metric, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serves the metrics of the registry, such as on a /metrics endpoint
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+// vec holds what the metric types share: their description and label names
+type vec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	kind   string
+	labels []string
+	values map[string]float64
+	// pairs keeps the label values of every key, so they are not split again on write
+	pairs map[string][]string
+}
+
+func newVec(name string, help string, kind string, labels []string) vec {
+	return vec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: make(map[string]float64),
+		pairs:  make(map[string][]string),
+	}
+}
+
+// key must be called with the lock held
+func (v *vec) key(labelValues []string) string {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %q has %d labels, got %d values", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	if _, ok := v.pairs[key]; !ok {
+		v.pairs[key] = append([]string(nil), labelValues...)
+	}
+	return key
+}
+
+// sortedKeys must be called with the lock held
+func (v *vec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.pairs))
+	for key := range v.pairs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (v *vec) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, v.kind)
+}
+
+// writeSample writes one line of the metric, extra is a label pair appended after the labels of key
+func (v *vec) writeSample(w *bufio.Writer, suffix string, key string, extra string, value float64) {
+	w.WriteString(v.name + suffix)
+	pairs := v.pairs[key]
+	if len(pairs) > 0 || extra != "" {
+		w.WriteByte('{')
+		for i, label := range v.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabel(pairs[i]))
+		}
+		if extra != "" {
+			if len(pairs) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extra)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+// Counter is a cumulative metric that only goes up
+type Counter struct {
+	vec
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter by v, which has to be positive
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("counter %q cannot decrease", c.name))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[c.key(labelValues)] += v
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+	for _, key := range c.sortedKeys() {
+		c.writeSample(w, "", key, "", c.values[key])
+	}
+}
+
+// Gauge is a metric that may go up and down. Its values are either set or,
+// for values that are cheaper to compute when scraped, read from a function
+type Gauge struct {
+	vec
+	funcs map[string]func() float64
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := g.key(labelValues)
+	delete(g.funcs, key)
+	g.values[key] = v
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[g.key(labelValues)] += v
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// SetFunc reads the value of the gauge from f whenever the metrics are written
+func (g *Gauge) SetFunc(f func() float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := g.key(labelValues)
+	delete(g.values, key)
+	g.funcs[key] = f
+}
+
+// Delete removes the value of the gauge for the label values, such as when what it measures is gone
+func (g *Gauge) Delete(labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := g.key(labelValues)
+	delete(g.values, key)
+	delete(g.funcs, key)
+	delete(g.pairs, key)
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.mu.Lock()
+	keys := g.sortedKeys()
+	values := make(map[string]float64, len(keys))
+	funcs := make(map[string]func() float64, len(g.funcs))
+	for _, key := range keys {
+		if f, ok := g.funcs[key]; ok {
+			funcs[key] = f
+		} else {
+			values[key] = g.values[key]
+		}
+	}
+	g.mu.Unlock()
+
+	// functions may take locks of their own, so they are called without holding the gauge's
+	for key, f := range funcs {
+		values[key] = f()
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writeHeader(w)
+	for _, key := range keys {
+		if _, ok := g.pairs[key]; ok {
+			g.writeSample(w, "", key, "", values[key])
+		}
+	}
+}
+
+// Histogram counts observations, such as latencies, into cumulative buckets
+type Histogram struct {
+	vec
+	buckets   []float64
+	histories map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := h.key(labelValues)
+	hist, ok := h.histories[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.histories[key] = hist
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			hist.counts[i]++
+			break
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	for _, key := range h.sortedKeys() {
+		hist := h.histories[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hist.counts[i]
+			h.writeSample(w, "_bucket", key, fmt.Sprintf("le=\"%s\"", formatFloat(bound)), float64(cumulative))
+		}
+		h.writeSample(w, "_bucket", key, "le=\"+Inf\"", float64(hist.count))
+		h.writeSample(w, "_sum", key, "", hist.sum)
+		h.writeSample(w, "_count", key, "", float64(hist.count))
+	}
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type MetricsTestSuite struct {
+	suite.Suite
+	registry *Registry
+}
+
+func TestMetricsTestSuite(t *testing.T) {
+	suite.Run(t, &MetricsTestSuite{})
+}
+
+func (s *MetricsTestSuite) SetupTest() {
+	s.registry = NewRegistry()
+}
+
+func (s *MetricsTestSuite) TestCounter() {
+	c := s.registry.NewCounter("requests_total", "Requests by \\ code\nhandled.", "method", "code")
+	c.Inc("/Produce", "OK")
+	c.Add(2, "/Produce", "OK")
+	c.Inc("/Consume", "say \"hi\"")
+	s.Require().Panics(func() { c.Add(-1, "/Produce", "OK") })
+	s.Require().Panics(func() { c.Inc("/Produce") })
+
+	s.Require().Equal(`# HELP requests_total Requests by \\ code\nhandled.
+# TYPE requests_total counter
+requests_total{method="/Consume",code="say \"hi\""} 1
+requests_total{method="/Produce",code="OK"} 3
+`, s.write())
+}
+
+func (s *MetricsTestSuite) TestGauge() {
+	g := s.registry.NewGauge("segments", "Segments.", "dir")
+	g.Set(3, "a")
+	g.Dec("a")
+	g.SetFunc(func() float64 { return 7 }, "b")
+	g.Inc("c")
+	g.Delete("c")
+
+	s.Require().Equal(`# HELP segments Segments.
+# TYPE segments gauge
+segments{dir="a"} 2
+segments{dir="b"} 7
+`, s.write())
+}
+
+func (s *MetricsTestSuite) TestHistogram() {
+	h := s.registry.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	s.Require().Equal(`# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.55
+latency_seconds_count 3
+`, s.write())
+}
+
+func (s *MetricsTestSuite) TestServeHTTP() {
+	s.registry.NewCounter("b_total", "B.").Inc()
+	s.registry.NewCounter("a_total", "A.").Inc()
+	s.Require().Panics(func() { s.registry.NewGauge("a_total", "A.") })
+
+	rec := httptest.NewRecorder()
+	s.registry.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	s.Require().Equal(http.StatusOK, rec.Code)
+	s.Require().Equal("text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	// metrics are sorted by name
+	s.Require().Equal(`# HELP a_total A.
+# TYPE a_total counter
+a_total 1
+# HELP b_total B.
+# TYPE b_total counter
+b_total 1
+`, rec.Body.String())
+}
+
+func (s *MetricsTestSuite) write() string {
+	var buf bytes.Buffer
+	n, err := s.registry.WriteTo(&buf)
+	s.Require().NoError(err)
+	s.Require().Equal(int64(buf.Len()), n)
+	return buf.String()
+}
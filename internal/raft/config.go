@@ -0,0 +1,55 @@
+package raft
+
+import (
+	"errors"
+	"github.com/a-shakra/commit-log/internal/log"
+	"time"
+)
+
+var (
+	defaultHeartbeatInterval = 50 * time.Millisecond
+	defaultElectionTimeout   = 500 * time.Millisecond
+	defaultMaxAppendEntries  = 64
+)
+
+type options struct {
+	heartbeatInterval *time.Duration
+	electionTimeout   *time.Duration
+	maxAppendEntries  *int
+	logOptions        []log.Options
+}
+
+type Options func(options *options) error
+
+// WithTimeouts sets how often a leader sends heartbeats and how long a
+// follower waits without hearing from a leader before it starts an election.
+// The election timeout is randomized between electionTimeout and twice its value
+func WithTimeouts(heartbeatInterval time.Duration, electionTimeout time.Duration) Options {
+	return func(options *options) error {
+		if heartbeatInterval <= 0 || electionTimeout <= heartbeatInterval {
+			return errors.New("election timeout should be greater than a positive heartbeat interval")
+		}
+		options.heartbeatInterval = &heartbeatInterval
+		options.electionTimeout = &electionTimeout
+		return nil
+	}
+}
+
+// WithMaxAppendEntries sets how many entries a leader sends in a single AppendEntries request
+func WithMaxAppendEntries(n int) Options {
+	return func(options *options) error {
+		if n < 1 {
+			return errors.New("max append entries should be a positive value")
+		}
+		options.maxAppendEntries = &n
+		return nil
+	}
+}
+
+// WithLogOptions sets the options the Log that stores the entries is opened with
+func WithLogOptions(opts ...log.Options) Options {
+	return func(options *options) error {
+		options.logOptions = append(options.logOptions, opts...)
+		return nil
+	}
+}
@@ -0,0 +1,28 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrLeadershipLost = errors.New("leadership lost before the entry was committed")
+	ErrNodeClosed     = errors.New("raft node is closed")
+)
+
+type ErrNotLeader struct {
+	Leader string
+}
+
+func (e ErrNotLeader) GRPCStatus() *status.Status {
+	if e.Leader == "" {
+		return status.New(codes.Unavailable, "node is not the leader and no leader is known")
+	}
+	return status.New(codes.FailedPrecondition, fmt.Sprintf("node is not the leader, the leader is %s", e.Leader))
+}
+
+func (e ErrNotLeader) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
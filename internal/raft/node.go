@@ -0,0 +1,692 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const logDir = "log"
+
+type Role int
+
+const (
+	Follower Role = iota
+	Candidate
+	Leader
+)
+
+func (r Role) String() string {
+	switch r {
+	case Leader:
+		return "leader"
+	case Candidate:
+		return "candidate"
+	default:
+		return "follower"
+	}
+}
+
+// guarantee *Node meets RaftServer interface at compile time
+var _ api.RaftServer = &Node{}
+
+// Node is a member of a Raft cluster that replicates a Log. The Raft index of
+// an entry is its offset in the Log plus one, the term and type of an entry
+// ride in the record envelope. Entries are only visible to readers once a
+// quorum of the cluster stored them
+type Node struct {
+	api.UnimplementedRaftServer
+	mu sync.Mutex
+
+	ID        string
+	Dir       string
+	peers     []string
+	transport Transport
+	log       *log.Log
+	options   options
+
+	state    persistentState
+	role     Role
+	leaderID string
+	// commitIndex is the index of the last committed entry, all entries up to it may be read
+	commitIndex uint64
+	lastHeard   time.Time
+	timeout     time.Duration
+
+	// leader state, reinitialized after every election
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	lastContact map[string]time.Time
+	inflight    map[string]bool
+
+	// changed is closed and replaced whenever the commit index or the role changes
+	changed chan struct{}
+	closed  chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewNode opens the entry log and persistent state in dir and starts the node
+// as a follower of a cluster made of itself and peers
+func NewNode(id string, dir string, peers []string, transport Transport, opts ...Options) (*Node, error) {
+	var rOpts options
+	for _, opt := range opts {
+		if err := opt(&rOpts); err != nil {
+			return nil, fmt.Errorf("error on raft node creation: %v", err)
+		}
+	}
+	if rOpts.heartbeatInterval == nil {
+		rOpts.heartbeatInterval = &defaultHeartbeatInterval
+		rOpts.electionTimeout = &defaultElectionTimeout
+	}
+	if rOpts.maxAppendEntries == nil {
+		rOpts.maxAppendEntries = &defaultMaxAppendEntries
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, logDir), 0755); err != nil {
+		return nil, err
+	}
+	st, err := readState(dir)
+	if err != nil {
+		return nil, err
+	}
+	l, err := log.NewLog(filepath.Join(dir, logDir), rOpts.logOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		ID:        id,
+		Dir:       dir,
+		peers:     peers,
+		transport: transport,
+		log:       l,
+		options:   rOpts,
+		state:     st,
+		role:      Follower,
+		lastHeard: time.Now(),
+		changed:   make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+	n.resetTimeout()
+	n.wg.Add(1)
+	go n.run()
+	return n, nil
+}
+
+// Append proposes the record to the cluster and returns its offset once a
+// quorum committed it. Only the leader accepts proposals
+func (n *Node) Append(record *api.Record) (uint64, error) {
+	return n.Propose(context.Background(), record)
+}
+
+// Propose is Append bounded by ctx
+func (n *Node) Propose(ctx context.Context, record *api.Record) (uint64, error) {
+	n.mu.Lock()
+	if n.role != Leader {
+		leader := n.leaderID
+		n.mu.Unlock()
+		return 0, ErrNotLeader{Leader: leader}
+	}
+	leaderTerm := n.state.CurrentTerm
+	record.Term = leaderTerm
+	next := n.log.NextOffset()
+	off, err := n.log.Append(record)
+	if err == nil {
+		// the leader counts itself among the nodes that stored the entry
+		err = n.log.Sync()
+	}
+	if err != nil {
+		n.mu.Unlock()
+		return 0, err
+	}
+	// a retry of an idempotent producer returns the entry proposed first, which
+	// may be from an earlier term and is committed like the entries before it
+	term := leaderTerm
+	if off < next {
+		if term, err = n.termAt(off + 1); err != nil {
+			n.mu.Unlock()
+			return 0, err
+		}
+	}
+	// a single node cluster commits without hearing from any peer
+	n.advanceCommitIndex()
+	n.mu.Unlock()
+	n.replicate()
+
+	for {
+		n.mu.Lock()
+		changed := n.changed
+		committed := n.commitIndex > off
+		current := n.role == Leader && n.state.CurrentTerm == leaderTerm
+		if committed {
+			// the entry may have been replaced by a later leader before it committed
+			t, err := n.termAt(off + 1)
+			n.mu.Unlock()
+			if err != nil || t != term {
+				return 0, ErrLeadershipLost
+			}
+			return off, nil
+		}
+		n.mu.Unlock()
+		if !current {
+			return 0, ErrLeadershipLost
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-n.closed:
+			return 0, ErrLeadershipLost
+		}
+	}
+}
+
+// Read returns the committed record stored at the given offset
+func (n *Node) Read(off uint64) (*api.Record, error) {
+	n.mu.Lock()
+	committed := off < n.commitIndex
+	n.mu.Unlock()
+	if !committed {
+		return nil, log.ErrOffsetOutOfRange{Offset: off}
+	}
+	return n.log.Read(off)
+}
+
+// LowestOffset returns the offset of the oldest entry
+func (n *Node) LowestOffset() uint64 {
+	return n.log.LowestOffset()
+}
+
+// NextOffset returns the offset following the last committed entry
+func (n *Node) NextOffset() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.commitIndex
+}
+
+// State returns the role of the node, the current term and the id of the leader it knows of
+func (n *Node) State() (role Role, term uint64, leader string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role, n.state.CurrentTerm, n.leaderID
+}
+
+// Close stops the node and closes its entry log
+func (n *Node) Close() error {
+	n.mu.Lock()
+	if n.isClosed() {
+		n.mu.Unlock()
+		return nil
+	}
+	close(n.closed)
+	n.mu.Unlock()
+	n.wg.Wait()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.log.Close()
+}
+
+// Remove stops the node and deletes its entries and persistent state
+func (n *Node) Remove() error {
+	if err := n.Close(); err != nil {
+		return err
+	}
+	return os.RemoveAll(n.Dir)
+}
+
+// RequestVote grants the vote of this node to a candidate whose log is at least as up-to-date
+func (n *Node) RequestVote(_ context.Context, req *api.RequestVoteRequest) (*api.RequestVoteResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.isClosed() {
+		return nil, ErrNodeClosed
+	}
+
+	if req.Term < n.state.CurrentTerm {
+		return &api.RequestVoteResponse{Term: n.state.CurrentTerm}, nil
+	}
+	if req.Term > n.state.CurrentTerm {
+		if err := n.becomeFollower(req.Term, ""); err != nil {
+			return nil, err
+		}
+	}
+	lastIndex, lastTerm, err := n.lastEntry()
+	if err != nil {
+		return nil, err
+	}
+	upToDate := req.LastLogTerm > lastTerm || (req.LastLogTerm == lastTerm && req.LastLogIndex >= lastIndex)
+	if (n.state.VotedFor == "" || n.state.VotedFor == req.CandidateId) && upToDate {
+		n.state.VotedFor = req.CandidateId
+		if err = writeState(n.Dir, n.state); err != nil {
+			return nil, err
+		}
+		n.lastHeard = time.Now()
+		return &api.RequestVoteResponse{Term: n.state.CurrentTerm, VoteGranted: true}, nil
+	}
+	return &api.RequestVoteResponse{Term: n.state.CurrentTerm}, nil
+}
+
+// AppendEntries stores the entries of the leader after checking that the logs
+// match at the previous entry. Conflicting entries and everything following
+// them are truncated before the new entries are appended
+func (n *Node) AppendEntries(_ context.Context, req *api.AppendEntriesRequest) (*api.AppendEntriesResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.isClosed() {
+		return nil, ErrNodeClosed
+	}
+
+	if req.Term < n.state.CurrentTerm {
+		return &api.AppendEntriesResponse{Term: n.state.CurrentTerm}, nil
+	}
+	if req.Term > n.state.CurrentTerm || n.role != Follower {
+		if err := n.becomeFollower(req.Term, req.LeaderId); err != nil {
+			return nil, err
+		}
+	}
+	n.leaderID = req.LeaderId
+	n.lastHeard = time.Now()
+
+	lastIndex := n.log.NextOffset()
+	if req.PrevLogIndex > lastIndex {
+		return &api.AppendEntriesResponse{Term: n.state.CurrentTerm, ConflictIndex: lastIndex + 1}, nil
+	}
+	if req.PrevLogIndex > 0 {
+		prevTerm, err := n.termAt(req.PrevLogIndex)
+		if err != nil {
+			return nil, err
+		}
+		if prevTerm != req.PrevLogTerm {
+			conflict, err := n.firstIndexOfTerm(req.PrevLogIndex, prevTerm)
+			if err != nil {
+				return nil, err
+			}
+			return &api.AppendEntriesResponse{Term: n.state.CurrentTerm, ConflictIndex: conflict}, nil
+		}
+	}
+
+	var appended bool
+	for i, entry := range req.Entries {
+		index := req.PrevLogIndex + uint64(i) + 1
+		if index <= n.log.NextOffset() {
+			t, err := n.termAt(index)
+			if err != nil {
+				return nil, err
+			}
+			if t == entry.Term {
+				continue
+			}
+			if err = n.truncate(index - 1); err != nil {
+				return nil, err
+			}
+		}
+		// the leader already checked the producer of the entry
+		if _, err := n.log.AppendReplica(entry); err != nil {
+			return nil, err
+		}
+		appended = true
+	}
+	// the leader counts the entries as stored once it hears of success
+	if appended {
+		if err := n.log.Sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	lastNew := req.PrevLogIndex + uint64(len(req.Entries))
+	if req.LeaderCommit > n.commitIndex {
+		n.setCommitIndex(min(req.LeaderCommit, lastNew))
+	}
+	return &api.AppendEntriesResponse{Term: n.state.CurrentTerm, Success: true}, nil
+}
+
+func (n *Node) run() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(*n.options.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-n.closed:
+			return
+		}
+		n.mu.Lock()
+		role := n.role
+		expired := time.Since(n.lastHeard) >= n.timeout
+		n.mu.Unlock()
+
+		switch {
+		case role == Leader:
+			n.checkQuorum()
+			n.replicate()
+		case expired:
+			n.startElection()
+		}
+	}
+}
+
+func (n *Node) startElection() {
+	n.mu.Lock()
+	n.role = Candidate
+	n.leaderID = ""
+	n.state.CurrentTerm++
+	n.state.VotedFor = n.ID
+	if err := writeState(n.Dir, n.state); err != nil {
+		n.mu.Unlock()
+		return
+	}
+	n.lastHeard = time.Now()
+	n.resetTimeout()
+	n.broadcast()
+	term := n.state.CurrentTerm
+	lastIndex, lastTerm, err := n.lastEntry()
+	if err != nil {
+		n.mu.Unlock()
+		return
+	}
+	votes := 1
+	if votes >= n.quorum() {
+		won := n.becomeLeader() == nil
+		n.mu.Unlock()
+		if won {
+			n.replicate()
+		}
+		return
+	}
+
+	req := &api.RequestVoteRequest{
+		Term:         term,
+		CandidateId:  n.ID,
+		LastLogIndex: lastIndex,
+		LastLogTerm:  lastTerm,
+	}
+	defer n.mu.Unlock()
+	if n.isClosed() {
+		return
+	}
+	for _, peer := range n.peers {
+		n.wg.Add(1)
+		go func(peer string) {
+			defer n.wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), *n.options.electionTimeout)
+			defer cancel()
+			res, err := n.transport.RequestVote(ctx, peer, req)
+			if err != nil {
+				return
+			}
+			n.mu.Lock()
+			if res.Term > n.state.CurrentTerm {
+				n.becomeFollower(res.Term, "")
+				n.mu.Unlock()
+				return
+			}
+			if !res.VoteGranted || n.role != Candidate || n.state.CurrentTerm != term {
+				n.mu.Unlock()
+				return
+			}
+			votes++
+			won := votes == n.quorum() && n.becomeLeader() == nil
+			n.mu.Unlock()
+			if won {
+				n.replicate()
+			}
+		}(peer)
+	}
+}
+
+// becomeLeader initializes the leader state and appends a no-op entry so that
+// entries of earlier terms are committed without waiting for a new proposal.
+// When the no-op cannot be appended the node steps down instead
+func (n *Node) becomeLeader() error {
+	next := n.log.NextOffset() + 1
+	_, err := n.log.Append(&api.Record{Term: n.state.CurrentTerm, Type: api.RecordType_RECORD_TYPE_NOOP})
+	if err == nil {
+		err = n.log.Sync()
+	}
+	if err != nil {
+		n.becomeFollower(n.state.CurrentTerm, "")
+		return err
+	}
+	n.role = Leader
+	n.leaderID = n.ID
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.lastContact = make(map[string]time.Time)
+	n.inflight = make(map[string]bool)
+	for _, peer := range n.peers {
+		n.nextIndex[peer] = next
+		n.lastContact[peer] = time.Now()
+	}
+	n.advanceCommitIndex()
+	n.broadcast()
+	return nil
+}
+
+// becomeFollower must be called with the lock held
+func (n *Node) becomeFollower(term uint64, leader string) error {
+	if term > n.state.CurrentTerm {
+		n.state.CurrentTerm = term
+		n.state.VotedFor = ""
+		if err := writeState(n.Dir, n.state); err != nil {
+			return err
+		}
+	}
+	n.role = Follower
+	n.leaderID = leader
+	n.lastHeard = time.Now()
+	n.resetTimeout()
+	n.broadcast()
+	return nil
+}
+
+// checkQuorum makes a leader that cannot reach a quorum step down, so that
+// proposals waiting on it fail instead of blocking until the partition heals
+func (n *Node) checkQuorum() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.role != Leader {
+		return
+	}
+	reachable := 1
+	for _, peer := range n.peers {
+		if time.Since(n.lastContact[peer]) < *n.options.electionTimeout {
+			reachable++
+		}
+	}
+	if reachable < n.quorum() {
+		n.becomeFollower(n.state.CurrentTerm, "")
+	}
+}
+
+// replicate sends the missing entries, or a heartbeat, to every peer that has no request in flight
+func (n *Node) replicate() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.role != Leader || n.isClosed() {
+		return
+	}
+	for _, peer := range n.peers {
+		if !n.inflight[peer] {
+			n.inflight[peer] = true
+			n.wg.Add(1)
+			go n.replicateTo(peer, n.state.CurrentTerm)
+		}
+	}
+}
+
+func (n *Node) replicateTo(peer string, term uint64) {
+	defer n.wg.Done()
+	for {
+		more, err := n.sendAppendEntries(peer, term)
+		if err != nil || !more {
+			break
+		}
+	}
+	n.mu.Lock()
+	if n.inflight != nil && n.state.CurrentTerm == term {
+		n.inflight[peer] = false
+	}
+	n.mu.Unlock()
+}
+
+// sendAppendEntries sends a single AppendEntries request to peer and reports
+// whether the peer still misses entries after it
+func (n *Node) sendAppendEntries(peer string, term uint64) (bool, error) {
+	n.mu.Lock()
+	if n.role != Leader || n.state.CurrentTerm != term {
+		n.mu.Unlock()
+		return false, nil
+	}
+	next := n.nextIndex[peer]
+	prevIndex := next - 1
+	prevTerm, err := n.termAt(prevIndex)
+	if err != nil {
+		n.mu.Unlock()
+		return false, err
+	}
+	var entries []*api.Record
+	for index := next; index <= n.log.NextOffset() && len(entries) < *n.options.maxAppendEntries; index++ {
+		rec, err := n.log.Read(index - 1)
+		if err != nil {
+			n.mu.Unlock()
+			return false, err
+		}
+		entries = append(entries, rec)
+	}
+	req := &api.AppendEntriesRequest{
+		Term:         term,
+		LeaderId:     n.ID,
+		PrevLogIndex: prevIndex,
+		PrevLogTerm:  prevTerm,
+		Entries:      entries,
+		LeaderCommit: n.commitIndex,
+	}
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *n.options.electionTimeout)
+	defer cancel()
+	res, err := n.transport.AppendEntries(ctx, peer, req)
+	if err != nil {
+		return false, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if res.Term > n.state.CurrentTerm {
+		return false, n.becomeFollower(res.Term, "")
+	}
+	if n.role != Leader || n.state.CurrentTerm != term {
+		return false, nil
+	}
+	n.lastContact[peer] = time.Now()
+	if !res.Success {
+		n.nextIndex[peer] = max(1, min(res.ConflictIndex, next-1))
+		return true, nil
+	}
+	n.matchIndex[peer] = prevIndex + uint64(len(entries))
+	n.nextIndex[peer] = n.matchIndex[peer] + 1
+	n.advanceCommitIndex()
+	return n.nextIndex[peer] <= n.log.NextOffset(), nil
+}
+
+// advanceCommitIndex commits the highest entry of the current term that a quorum stored
+func (n *Node) advanceCommitIndex() {
+	for index := n.log.NextOffset(); index > n.commitIndex; index-- {
+		t, err := n.termAt(index)
+		if err != nil || t != n.state.CurrentTerm {
+			// entries of earlier terms only commit indirectly
+			return
+		}
+		stored := 1
+		for _, peer := range n.peers {
+			if n.matchIndex[peer] >= index {
+				stored++
+			}
+		}
+		if stored >= n.quorum() {
+			n.setCommitIndex(index)
+			return
+		}
+	}
+}
+
+func (n *Node) setCommitIndex(index uint64) {
+	if index > n.commitIndex {
+		n.commitIndex = index
+		n.broadcast()
+	}
+}
+
+func (n *Node) broadcast() {
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+// truncate removes every entry after the first keep entries
+func (n *Node) truncate(keep uint64) error {
+	if keep == 0 {
+		return n.log.Reset()
+	}
+	return n.log.TruncateAfter(keep - 1)
+}
+
+// termAt returns the term of the entry with the given index, index 0 precedes the first entry
+func (n *Node) termAt(index uint64) (uint64, error) {
+	if index == 0 {
+		return 0, nil
+	}
+	rec, err := n.log.Read(index - 1)
+	if err != nil {
+		return 0, err
+	}
+	return rec.Term, nil
+}
+
+func (n *Node) firstIndexOfTerm(index uint64, term uint64) (uint64, error) {
+	for index > 1 {
+		t, err := n.termAt(index - 1)
+		if err != nil {
+			return 0, err
+		}
+		if t != term {
+			break
+		}
+		index--
+	}
+	return index, nil
+}
+
+func (n *Node) lastEntry() (index uint64, term uint64, err error) {
+	index = n.log.NextOffset()
+	term, err = n.termAt(index)
+	if err != nil && !errors.Is(err, log.ErrEndOfFile) {
+		return 0, 0, err
+	}
+	return index, term, nil
+}
+
+func (n *Node) isClosed() bool {
+	select {
+	case <-n.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (n *Node) quorum() int {
+	return (len(n.peers)+1)/2 + 1
+}
+
+func (n *Node) resetTimeout() {
+	base := *n.options.electionTimeout
+	n.timeout = base + time.Duration(rand.Int63n(int64(base)))
+}
@@ -0,0 +1,351 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errUnreachable = errors.New("member is unreachable")
+
+// inmemNetwork connects the nodes of a test cluster and can cut the links between them
+type inmemNetwork struct {
+	mu    sync.Mutex
+	nodes map[string]*Node
+	cut   map[[2]string]bool
+}
+
+type inmemTransport struct {
+	from    string
+	network *inmemNetwork
+}
+
+func (t *inmemTransport) RequestVote(ctx context.Context, target string, req *api.RequestVoteRequest) (
+	*api.RequestVoteResponse, error) {
+	node, err := t.network.route(t.from, target)
+	if err != nil {
+		return nil, err
+	}
+	return node.RequestVote(ctx, req)
+}
+
+func (t *inmemTransport) AppendEntries(ctx context.Context, target string, req *api.AppendEntriesRequest) (
+	*api.AppendEntriesResponse, error) {
+	node, err := t.network.route(t.from, target)
+	if err != nil {
+		return nil, err
+	}
+	return node.AppendEntries(ctx, req)
+}
+
+func (nw *inmemNetwork) route(from string, to string) (*Node, error) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	node, ok := nw.nodes[to]
+	if !ok || nw.cut[[2]string{from, to}] {
+		return nil, errUnreachable
+	}
+	return node, nil
+}
+
+// partition cuts every link between members of different groups
+func (nw *inmemNetwork) partition(groups ...[]string) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	for i, a := range groups {
+		for j, b := range groups {
+			if i == j {
+				continue
+			}
+			for _, from := range a {
+				for _, to := range b {
+					nw.cut[[2]string{from, to}] = true
+				}
+			}
+		}
+	}
+}
+
+func (nw *inmemNetwork) heal() {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.cut = make(map[[2]string]bool)
+}
+
+type NodeTestSuite struct {
+	suite.Suite
+	testDir string
+	network *inmemNetwork
+	ids     []string
+}
+
+func TestNodeTestSuite(t *testing.T) {
+	suite.Run(t, &NodeTestSuite{})
+}
+
+func (s *NodeTestSuite) SetupTest() {
+	dir, err := os.MkdirTemp("", "raft-test")
+	s.Require().NoError(err)
+	s.testDir = dir
+	s.network = &inmemNetwork{nodes: make(map[string]*Node), cut: make(map[[2]string]bool)}
+}
+
+func (s *NodeTestSuite) TearDownTest() {
+	for _, node := range s.network.nodes {
+		err := node.Close()
+		s.Require().NoError(err)
+	}
+	err := os.RemoveAll(s.testDir)
+	s.Require().NoError(err)
+}
+
+func (s *NodeTestSuite) TestSingleNodeCommits() {
+	s.startCluster(1)
+	leader := s.waitForLeader(s.ids...)
+	off, err := leader.Append(&api.Record{Value: []byte("test input")})
+	s.Require().NoError(err)
+	// the first entry is the no-op of the elected leader
+	s.Require().Equal(uint64(1), off)
+}
+
+func (s *NodeTestSuite) TestLeaderStepsDownWhenNoopFails() {
+	s.startCluster(1)
+	node := s.network.nodes[s.ids[0]]
+	node.mu.Lock()
+	s.Require().NoError(node.log.Close())
+	node.mu.Unlock()
+
+	// the node keeps winning its own elections but never leads without the no-op entry
+	s.Require().Never(func() bool {
+		role, _, _ := node.State()
+		return role == Leader
+	}, 500*time.Millisecond, 5*time.Millisecond)
+	_, term, _ := node.State()
+	s.Require().Greater(term, uint64(1))
+}
+
+func (s *NodeTestSuite) TestFollowerStoresEveryEntry() {
+	s.ids = []string{"node-0", "node-1"}
+	follower := s.startNode("node-0", []string{"node-1"})
+	// a retry the leader appended again, e.g. after its window forgot the first attempt
+	entry := &api.Record{Term: 1, Value: []byte("test input"), ProducerId: "producer", Sequence: 1}
+	res, err := follower.AppendEntries(context.Background(), &api.AppendEntriesRequest{
+		Term:     1,
+		LeaderId: "node-1",
+		Entries:  []*api.Record{entry, entry},
+	})
+	s.Require().NoError(err)
+	s.Require().True(res.Success)
+	s.Require().Equal(uint64(2), follower.log.NextOffset())
+}
+
+func (s *NodeTestSuite) TestThreeNodeClusterReplicates() {
+	s.startCluster(3)
+	leader := s.waitForLeader(s.ids...)
+
+	for i := 0; i < 10; i++ {
+		_, err := leader.Append(&api.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+		s.Require().NoError(err)
+	}
+	s.waitForConvergence(s.ids...)
+
+	for _, id := range s.ids {
+		node := s.network.nodes[id]
+		if node == leader {
+			continue
+		}
+		_, err := node.Append(&api.Record{Value: []byte("test input")})
+		s.Require().ErrorAs(err, &ErrNotLeader{})
+		s.Require().Equal(ErrNotLeader{Leader: leader.ID}, err)
+	}
+}
+
+func (s *NodeTestSuite) TestReadOnlyReturnsCommittedEntries() {
+	s.startCluster(3)
+	leader := s.waitForLeader(s.ids...)
+	off, err := leader.Append(&api.Record{Value: []byte("test input")})
+	s.Require().NoError(err)
+
+	rec, err := leader.Read(off)
+	s.Require().NoError(err)
+	s.Require().Equal([]byte("test input"), rec.Value)
+	s.Require().Equal(api.RecordType_RECORD_TYPE_DATA, rec.Type)
+
+	rec, err = leader.Read(0)
+	s.Require().NoError(err)
+	s.Require().Equal(api.RecordType_RECORD_TYPE_NOOP, rec.Type)
+
+	_, err = leader.Read(off + 1)
+	s.Require().Error(err)
+	s.Require().Equal(off+1, leader.NextOffset())
+}
+
+func (s *NodeTestSuite) TestLeaderFailover() {
+	s.startCluster(3)
+	leader := s.waitForLeader(s.ids...)
+	_, err := leader.Append(&api.Record{Value: []byte("committed")})
+	s.Require().NoError(err)
+
+	// isolate the leader, the remaining majority elects a new one
+	rest := s.others(leader.ID)
+	s.network.partition([]string{leader.ID}, rest)
+	newLeader := s.waitForLeader(rest...)
+	s.Require().NotEqual(leader.ID, newLeader.ID)
+
+	// the old leader cannot commit and steps down
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = leader.Propose(ctx, &api.Record{Value: []byte("uncommitted")})
+	s.Require().Error(err)
+
+	_, err = newLeader.Append(&api.Record{Value: []byte("after failover")})
+	s.Require().NoError(err)
+
+	// after healing, the divergent suffix of the old leader is truncated
+	s.network.heal()
+	s.waitForConvergence(s.ids...)
+	for _, id := range s.ids {
+		for off := uint64(0); off < s.network.nodes[id].NextOffset(); off++ {
+			rec, err := s.network.nodes[id].Read(off)
+			s.Require().NoError(err)
+			s.Require().NotEqual([]byte("uncommitted"), rec.Value)
+		}
+	}
+}
+
+func (s *NodeTestSuite) TestFiveNodeClusterWithPartition() {
+	s.startCluster(5)
+	leader := s.waitForLeader(s.ids...)
+	_, err := leader.Append(&api.Record{Value: []byte("before partition")})
+	s.Require().NoError(err)
+
+	// keep the leader in the minority
+	rest := s.others(leader.ID)
+	minority := []string{leader.ID, rest[0]}
+	majority := rest[1:]
+	s.network.partition(minority, majority)
+
+	newLeader := s.waitForLeader(majority...)
+	for i := 0; i < 5; i++ {
+		_, err = newLeader.Append(&api.Record{Value: []byte(fmt.Sprintf("majority %d", i))})
+		s.Require().NoError(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = leader.Propose(ctx, &api.Record{Value: []byte("minority")})
+	s.Require().Error(err)
+
+	s.network.heal()
+	s.waitForConvergence(s.ids...)
+	last := s.network.nodes[s.ids[0]].NextOffset()
+	for _, id := range s.ids {
+		s.Require().Equal(last, s.network.nodes[id].NextOffset())
+	}
+}
+
+func (s *NodeTestSuite) TestRestartKeepsTermAndEntries() {
+	s.startCluster(3)
+	leader := s.waitForLeader(s.ids...)
+	off, err := leader.Append(&api.Record{Value: []byte("test input")})
+	s.Require().NoError(err)
+	_, term, _ := leader.State()
+
+	s.network.mu.Lock()
+	delete(s.network.nodes, leader.ID)
+	s.network.mu.Unlock()
+	err = leader.Close()
+	s.Require().NoError(err)
+
+	restarted := s.startNode(leader.ID, s.others(leader.ID))
+	_, restartedTerm, _ := restarted.State()
+	s.Require().GreaterOrEqual(restartedTerm, term)
+	s.Require().Eventually(func() bool {
+		rec, err := restarted.Read(off)
+		return err == nil && string(rec.Value) == "test input"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func (s *NodeTestSuite) startCluster(size int) {
+	s.ids = nil
+	for i := 0; i < size; i++ {
+		s.ids = append(s.ids, fmt.Sprintf("node-%d", i))
+	}
+	for _, id := range s.ids {
+		s.startNode(id, s.others(id))
+	}
+}
+
+func (s *NodeTestSuite) startNode(id string, peers []string) *Node {
+	node, err := NewNode(
+		id,
+		filepath.Join(s.testDir, id),
+		peers,
+		&inmemTransport{from: id, network: s.network},
+		WithTimeouts(10*time.Millisecond, 100*time.Millisecond),
+	)
+	s.Require().NoError(err)
+	s.network.mu.Lock()
+	s.network.nodes[id] = node
+	s.network.mu.Unlock()
+	return node
+}
+
+func (s *NodeTestSuite) others(id string) []string {
+	var peers []string
+	for _, other := range s.ids {
+		if other != id {
+			peers = append(peers, other)
+		}
+	}
+	return peers
+}
+
+// waitForLeader waits until exactly one of the given nodes leads the highest term among them
+func (s *NodeTestSuite) waitForLeader(ids ...string) *Node {
+	var leader *Node
+	s.Require().Eventually(func() bool {
+		leader = nil
+		var leaderTerm uint64
+		for _, id := range ids {
+			role, term, _ := s.network.nodes[id].State()
+			if role == Leader && term >= leaderTerm {
+				leader, leaderTerm = s.network.nodes[id], term
+			}
+		}
+		return leader != nil
+	}, 10*time.Second, 10*time.Millisecond)
+	return leader
+}
+
+// waitForConvergence waits until the given nodes committed the same entries
+func (s *NodeTestSuite) waitForConvergence(ids ...string) {
+	s.Require().Eventually(func() bool {
+		first := s.network.nodes[ids[0]]
+		for _, id := range ids[1:] {
+			if s.network.nodes[id].NextOffset() != first.NextOffset() {
+				return false
+			}
+		}
+		for off := uint64(0); off < first.NextOffset(); off++ {
+			want, err := first.Read(off)
+			if err != nil {
+				return false
+			}
+			for _, id := range ids[1:] {
+				got, err := s.network.nodes[id].Read(off)
+				if err != nil || got.Term != want.Term || string(got.Value) != string(want.Value) {
+					return false
+				}
+			}
+		}
+		return first.NextOffset() > 0
+	}, 10*time.Second, 20*time.Millisecond)
+}
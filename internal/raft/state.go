@@ -0,0 +1,53 @@
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+const stateFile = "state.json"
+
+// persistentState is the part of the Raft state that has to survive restarts
+// before a node answers any RPC
+type persistentState struct {
+	CurrentTerm uint64 `json:"current_term"`
+	VotedFor    string `json:"voted_for"`
+}
+
+func readState(dir string) (persistentState, error) {
+	var st persistentState
+	b, err := os.ReadFile(filepath.Join(dir, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	err = json.Unmarshal(b, &st)
+	return st, err
+}
+
+// writeState replaces the state file atomically and syncs it to disk
+func writeState(dir string, st persistentState) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, stateFile+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(dir, stateFile))
+}
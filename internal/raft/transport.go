@@ -0,0 +1,85 @@
+package raft
+
+import (
+	"context"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"google.golang.org/grpc"
+	"sync"
+)
+
+// Transport delivers Raft RPCs to the other members of the cluster
+type Transport interface {
+	RequestVote(ctx context.Context, target string, req *api.RequestVoteRequest) (*api.RequestVoteResponse, error)
+	AppendEntries(ctx context.Context, target string, req *api.AppendEntriesRequest) (*api.AppendEntriesResponse, error)
+}
+
+// guarantee *GRPCTransport meets Transport interface at compile time
+var _ Transport = &GRPCTransport{}
+
+// GRPCTransport sends Raft RPCs to the Raft service of the other members
+type GRPCTransport struct {
+	mu sync.Mutex
+
+	addrs       map[string]string
+	dialOptions []grpc.DialOption
+	conns       map[string]*grpc.ClientConn
+}
+
+// NewGRPCTransport returns a Transport that reaches every member id at the RPC address mapped to it
+func NewGRPCTransport(addrs map[string]string, opts ...grpc.DialOption) *GRPCTransport {
+	return &GRPCTransport{
+		addrs:       addrs,
+		dialOptions: opts,
+		conns:       make(map[string]*grpc.ClientConn),
+	}
+}
+
+func (t *GRPCTransport) RequestVote(ctx context.Context, target string, req *api.RequestVoteRequest) (
+	*api.RequestVoteResponse, error) {
+	client, err := t.client(target)
+	if err != nil {
+		return nil, err
+	}
+	return client.RequestVote(ctx, req)
+}
+
+func (t *GRPCTransport) AppendEntries(ctx context.Context, target string, req *api.AppendEntriesRequest) (
+	*api.AppendEntriesResponse, error) {
+	client, err := t.client(target)
+	if err != nil {
+		return nil, err
+	}
+	return client.AppendEntries(ctx, req)
+}
+
+// Close closes the connections to every member
+func (t *GRPCTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, conn := range t.conns {
+		if err := conn.Close(); err != nil {
+			return err
+		}
+		delete(t.conns, id)
+	}
+	return nil
+}
+
+func (t *GRPCTransport) client(target string) (api.RaftClient, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	conn, ok := t.conns[target]
+	if !ok {
+		addr, ok := t.addrs[target]
+		if !ok {
+			return nil, fmt.Errorf("unknown raft member %q", target)
+		}
+		var err error
+		if conn, err = grpc.Dial(addr, t.dialOptions...); err != nil {
+			return nil, err
+		}
+		t.conns[target] = conn
+	}
+	return api.NewRaftClient(conn), nil
+}
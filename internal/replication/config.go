@@ -0,0 +1,85 @@
+package replication
+
+import (
+	"errors"
+	"google.golang.org/grpc"
+	"time"
+)
+
+var (
+	defaultMaxLag       = 10 * time.Second
+	defaultSyncInterval = time.Second
+	defaultAsyncBuffer  = 1024
+)
+
+type options struct {
+	maxLag       *time.Duration
+	syncInterval *time.Duration
+	asyncBuffer  *int
+	dialOptions  []grpc.DialOption
+}
+
+type Options func(options *options) error
+
+// WithMaxLag sets how long a follower may go without catching up to the
+// leader before it drops out of the in-sync replicas
+func WithMaxLag(d time.Duration) Options {
+	return func(options *options) error {
+		if d <= 0 {
+			return errors.New("max lag should be a positive duration")
+		}
+		options.maxLag = &d
+		return nil
+	}
+}
+
+// WithSyncInterval sets how often a follower refreshes the topics of the
+// leader and how long it waits before reconnecting a failed stream
+func WithSyncInterval(d time.Duration) Options {
+	return func(options *options) error {
+		if d <= 0 {
+			return errors.New("sync interval should be a positive duration")
+		}
+		options.syncInterval = &d
+		return nil
+	}
+}
+
+// WithAsyncBuffer sets how many records produced without acknowledgement
+// may be queued per partition before producers are blocked
+func WithAsyncBuffer(n int) Options {
+	return func(options *options) error {
+		if n < 1 {
+			return errors.New("async buffer should hold at least one record")
+		}
+		options.asyncBuffer = &n
+		return nil
+	}
+}
+
+// WithDialOptions sets the options a follower dials the leader with
+func WithDialOptions(opts ...grpc.DialOption) Options {
+	return func(options *options) error {
+		options.dialOptions = append(options.dialOptions, opts...)
+		return nil
+	}
+}
+
+func newOptions(opts []Options) (options, error) {
+	var rOpts options
+	for _, opt := range opts {
+		if err := opt(&rOpts); err != nil {
+			return rOpts, err
+		}
+	}
+	if rOpts.maxLag == nil {
+		rOpts.maxLag = &defaultMaxLag
+	}
+	if rOpts.syncInterval == nil {
+		rOpts.syncInterval = &defaultSyncInterval
+	}
+	if rOpts.asyncBuffer == nil {
+		rOpts.asyncBuffer = &defaultAsyncBuffer
+	}
+	return rOpts, nil
+}
@@ -0,0 +1,207 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/topic"
+	"google.golang.org/grpc"
+	"sync"
+	"time"
+)
+
+// Follower mirrors every topic of a leader server into the local topics.
+// Each partition is pulled through a Replicate stream, appended to the local
+// Log with the offsets the leader assigned, and acknowledged back to the leader
+type Follower struct {
+	mu sync.Mutex
+
+	ID          string
+	LeaderAddr  string
+	topics      *topic.Manager
+	conn        *grpc.ClientConn
+	client      api.LogClient
+	replicators map[Partition]context.CancelFunc
+	hwms        map[Partition]uint64
+	options     options
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+// NewFollower connects to the leader at leaderAddr and starts replicating its topics into topics
+func NewFollower(id string, leaderAddr string, topics *topic.Manager, opts ...Options) (*Follower, error) {
+	rOpts, err := newOptions(opts)
+	if err != nil {
+		return nil, fmt.Errorf("error on replication follower creation: %v", err)
+	}
+	if id == "" {
+		return nil, errors.New("follower id should not be empty")
+	}
+	conn, err := grpc.Dial(leaderAddr, rOpts.dialOptions...)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &Follower{
+		ID:          id,
+		LeaderAddr:  leaderAddr,
+		topics:      topics,
+		conn:        conn,
+		client:      api.NewLogClient(conn),
+		replicators: make(map[Partition]context.CancelFunc),
+		hwms:        make(map[Partition]uint64),
+		options:     rOpts,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	f.wg.Add(1)
+	go f.run()
+	return f, nil
+}
+
+// HighWatermark returns the last high watermark the leader reported for the partition
+func (f *Follower) HighWatermark(p Partition) uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hwms[p]
+}
+
+// Close stops replicating and closes the connection to the leader
+func (f *Follower) Close() error {
+	f.cancel()
+	f.wg.Wait()
+	return f.conn.Close()
+}
+
+func (f *Follower) run() {
+	defer f.wg.Done()
+	ticker := time.NewTicker(*f.options.syncInterval)
+	defer ticker.Stop()
+	for {
+		// a failed sync is retried on the next tick
+		_ = f.sync()
+		select {
+		case <-ticker.C:
+		case <-f.ctx.Done():
+			return
+		}
+	}
+}
+
+// sync mirrors the topics of the leader and makes sure every partition has a running replicator
+func (f *Follower) sync() error {
+	res, err := f.client.ListTopics(f.ctx, &api.ListTopicsRequest{})
+	if err != nil {
+		return err
+	}
+	leaderTopics := make(map[string]bool)
+	for _, t := range res.Topics {
+		leaderTopics[t.Name] = true
+		local, err := f.topics.Get(t.Name)
+		if errors.As(err, &topic.ErrTopicNotFound{}) {
+			if _, err = f.topics.Create(t.Name, t.Partitions, t.Partitioner); err != nil {
+				return err
+			}
+			local, err = f.topics.Get(t.Name)
+		}
+		if err != nil {
+			return err
+		}
+		for p := uint32(0); p < local.Partitions; p++ {
+			f.startReplicator(Partition{Topic: t.Name, Partition: p})
+		}
+	}
+	for _, meta := range f.topics.List() {
+		if !leaderTopics[meta.Name] && meta.Name != topic.DefaultTopic {
+			f.stopReplicators(meta.Name)
+			if err = f.topics.Delete(meta.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *Follower) startReplicator(p Partition) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.replicators[p]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(f.ctx)
+	f.replicators[p] = cancel
+	f.wg.Add(1)
+	go f.replicate(ctx, p)
+}
+
+func (f *Follower) stopReplicators(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for p, cancel := range f.replicators {
+		if p.Topic == name {
+			cancel()
+			delete(f.replicators, p)
+			delete(f.hwms, p)
+		}
+	}
+}
+
+// replicate keeps a Replicate stream open for the partition, reconnecting after failures
+func (f *Follower) replicate(ctx context.Context, p Partition) {
+	defer f.wg.Done()
+	for {
+		_ = f.stream(ctx, p)
+		select {
+		case <-time.After(*f.options.syncInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (f *Follower) stream(ctx context.Context, p Partition) error {
+	t, err := f.topics.Get(p.Topic)
+	if err != nil {
+		return err
+	}
+	l, err := t.Partition(p.Partition)
+	if err != nil {
+		return err
+	}
+
+	stream, err := f.client.Replicate(ctx)
+	if err != nil {
+		return err
+	}
+	if err = stream.Send(&api.ReplicateRequest{
+		ReplicaId: f.ID,
+		Topic:     p.Topic,
+		Partition: p.Partition,
+		Offset:    l.NextOffset(),
+	}); err != nil {
+		return err
+	}
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if res.Record != nil {
+			if res.Record.Offset != l.NextOffset() {
+				return fmt.Errorf("replica diverged from leader: expected offset %d, received %d",
+					l.NextOffset(), res.Record.Offset)
+			}
+			if _, err = l.AppendReplica(res.Record); err != nil {
+				return err
+			}
+			if err = stream.Send(&api.ReplicateRequest{Offset: l.NextOffset()}); err != nil {
+				return err
+			}
+		}
+		f.mu.Lock()
+		f.hwms[p] = res.HighWatermark
+		f.mu.Unlock()
+	}
+}
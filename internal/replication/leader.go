@@ -0,0 +1,260 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"sync"
+	"time"
+)
+
+// Partition identifies a replicated partition of a topic
+type Partition struct {
+	Topic     string
+	Partition uint32
+}
+
+// Log is the part of a partition's log the leader needs to replicate it
+type Log interface {
+	Append(record *api.Record) (uint64, error)
+	NextOffset() uint64
+}
+
+type replicaState struct {
+	// offset is the next offset the replica needs, every record before it is stored
+	offset       uint64
+	lastCaughtUp time.Time
+}
+
+type asyncAppend struct {
+	log    Log
+	record *api.Record
+}
+
+type partitionState struct {
+	replicas map[string]*replicaState
+	hwm      uint64
+	// changed is closed and replaced whenever records are appended or a replica reports progress
+	changed chan struct{}
+	async   chan asyncAppend
+	// removed is closed when the partition is removed, which stops its background appends
+	removed chan struct{}
+}
+
+// Leader tracks the followers that replicate the partitions of this server.
+// It maintains the high watermark of each partition, the offset below which
+// every in-sync replica stored the records, and makes producers wait for it
+type Leader struct {
+	mu sync.Mutex
+
+	partitions map[Partition]*partitionState
+	options    options
+	closed     chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewLeader returns a Leader that has no followers yet
+func NewLeader(opts ...Options) (*Leader, error) {
+	rOpts, err := newOptions(opts)
+	if err != nil {
+		return nil, fmt.Errorf("error on replication leader creation: %v", err)
+	}
+	return &Leader{
+		partitions: make(map[Partition]*partitionState),
+		options:    rOpts,
+		closed:     make(chan struct{}),
+	}, nil
+}
+
+// Append stores the record in the partition's log and waits for as many
+// replicas as acks requires. Records produced with ACKS_NONE are queued and
+// appended in order in the background, so their offset is not known
+func (l *Leader) Append(ctx context.Context, p Partition, log Log, record *api.Record, acks api.Acks) (uint64, error) {
+	if acks == api.Acks_ACKS_NONE {
+		return 0, l.enqueue(ctx, p, log, record)
+	}
+	off, err := log.Append(record)
+	if err != nil {
+		return 0, err
+	}
+	l.notify(p)
+	if acks != api.Acks_ACKS_ALL {
+		return off, nil
+	}
+
+	for {
+		changed := l.Changed(p)
+		if l.HighWatermark(p, log) > off {
+			return off, nil
+		}
+		// ISR membership expires with time, so re-evaluate even without progress
+		timer := time.NewTimer(*l.options.maxLag)
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return off, ctx.Err()
+		case <-l.closed:
+			timer.Stop()
+			return off, fmt.Errorf("replication leader closed")
+		}
+		timer.Stop()
+	}
+}
+
+// HighWatermark returns the offset below which every in-sync replica stored the records
+// of the partition. Followers that lag behind for longer than the max lag are not in sync
+func (l *Leader) HighWatermark(p Partition, log Log) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state := l.state(p)
+	hwm := log.NextOffset()
+	now := time.Now()
+	for _, r := range state.replicas {
+		if now.Sub(r.lastCaughtUp) <= *l.options.maxLag && r.offset < hwm {
+			hwm = r.offset
+		}
+	}
+	// a replica falling out of sync never moves the high watermark backwards,
+	// but it never passes the end of the log either
+	if floor := min(state.hwm, log.NextOffset()); hwm < floor {
+		hwm = floor
+	}
+	state.hwm = hwm
+	return hwm
+}
+
+// Report records that the replica stored every record of the partition before offset
+func (l *Leader) Report(p Partition, replicaID string, offset uint64, log Log) {
+	l.mu.Lock()
+	state := l.state(p)
+	r, ok := state.replicas[replicaID]
+	if !ok {
+		r = &replicaState{}
+		state.replicas[replicaID] = r
+	}
+	r.offset = offset
+	if offset >= log.NextOffset() {
+		r.lastCaughtUp = time.Now()
+	}
+	l.mu.Unlock()
+	l.notify(p)
+}
+
+// Unregister removes a replica that stopped replicating the partition from the in-sync replicas
+func (l *Leader) Unregister(p Partition, replicaID string) {
+	l.mu.Lock()
+	delete(l.state(p).replicas, replicaID)
+	l.mu.Unlock()
+	l.notify(p)
+}
+
+// Remove forgets the replicas and the high watermark of every partition of the
+// topic, such as when it is deleted, so a topic created under the same name starts over
+func (l *Leader) Remove(topic string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for p, state := range l.partitions {
+		if p.Topic != topic {
+			continue
+		}
+		delete(l.partitions, p)
+		close(state.removed)
+		// producers waiting for the partition re-evaluate it against the new state
+		close(state.changed)
+	}
+}
+
+// Replicas returns the ids of the replicas that currently replicate the partition
+func (l *Leader) Replicas(p Partition) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var ids []string
+	for id := range l.state(p).replicas {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Changed returns a channel that is closed the next time records are appended
+// to the partition or one of its replicas reports progress
+func (l *Leader) Changed(p Partition) <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state(p).changed
+}
+
+// Close stops appending queued records and wakes up waiting producers
+func (l *Leader) Close() error {
+	l.mu.Lock()
+	select {
+	case <-l.closed:
+	default:
+		close(l.closed)
+	}
+	l.mu.Unlock()
+	l.wg.Wait()
+	return nil
+}
+
+func (l *Leader) notify(p Partition) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state := l.state(p)
+	close(state.changed)
+	state.changed = make(chan struct{})
+}
+
+func (l *Leader) enqueue(ctx context.Context, p Partition, log Log, record *api.Record) error {
+	l.mu.Lock()
+	state := l.state(p)
+	if state.async == nil {
+		state.async = make(chan asyncAppend, *l.options.asyncBuffer)
+		l.wg.Add(1)
+		go l.appendAsync(p, state.async, state.removed)
+	}
+	async := state.async
+	l.mu.Unlock()
+
+	select {
+	case async <- asyncAppend{log: log, record: record}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.closed:
+		return fmt.Errorf("replication leader closed")
+	}
+}
+
+// appendAsync appends the queued records of a partition in the order they were produced.
+// Producers did not ask for an acknowledgement, so failed appends are dropped
+func (l *Leader) appendAsync(p Partition, async <-chan asyncAppend, removed <-chan struct{}) {
+	defer l.wg.Done()
+	for {
+		select {
+		case a := <-async:
+			if _, err := a.log.Append(a.record); err == nil {
+				l.notify(p)
+			}
+		case <-l.closed:
+			return
+		case <-removed:
+			return
+		}
+	}
+}
+
+func (l *Leader) state(p Partition) *partitionState {
+	state, ok := l.partitions[p]
+	if !ok {
+		state = &partitionState{
+			replicas: make(map[string]*replicaState),
+			changed:  make(chan struct{}),
+			removed:  make(chan struct{}),
+		}
+		l.partitions[p] = state
+	}
+	return state
+}
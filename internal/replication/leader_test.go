@@ -0,0 +1,142 @@
+package replication
+
+import (
+	"context"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/log"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"testing"
+	"time"
+)
+
+var testPartition = Partition{Topic: "orders", Partition: 0}
+
+type LeaderTestSuite struct {
+	suite.Suite
+	testDir string
+	log     *log.Log
+	leader  *Leader
+}
+
+func TestLeaderTestSuite(t *testing.T) {
+	suite.Run(t, &LeaderTestSuite{})
+}
+
+func (s *LeaderTestSuite) SetupTest() {
+	dir, err := os.MkdirTemp("", "replication-leader-test")
+	s.Require().NoError(err)
+	s.testDir = dir
+	s.log, err = log.NewLog(dir)
+	s.Require().NoError(err)
+	s.leader, err = NewLeader(WithMaxLag(time.Second))
+	s.Require().NoError(err)
+}
+
+func (s *LeaderTestSuite) TearDownTest() {
+	err := s.leader.Close()
+	s.Require().NoError(err)
+	err = s.log.Remove()
+	s.Require().NoError(err)
+}
+
+func (s *LeaderTestSuite) TestHighWatermarkWithoutReplicas() {
+	off, err := s.leader.Append(context.Background(), testPartition, s.log, s.record(), api.Acks_ACKS_ALL)
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(0), off)
+	s.Require().Equal(uint64(1), s.leader.HighWatermark(testPartition, s.log))
+}
+
+func (s *LeaderTestSuite) TestHighWatermarkFollowsSlowestInSyncReplica() {
+	s.leader.Report(testPartition, "follower-1", 0, s.log)
+	s.leader.Report(testPartition, "follower-2", 0, s.log)
+	for i := 0; i < 3; i++ {
+		_, err := s.leader.Append(context.Background(), testPartition, s.log, s.record(), api.Acks_ACKS_LEADER)
+		s.Require().NoError(err)
+	}
+	s.Require().Equal(uint64(0), s.leader.HighWatermark(testPartition, s.log))
+
+	s.leader.Report(testPartition, "follower-1", 3, s.log)
+	s.leader.Report(testPartition, "follower-2", 2, s.log)
+	s.Require().Equal(uint64(2), s.leader.HighWatermark(testPartition, s.log))
+
+	// an unregistered replica no longer holds the high watermark back
+	s.leader.Unregister(testPartition, "follower-2")
+	s.Require().Equal(uint64(3), s.leader.HighWatermark(testPartition, s.log))
+	s.Require().Equal([]string{"follower-1"}, s.leader.Replicas(testPartition))
+}
+
+func (s *LeaderTestSuite) TestRemovedTopicStartsOver() {
+	for i := 0; i < 3; i++ {
+		_, err := s.leader.Append(context.Background(), testPartition, s.log, s.record(), api.Acks_ACKS_LEADER)
+		s.Require().NoError(err)
+	}
+	s.leader.Report(testPartition, "follower-1", 3, s.log)
+	s.Require().Equal(uint64(3), s.leader.HighWatermark(testPartition, s.log))
+
+	// the topic is deleted and created again with an empty log
+	s.leader.Remove(testPartition.Topic)
+	s.Require().Empty(s.leader.Replicas(testPartition))
+	s.Require().NoError(s.log.Reset())
+	s.Require().Equal(uint64(0), s.leader.HighWatermark(testPartition, s.log))
+}
+
+func (s *LeaderTestSuite) TestHighWatermarkNeverPassesLogEnd() {
+	for i := 0; i < 3; i++ {
+		_, err := s.leader.Append(context.Background(), testPartition, s.log, s.record(), api.Acks_ACKS_LEADER)
+		s.Require().NoError(err)
+	}
+	s.Require().Equal(uint64(3), s.leader.HighWatermark(testPartition, s.log))
+	s.Require().NoError(s.log.TruncateAfter(0))
+	s.Require().Equal(uint64(1), s.leader.HighWatermark(testPartition, s.log))
+}
+
+func (s *LeaderTestSuite) TestAcksAllWaitsForReplicas() {
+	s.leader.Report(testPartition, "follower-1", 0, s.log)
+
+	done := make(chan uint64)
+	go func() {
+		off, err := s.leader.Append(context.Background(), testPartition, s.log, s.record(), api.Acks_ACKS_ALL)
+		s.Require().NoError(err)
+		done <- off
+	}()
+
+	select {
+	case <-done:
+		s.Fail("produce returned before the replica acknowledged")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.leader.Report(testPartition, "follower-1", 1, s.log)
+	select {
+	case off := <-done:
+		s.Require().Equal(uint64(0), off)
+	case <-time.After(time.Second):
+		s.Fail("produce did not return after the replica acknowledged")
+	}
+}
+
+func (s *LeaderTestSuite) TestLaggingReplicaDropsOutOfSync() {
+	s.leader.Report(testPartition, "follower-1", 0, s.log)
+	_, err := s.leader.Append(context.Background(), testPartition, s.log, s.record(), api.Acks_ACKS_LEADER)
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(0), s.leader.HighWatermark(testPartition, s.log))
+
+	// the replica never catches up within the max lag
+	s.Require().Eventually(func() bool {
+		return s.leader.HighWatermark(testPartition, s.log) == 1
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func (s *LeaderTestSuite) TestAcksNoneAppendsInBackground() {
+	for i := 0; i < 3; i++ {
+		_, err := s.leader.Append(context.Background(), testPartition, s.log, s.record(), api.Acks_ACKS_NONE)
+		s.Require().NoError(err)
+	}
+	s.Require().Eventually(func() bool {
+		return s.log.NextOffset() == 3
+	}, time.Second, 10*time.Millisecond)
+}
+
+func (s *LeaderTestSuite) record() *api.Record {
+	return &api.Record{Value: []byte("test input")}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionalphapb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// bearerPrefix starts the authorization metadata of calls that carry a token
+const bearerPrefix = "Bearer "
+
+// methodActions maps every RPC to the action it is authorized as. RPCs that
+// are not listed, such as the ones served between the members of a
+// cluster, are authorized as admin
+var methodActions = map[string]string{
+	api.Log_Produce_FullMethodName:       auth.ActionProduce,
+	api.Log_ProduceStream_FullMethodName: auth.ActionProduce,
+	api.Log_Consume_FullMethodName:       auth.ActionConsume,
+	api.Log_ConsumeStream_FullMethodName: auth.ActionConsume,
+	// only the start command of a session is authorized, the later ones act on the topic it named
+	api.Log_ConsumeSession_FullMethodName: auth.ActionConsume,
+	api.Log_CommitOffset_FullMethodName:   auth.ActionConsume,
+	api.Log_FetchOffset_FullMethodName:    auth.ActionConsume,
+	api.Log_ListTopics_FullMethodName:     auth.ActionConsume,
+	api.Log_GetServers_FullMethodName:     auth.ActionConsume,
+	// transactions are not bound to a topic, so they are authorized on every topic
+	api.Log_BeginTxn_FullMethodName:    auth.ActionProduce,
+	api.Log_CommitTxn_FullMethodName:   auth.ActionProduce,
+	api.Log_AbortTxn_FullMethodName:    auth.ActionProduce,
+	api.Log_CreateTopic_FullMethodName: auth.ActionAdmin,
+	api.Log_DeleteTopic_FullMethodName: auth.ActionAdmin,
+}
+
+// publicMethods are served to every caller, load balancers and operators probe
+// them without an identity that a policy could name
+var publicMethods = map[string]bool{
+	healthpb.Health_Check_FullMethodName:                                   true,
+	healthpb.Health_Watch_FullMethodName:                                   true,
+	reflectionpb.ServerReflection_ServerReflectionInfo_FullMethodName:      true,
+	reflectionalphapb.ServerReflection_ServerReflectionInfo_FullMethodName: true,
+}
+
+type topicRequest interface {
+	GetTopic() string
+}
+
+type nameRequest interface {
+	GetName() string
+}
+
+// authenticateUnary attaches the principal of the caller to the context of every unary call
+func authenticateUnary(tokens *auth.Tokens) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (
+		interface{}, error) {
+		ctx, err := authenticate(ctx, tokens, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// authenticateStream attaches the principal of the caller to the context of every stream
+func authenticateStream(tokens *auth.Tokens) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(stream.Context(), tokens, info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticate proves the identity of the caller with the bearer token in the
+// call metadata, or with its client certificate when it sent no token. Callers
+// with neither are only served the public methods. The
+// actions of a token are enforced here so that they hold without an Authorizer,
+// req is nil for streams
+func authenticate(ctx context.Context, tokens *auth.Tokens, method string, req interface{}) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		sub := subject(ctx)
+		if sub == "" && !publicMethods[method] {
+			return nil, status.Error(codes.Unauthenticated, "caller should present a bearer token or a client certificate")
+		}
+		return auth.NewContext(ctx, auth.Principal{Subject: sub}), nil
+	}
+	if !strings.HasPrefix(values[0], bearerPrefix) {
+		return nil, status.Error(codes.Unauthenticated, "authorization metadata should hold a bearer token")
+	}
+	claims, err := tokens.Verify(strings.TrimPrefix(values[0], bearerPrefix))
+	if err != nil {
+		return nil, err
+	}
+	principal := auth.Principal{Subject: claims.Subject, Actions: claims.Actions}
+	if action := methodAction(method); !publicMethods[method] && !principal.Allows(action) {
+		return nil, auth.ErrPermissionDenied{Subject: principal.Subject, Resource: resource(req), Action: action}
+	}
+	return auth.NewContext(ctx, principal), nil
+}
+
+// authorizeUnary authorizes every unary call against the topic of its request
+func authorizeUnary(authorizer *auth.Authorizer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (
+		interface{}, error) {
+		if err := authorize(ctx, authorizer, info.FullMethod, req); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// authorizeStream authorizes every message received on a stream, since
+// each message of a stream may name a different topic
+func authorizeStream(authorizer *auth.Authorizer) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &authorizedStream{
+			ServerStream: stream,
+			authorizer:   authorizer,
+			method:       info.FullMethod,
+		})
+	}
+}
+
+type authorizedStream struct {
+	grpc.ServerStream
+	authorizer *auth.Authorizer
+	method     string
+}
+
+func (s *authorizedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if cmd, ok := m.(*api.ConsumeSessionRequest); ok && cmd.GetStart() == nil {
+		return nil
+	}
+	return authorize(s.Context(), s.authorizer, s.method, m)
+}
+
+func authorize(ctx context.Context, authorizer *auth.Authorizer, method string, req interface{}) error {
+	if publicMethods[method] {
+		return nil
+	}
+	principal, ok := auth.FromContext(ctx)
+	if !ok {
+		principal = auth.Principal{Subject: subject(ctx)}
+	}
+	return authorizer.Authorize(principal.Subject, resource(req), methodAction(method))
+}
+
+// methodAction returns the action a call of method is authorized as
+func methodAction(method string) string {
+	if action, ok := methodActions[method]; ok {
+		return action
+	}
+	return auth.ActionAdmin
+}
+
+// resource returns the topic a request operates on, requests that are not
+// about a single topic operate on every topic
+func resource(req interface{}) string {
+	switch r := req.(type) {
+	case *api.ListTopicsRequest, *api.GetServersRequest:
+		return auth.Wildcard
+	case *api.ConsumeSessionRequest:
+		return topicName(r.GetStart().GetTopic())
+	case topicRequest:
+		return topicName(r.GetTopic())
+	case nameRequest:
+		return r.GetName()
+	default:
+		return auth.Wildcard
+	}
+}
+
+// subject returns the common name of the verified client certificate of the
+// caller, or an empty subject when the caller did not present one
+func subject(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"context"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/auth"
+	"github.com/a-shakra/commit-log/internal/config"
+	"github.com/a-shakra/commit-log/internal/topic"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type AuthTestSuite struct {
+	suite.Suite
+	dir          string
+	policyFile   string
+	authorizer   *auth.Authorizer
+	topics       *topic.Manager
+	server       *grpc.Server
+	rootConn     *grpc.ClientConn
+	nobodyConn   *grpc.ClientConn
+	rootClient   api.LogClient
+	nobodyClient api.LogClient
+	tokens       *auth.Tokens
+	tokenConn    *grpc.ClientConn
+	tokenClient  api.LogClient
+}
+
+func TestAuthTestSuite(t *testing.T) {
+	suite.Run(t, &AuthTestSuite{})
+}
+
+func (s *AuthTestSuite) SetupTest() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	s.dir, err = os.MkdirTemp("", "server-auth-test")
+	s.Require().NoError(err)
+	s.topics, err = topic.NewManager(filepath.Join(s.dir, "topics"))
+	s.Require().NoError(err)
+	s.policyFile = filepath.Join(s.dir, "policy.csv")
+	s.writePolicy("root,*,*\nnobody,default,consume\n", time.Now())
+	s.authorizer, err = auth.NewAuthorizer(s.policyFile)
+	s.Require().NoError(err)
+	secretFile := filepath.Join(s.dir, "token-secret")
+	err = os.WriteFile(secretFile, []byte("0123456789abcdef0123456789abcdef"), 0600)
+	s.Require().NoError(err)
+	s.tokens, err = auth.NewTokens(secretFile)
+	s.Require().NoError(err)
+
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:           config.ServerCertFile,
+		KeyFile:            config.ServerKeyFile,
+		CAFile:             config.CAFile,
+		ServerAddress:      listener.Addr().String(),
+		Server:             true,
+		ClientCertOptional: true,
+	})
+	s.Require().NoError(err)
+	s.server, err = NewGrpcServer(
+		&Config{Topics: s.topics, Authorizer: s.authorizer, Tokens: s.tokens},
+		grpc.Creds(credentials.NewTLS(serverTLSConfig)),
+	)
+	s.Require().NoError(err)
+	go func() {
+		_ = s.server.Serve(listener)
+	}()
+
+	s.rootConn, s.rootClient = s.newClient(listener.Addr().String(), config.RootClientCertFile, config.RootClientKeyFile)
+	s.nobodyConn, s.nobodyClient = s.newClient(
+		listener.Addr().String(), config.NobodyClientCertFile, config.NobodyClientKeyFile)
+	s.tokenConn, s.tokenClient = s.newClient(listener.Addr().String(), "", "")
+}
+
+func (s *AuthTestSuite) TearDownTest() {
+	s.Require().NoError(s.rootConn.Close())
+	s.Require().NoError(s.nobodyConn.Close())
+	s.Require().NoError(s.tokenConn.Close())
+	s.server.Stop()
+	s.Require().NoError(s.authorizer.Close())
+	s.Require().NoError(s.topics.Close())
+	s.Require().NoError(os.RemoveAll(s.dir))
+}
+
+func (s *AuthTestSuite) TestAuthorizedSubject() {
+	ctx := context.Background()
+	produce, err := s.rootClient.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("test input")}})
+	s.Require().NoError(err)
+	_, err = s.rootClient.CreateTopic(ctx, &api.CreateTopicRequest{Name: "orders"})
+	s.Require().NoError(err)
+
+	consume, err := s.nobodyClient.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	s.Require().NoError(err)
+	s.Require().Equal([]byte("test input"), consume.Record.Value)
+}
+
+func (s *AuthTestSuite) TestUnauthorizedSubject() {
+	ctx := context.Background()
+	_, err := s.nobodyClient.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("test input")}})
+	s.Require().Equal(codes.PermissionDenied, status.Code(err))
+	_, err = s.nobodyClient.CreateTopic(ctx, &api.CreateTopicRequest{Name: "orders"})
+	s.Require().Equal(codes.PermissionDenied, status.Code(err))
+
+	_, err = s.rootClient.CreateTopic(ctx, &api.CreateTopicRequest{Name: "orders"})
+	s.Require().NoError(err)
+	_, err = s.nobodyClient.Consume(ctx, &api.ConsumeRequest{Topic: "orders"})
+	s.Require().Equal(codes.PermissionDenied, status.Code(err))
+
+	// every message of a stream is authorized against the topic it names
+	stream, err := s.nobodyClient.ProduceStream(ctx)
+	s.Require().NoError(err)
+	err = stream.Send(&api.ProduceRequest{Record: &api.Record{Value: []byte("test input")}})
+	s.Require().NoError(err)
+	_, err = stream.Recv()
+	s.Require().Equal(codes.PermissionDenied, status.Code(err))
+}
+
+func (s *AuthTestSuite) TestConsumeSession() {
+	ctx := context.Background()
+	_, err := s.rootClient.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("test input")}})
+	s.Require().NoError(err)
+	_, err = s.rootClient.CreateTopic(ctx, &api.CreateTopicRequest{Name: "orders"})
+	s.Require().NoError(err)
+
+	// the start command is authorized against its topic, the commands after it are not authorized again
+	stream, err := s.nobodyClient.ConsumeSession(ctx)
+	s.Require().NoError(err)
+	s.Require().NoError(stream.Send(startCommand(&api.ConsumeRequest{})))
+	s.Require().NoError(stream.Send(creditCommand(1, 0)))
+	rec, err := stream.Recv()
+	for err == nil && rec.GetRecord() == nil {
+		rec, err = stream.Recv()
+	}
+	s.Require().NoError(err)
+	s.Require().Equal([]byte("test input"), rec.GetRecord().Value)
+
+	stream, err = s.nobodyClient.ConsumeSession(ctx)
+	s.Require().NoError(err)
+	s.Require().NoError(stream.Send(startCommand(&api.ConsumeRequest{Topic: "orders"})))
+	_, err = stream.Recv()
+	s.Require().Equal(codes.PermissionDenied, status.Code(err))
+}
+
+func (s *AuthTestSuite) TestPublicServices() {
+	ctx := context.Background()
+	// callers without an identity probe health and list services
+	res, err := healthpb.NewHealthClient(s.tokenConn).Check(ctx, &healthpb.HealthCheckRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(healthpb.HealthCheckResponse_SERVING, res.Status)
+
+	stream, err := reflectionpb.NewServerReflectionClient(s.tokenConn).ServerReflectionInfo(ctx)
+	s.Require().NoError(err)
+	err = stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	s.Require().NoError(err)
+	info, err := stream.Recv()
+	s.Require().NoError(err)
+	s.Require().NotEmpty(info.GetListServicesResponse().Service)
+	s.Require().NoError(stream.CloseSend())
+}
+
+func (s *AuthTestSuite) TestPolicyReloads() {
+	ctx := context.Background()
+	req := &api.ProduceRequest{Record: &api.Record{Value: []byte("test input")}}
+	_, err := s.nobodyClient.Produce(ctx, req)
+	s.Require().Equal(codes.PermissionDenied, status.Code(err))
+
+	s.writePolicy("root,*,*\nnobody,default,*\n", time.Now().Add(time.Minute))
+	s.Require().NoError(s.authorizer.Reload())
+	_, err = s.nobodyClient.Produce(ctx, req)
+	s.Require().NoError(err)
+}
+
+func (s *AuthTestSuite) TestBearerToken() {
+	req := &api.ProduceRequest{Record: &api.Record{Value: []byte("test input")}}
+	// clients without a certificate or token have no identity to be authorized as
+	_, err := s.tokenClient.Produce(context.Background(), req)
+	s.Require().Equal(codes.Unauthenticated, status.Code(err))
+
+	produce, err := s.tokenClient.Produce(s.withToken(auth.Claims{Subject: "root"}), req)
+	s.Require().NoError(err)
+
+	// tokens are authorized like certificates with the same subject
+	ctx := s.withToken(auth.Claims{Subject: "nobody"})
+	_, err = s.tokenClient.Produce(ctx, req)
+	s.Require().Equal(codes.PermissionDenied, status.Code(err))
+	consume, err := s.tokenClient.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	s.Require().NoError(err)
+	s.Require().Equal([]byte("test input"), consume.Record.Value)
+
+	stream, err := s.tokenClient.ConsumeStream(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	s.Require().NoError(err)
+	res, err := stream.Recv()
+	s.Require().NoError(err)
+	s.Require().Equal([]byte("test input"), res.Record.Value)
+}
+
+func (s *AuthTestSuite) TestBearerTokenActions() {
+	// the actions of a token narrow down what the policy allows its subject
+	ctx := s.withToken(auth.Claims{Subject: "root", Actions: []string{auth.ActionConsume}})
+	_, err := s.tokenClient.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("test input")}})
+	s.Require().Equal(codes.PermissionDenied, status.Code(err))
+	_, err = s.tokenClient.ListTopics(ctx, &api.ListTopicsRequest{})
+	s.Require().NoError(err)
+}
+
+func (s *AuthTestSuite) TestBearerTokenActionsWithoutAuthorizer() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	server, err := NewGrpcServer(&Config{Topics: s.topics, Tokens: s.tokens})
+	s.Require().NoError(err)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	s.Require().NoError(err)
+	defer conn.Close()
+	client := api.NewLogClient(conn)
+
+	// without a policy the actions of a token are all that limits its subject
+	ctx := s.withToken(auth.Claims{Subject: "nobody", Actions: []string{auth.ActionConsume}})
+	_, err = client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("test input")}})
+	s.Require().Equal(codes.PermissionDenied, status.Code(err))
+	stream, err := client.ProduceStream(ctx)
+	s.Require().NoError(err)
+	_, err = stream.Recv()
+	s.Require().Equal(codes.PermissionDenied, status.Code(err))
+	_, err = client.ListTopics(ctx, &api.ListTopicsRequest{})
+	s.Require().NoError(err)
+
+	// anonymous callers of an insecure connection have no identity at all
+	_, err = client.Produce(context.Background(), &api.ProduceRequest{Record: &api.Record{Value: []byte("test input")}})
+	s.Require().Equal(codes.Unauthenticated, status.Code(err))
+}
+
+func (s *AuthTestSuite) TestInvalidBearerToken() {
+	for _, value := range []string{"Bearer not-a-token", "Basic cm9vdDpyb290"} {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", value)
+		_, err := s.tokenClient.ListTopics(ctx, &api.ListTopicsRequest{})
+		s.Require().Equal(codes.Unauthenticated, status.Code(err))
+	}
+
+	claims := auth.Claims{Subject: "root", ExpiresAt: time.Now().Add(-time.Minute).Unix()}
+	token, err := s.tokens.Sign(claims)
+	s.Require().NoError(err)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	_, err = s.tokenClient.ListTopics(ctx, &api.ListTopicsRequest{})
+	s.Require().Equal(codes.Unauthenticated, status.Code(err))
+}
+
+// withToken returns a context that sends a bearer token carrying the claims, valid for an hour
+func (s *AuthTestSuite) withToken(claims auth.Claims) context.Context {
+	claims.ExpiresAt = time.Now().Add(time.Hour).Unix()
+	token, err := s.tokens.Sign(claims)
+	s.Require().NoError(err)
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func (s *AuthTestSuite) newClient(addr string, certFile string, keyFile string) (*grpc.ClientConn, api.LogClient) {
+	tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		CAFile:   config.CAFile,
+	})
+	s.Require().NoError(err)
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	s.Require().NoError(err)
+	return conn, api.NewLogClient(conn)
+}
+
+func (s *AuthTestSuite) writePolicy(policy string, modTime time.Time) {
+	err := os.WriteFile(s.policyFile, []byte(policy), 0644)
+	s.Require().NoError(err)
+	err = os.Chtimes(s.policyFile, modTime, modTime)
+	s.Require().NoError(err)
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/raft"
+	"github.com/a-shakra/commit-log/internal/topic"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type consensusNode struct {
+	id        string
+	dir       string
+	topics    *topic.Manager
+	raft      *raft.Node
+	transport *raft.GRPCTransport
+	server    *grpc.Server
+	ccon      *grpc.ClientConn
+	client    api.LogClient
+}
+
+type ConsensusTestSuite struct {
+	suite.Suite
+	nodes []*consensusNode
+}
+
+func TestConsensusTestSuite(t *testing.T) {
+	suite.Run(t, &ConsensusTestSuite{})
+}
+
+func (s *ConsensusTestSuite) SetupTest() {
+	s.nodes = nil
+	listeners := make(map[string]net.Listener)
+	addrs := make(map[string]string)
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("node-%d", i)
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		s.Require().NoError(err)
+		listeners[id] = listener
+		addrs[id] = listener.Addr().String()
+	}
+
+	for id, listener := range listeners {
+		var peers []string
+		for peer := range addrs {
+			if peer != id {
+				peers = append(peers, peer)
+			}
+		}
+		dir, err := os.MkdirTemp("", "server-consensus-test")
+		s.Require().NoError(err)
+		topics, err := topic.NewManager(filepath.Join(dir, "topics"))
+		s.Require().NoError(err)
+		transport := raft.NewGRPCTransport(addrs, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		node, err := raft.NewNode(id, filepath.Join(dir, "raft"), peers, transport,
+			raft.WithTimeouts(20*time.Millisecond, 200*time.Millisecond))
+		s.Require().NoError(err)
+		server, err := NewGrpcServer(&Config{Topics: topics, Consensus: node})
+		s.Require().NoError(err)
+		go func(listener net.Listener) {
+			_ = server.Serve(listener)
+		}(listener)
+		ccon, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		s.Require().NoError(err)
+		s.nodes = append(s.nodes, &consensusNode{
+			id:        id,
+			dir:       dir,
+			topics:    topics,
+			raft:      node,
+			transport: transport,
+			server:    server,
+			ccon:      ccon,
+			client:    api.NewLogClient(ccon),
+		})
+	}
+}
+
+func (s *ConsensusTestSuite) TearDownTest() {
+	for _, node := range s.nodes {
+		s.stopNode(node)
+	}
+}
+
+func (s *ConsensusTestSuite) TestProduceReturnsOnceCommitted() {
+	ctx := context.Background()
+	leader := s.waitForLeader(s.nodes)
+	var offsets []uint64
+	for i := 0; i < 3; i++ {
+		res, err := leader.client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte(fmt.Sprintf("record %d", i))},
+		})
+		s.Require().NoError(err)
+		offsets = append(offsets, res.Offset)
+	}
+
+	// every member serves the committed records once the leader reported its commit index
+	for _, node := range s.nodes {
+		s.Require().Eventually(func() bool {
+			res, err := node.client.Consume(ctx, &api.ConsumeRequest{Offset: offsets[2]})
+			return err == nil && string(res.Record.Value) == "record 2"
+		}, 5*time.Second, 20*time.Millisecond)
+	}
+
+	stream, err := s.nodes[0].client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	s.Require().NoError(err)
+	for i := 0; i < 3; i++ {
+		res, err := stream.Recv()
+		s.Require().NoError(err)
+		// the no-op entry written on election is not delivered to consumers
+		s.Require().Equal(api.RecordType_RECORD_TYPE_DATA, res.Record.Type)
+		s.Require().Equal(offsets[i], res.Record.Offset)
+	}
+}
+
+func (s *ConsensusTestSuite) TestFollowerRejectsWrites() {
+	leader := s.waitForLeader(s.nodes)
+	for _, node := range s.nodes {
+		if node == leader {
+			continue
+		}
+		s.Require().Eventually(func() bool {
+			_, _, known := node.raft.State()
+			return known == leader.id
+		}, 5*time.Second, 10*time.Millisecond)
+		_, err := node.client.Produce(context.Background(), &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("test api record")},
+		})
+		s.Require().Equal(codes.FailedPrecondition, status.Code(err))
+	}
+}
+
+func (s *ConsensusTestSuite) TestLeaderFailover() {
+	ctx := context.Background()
+	leader := s.waitForLeader(s.nodes)
+	req := &api.ProduceRequest{
+		Record:     &api.Record{Value: []byte("before failover")},
+		ProducerId: "producer",
+		Sequence:   1,
+	}
+	produce, err := leader.client.Produce(ctx, req)
+	s.Require().NoError(err)
+
+	var rest []*consensusNode
+	for _, node := range s.nodes {
+		if node != leader {
+			rest = append(rest, node)
+		}
+	}
+	s.stopNode(leader)
+	s.nodes = rest
+
+	newLeader := s.waitForLeader(s.nodes)
+	// the producer retries against the new leader, which replicated the record of the old one
+	retry, err := newLeader.client.Produce(ctx, req)
+	s.Require().NoError(err)
+	s.Require().Equal(produce.Offset, retry.Offset)
+	res, err := newLeader.client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("after failover")},
+	})
+	s.Require().NoError(err)
+	s.Require().Greater(res.Offset, produce.Offset)
+
+	consume, err := newLeader.client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	s.Require().NoError(err)
+	s.Require().Equal([]byte("before failover"), consume.Record.Value)
+}
+
+func (s *ConsensusTestSuite) waitForLeader(nodes []*consensusNode) *consensusNode {
+	var leader *consensusNode
+	s.Require().Eventually(func() bool {
+		for _, node := range nodes {
+			if role, _, _ := node.raft.State(); role == raft.Leader {
+				leader = node
+				return true
+			}
+		}
+		return false
+	}, 10*time.Second, 10*time.Millisecond)
+	return leader
+}
+
+func (s *ConsensusTestSuite) stopNode(node *consensusNode) {
+	s.Require().NoError(node.ccon.Close())
+	node.server.Stop()
+	s.Require().NoError(node.raft.Close())
+	s.Require().NoError(node.transport.Close())
+	s.Require().NoError(node.topics.Close())
+	s.Require().NoError(os.RemoveAll(node.dir))
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"errors"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/topic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"net"
+	"strings"
+	"time"
+)
+
+// logServicePrefix starts the full method name of every RPC of the Log service
+var logServicePrefix = "/" + api.Log_ServiceDesc.ServiceName + "/"
+
+// NewHealth returns a health server that reports NOT_SERVING for the server
+// and its Log service. Passed in the Config, it lets the grpc server be started
+// before the logs are opened and recovered: the Log service rejects calls with
+// Unavailable until SetServing marks the server ready
+func NewHealth() *health.Server {
+	h := health.NewServer()
+	SetServing(h, false)
+	return h
+}
+
+// SetServing reports whether the server and its Log service are serving,
+// such as around opening the logs or recovering their segments
+func SetServing(h *health.Server, serving bool) {
+	st := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		st = healthpb.HealthCheckResponse_SERVING
+	}
+	h.SetServingStatus("", st)
+	h.SetServingStatus(api.Log_ServiceDesc.ServiceName, st)
+}
+
+// drainTimeout bounds how long Drain waits for pending calls, streams that
+// tail a partition only end when their client cancels them
+var drainTimeout = 10 * time.Second
+
+// Drain reports NOT_SERVING, so orchestrators and load balancers stop sending
+// calls, and then stops the server once its pending calls finished. Calls
+// still pending after the drain timeout are canceled. The health status stays
+// NOT_SERVING afterwards
+func Drain(gServer *grpc.Server, h *health.Server) {
+	h.Shutdown()
+	timer := time.AfterFunc(drainTimeout, gServer.Stop)
+	defer timer.Stop()
+	gServer.GracefulStop()
+}
+
+// OpenTopics sets the Topics of a server started with a Health that reports
+// NOT_SERVING and then marks it serving. Calls only read Topics once the server
+// serves, so Topics may not be set after NewGrpcServer in any other way. Stores
+// recovered along with the topics, such as Offsets, are set before calling it
+func (c *Config) OpenTopics(topics *topic.Manager) error {
+	if c.Health == nil {
+		return errors.New("topics may only be opened after the server started when it has a health server")
+	}
+	res, err := c.Health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: api.Log_ServiceDesc.ServiceName})
+	if err == nil && res.Status == healthpb.HealthCheckResponse_SERVING {
+		return errors.New("topics may not be replaced while the server is serving")
+	}
+	c.Topics = topics
+	SetServing(c.Health, true)
+	return nil
+}
+
+// Serve serves calls on listener until ctx is done and then drains the server,
+// see Drain. It returns the error Serve of the grpc server failed with, or nil
+// once the server drained
+func Serve(ctx context.Context, gServer *grpc.Server, h *health.Server, listener net.Listener) error {
+	served := make(chan error, 1)
+	go func() {
+		served <- gServer.Serve(listener)
+	}()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+		Drain(gServer, h)
+		return nil
+	}
+}
+
+// readyUnary rejects unary calls to the Log service while it is not serving
+func readyUnary(h *health.Server) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (
+		interface{}, error) {
+		if err := ready(ctx, h, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// readyStream rejects streams of the Log service while it is not serving
+func readyStream(h *health.Server) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := ready(stream.Context(), h, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+func ready(ctx context.Context, h *health.Server, method string) error {
+	if !strings.HasPrefix(method, logServicePrefix) {
+		return nil
+	}
+	res, err := h.Check(ctx, &healthpb.HealthCheckRequest{Service: api.Log_ServiceDesc.ServiceName})
+	if err != nil || res.Status != healthpb.HealthCheckResponse_SERVING {
+		return status.Error(codes.Unavailable, "server is not ready to serve the log")
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/topic"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type HealthTestSuite struct {
+	suite.Suite
+	dir          string
+	config       *Config
+	health       *health.Server
+	server       *grpc.Server
+	ccon         *grpc.ClientConn
+	client       api.LogClient
+	healthClient healthpb.HealthClient
+}
+
+func TestHealthTestSuite(t *testing.T) {
+	suite.Run(t, &HealthTestSuite{})
+}
+
+func (s *HealthTestSuite) SetupTest() {
+	s.dir = s.T().TempDir()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	// the server starts before its logs are opened
+	s.health = NewHealth()
+	s.config = &Config{Health: s.health}
+	s.server, err = NewGrpcServer(s.config)
+	s.Require().NoError(err)
+	go func() {
+		_ = s.server.Serve(listener)
+	}()
+
+	s.ccon, err = grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	s.Require().NoError(err)
+	s.client = api.NewLogClient(s.ccon)
+	s.healthClient = healthpb.NewHealthClient(s.ccon)
+}
+
+func (s *HealthTestSuite) TearDownTest() {
+	s.Require().NoError(s.ccon.Close())
+	s.server.Stop()
+	if s.config.Topics != nil {
+		s.Require().NoError(s.config.Topics.Close())
+	}
+}
+
+func (s *HealthTestSuite) TestReadiness() {
+	ctx := context.Background()
+	for _, service := range []string{"", api.Log_ServiceDesc.ServiceName} {
+		res, err := s.healthClient.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		s.Require().NoError(err)
+		s.Require().Equal(healthpb.HealthCheckResponse_NOT_SERVING, res.Status)
+	}
+	req := &api.ProduceRequest{Record: &api.Record{Value: []byte("test input")}}
+	_, err := s.client.Produce(ctx, req)
+	s.Require().Equal(codes.Unavailable, status.Code(err))
+
+	s.openTopics()
+	res, err := s.healthClient.Check(ctx, &healthpb.HealthCheckRequest{Service: api.Log_ServiceDesc.ServiceName})
+	s.Require().NoError(err)
+	s.Require().Equal(healthpb.HealthCheckResponse_SERVING, res.Status)
+	_, err = s.client.Produce(ctx, req)
+	s.Require().NoError(err)
+}
+
+func (s *HealthTestSuite) TestOpenTopicsOnce() {
+	s.openTopics()
+	// the topics of a serving server are in use by its calls
+	err := s.config.OpenTopics(s.config.Topics)
+	s.Require().Error(err)
+
+	err = (&Config{}).OpenTopics(s.config.Topics)
+	s.Require().Error(err)
+}
+
+func (s *HealthTestSuite) TestServe() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	h := NewHealth()
+	gServer, err := NewGrpcServer(&Config{Health: h})
+	s.Require().NoError(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, gServer, h, listener)
+	}()
+
+	cancel()
+	select {
+	case err = <-served:
+		s.Require().NoError(err)
+	case <-time.After(5 * time.Second):
+		s.Fail("serve did not return once its context was done")
+	}
+	res, err := h.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(healthpb.HealthCheckResponse_NOT_SERVING, res.Status)
+}
+
+func (s *HealthTestSuite) TestReflection() {
+	stream, err := reflectionpb.NewServerReflectionClient(s.ccon).ServerReflectionInfo(context.Background())
+	s.Require().NoError(err)
+	err = stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	s.Require().NoError(err)
+	res, err := stream.Recv()
+	s.Require().NoError(err)
+	var services []string
+	for _, service := range res.GetListServicesResponse().Service {
+		services = append(services, service.Name)
+	}
+	s.Require().Contains(services, api.Log_ServiceDesc.ServiceName)
+	s.Require().Contains(services, healthpb.Health_ServiceDesc.ServiceName)
+	s.Require().NoError(stream.CloseSend())
+}
+
+func (s *HealthTestSuite) TestDrain() {
+	s.openTopics()
+	// pending streams hold the drain until they finish
+	ctx, cancel := context.WithCancel(context.Background())
+	watch, err := s.healthClient.Watch(ctx, &healthpb.HealthCheckRequest{})
+	s.Require().NoError(err)
+	res, err := watch.Recv()
+	s.Require().NoError(err)
+	s.Require().Equal(healthpb.HealthCheckResponse_SERVING, res.Status)
+	consume, err := s.client.ConsumeStream(ctx, &api.ConsumeRequest{})
+	s.Require().NoError(err)
+	_, err = consume.Header()
+	s.Require().NoError(err)
+
+	drained := make(chan struct{})
+	go func() {
+		Drain(s.server, s.health)
+		close(drained)
+	}()
+	res, err = watch.Recv()
+	s.Require().NoError(err)
+	s.Require().Equal(healthpb.HealthCheckResponse_NOT_SERVING, res.Status)
+	select {
+	case <-drained:
+		s.Fail("drain returned before the pending streams finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		s.Fail("drain did not return after the pending streams finished")
+	}
+}
+
+func (s *HealthTestSuite) TestDrainTimeout() {
+	s.openTopics()
+	timeout := drainTimeout
+	drainTimeout = 100 * time.Millisecond
+	defer func() { drainTimeout = timeout }()
+	// a stream tailing the partition never finishes on its own
+	consume, err := s.client.ConsumeStream(context.Background(), &api.ConsumeRequest{})
+	s.Require().NoError(err)
+	_, err = consume.Header()
+	s.Require().NoError(err)
+
+	drained := make(chan struct{})
+	go func() {
+		Drain(s.server, s.health)
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		s.Fail("drain did not stop the server after its timeout")
+	}
+	_, err = consume.Recv()
+	s.Require().Error(err)
+}
+
+// openTopics opens the logs of the server and marks it ready
+func (s *HealthTestSuite) openTopics() {
+	topics, err := topic.NewManager(filepath.Join(s.dir, "topics"))
+	s.Require().NoError(err)
+	s.Require().NoError(s.config.OpenTopics(topics))
+}
@@ -0,0 +1,396 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/log"
+	"github.com/a-shakra/commit-log/internal/metrics"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxHTTPBody matches the default maximum message size of the grpc server
+	maxHTTPBody = 4 << 20
+
+	contentTypeJSON   = "application/json"
+	contentTypeText   = "text/plain"
+	contentTypeBinary = "application/octet-stream"
+)
+
+// httpJSON writes zero values too, so offset 0 and partition 0 are not left out of responses
+var httpJSON = protojson.MarshalOptions{EmitUnpopulated: true}
+
+// httpStatuses maps the codes of the errors returned by the grpc handlers onto HTTP statuses
+var httpStatuses = map[codes.Code]int{
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+}
+
+// httpGateway serves JSON endpoints that mirror the produce and consume RPCs.
+// Calls go through the handlers of the grpc server, so they are authenticated,
+// authorized and replicated the same way
+type httpGateway struct {
+	server *grpcServer
+	// eventStreams holds a token per open event stream, it is nil without MaxEventStreams
+	eventStreams chan struct{}
+}
+
+// NewHTTPServer returns the HTTP/JSON gateway of the server for the HTTPAddr of
+// the config. tlsConfig is the one of the grpc server, nil serves plain HTTP.
+//
+//	POST /v1/produce        produces the body as a record
+//	POST /v1/produce/batch  produces a JSON array of requests, or one record per line of text
+//	GET  /v1/consume        returns the record at the offset query parameter, the first
+//	                        committed one at or after it with isolation=read_committed
+//	GET  /v1/consume/events tails a partition from the offset query parameter as Server-Sent Events,
+//	                        at most MaxEventStreams at once
+//	GET  /metrics           serves the metrics of the server in the Prometheus text format
+//
+// JSON bodies carry values base64 encoded. text/plain and application/octet-stream
+// bodies are raw values, their topic, partition, key, producer_id and sequence are read
+// from the query
+func NewHTTPServer(config *Config, tlsConfig *tls.Config) (*http.Server, error) {
+	if config.HTTPAddr == "" {
+		return nil, errors.New("http gateway is not enabled, set HTTPAddr in the server config")
+	}
+	server, err := newGrpcServer(config)
+	if err != nil {
+		return nil, err
+	}
+	g := &httpGateway{server: server}
+	if config.MaxEventStreams > 0 {
+		g.eventStreams = make(chan struct{}, config.MaxEventStreams)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/produce", g.handleProduce)
+	mux.HandleFunc("/v1/produce/batch", g.handleProduceBatch)
+	mux.HandleFunc("/v1/consume", g.handleConsume)
+	mux.HandleFunc("/v1/consume/events", g.handleEvents)
+	mux.Handle("/metrics", metrics.Default)
+	return &http.Server{Addr: config.HTTPAddr, Handler: mux, TLSConfig: tlsConfig}, nil
+}
+
+func (g *httpGateway) handleProduce(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxHTTPBody))
+	if err != nil {
+		writeHTTPError(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	req := &api.ProduceRequest{}
+	switch mediaType(r.Header.Get("Content-Type")) {
+	case contentTypeJSON:
+		if err = protojson.Unmarshal(body, req); err != nil {
+			err = status.Error(codes.InvalidArgument, err.Error())
+		}
+	case contentTypeText, contentTypeBinary, "":
+		req, err = rawProduceRequest(r, body)
+	default:
+		err = status.Errorf(codes.InvalidArgument, "unsupported content type %q", r.Header.Get("Content-Type"))
+	}
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	res, err := g.produce(r, req)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTPMessage(w, res)
+}
+
+// handleProduceBatch produces the records of the batch in order. It stops at
+// the first record that fails, the records before it stay produced. When some
+// of them were produced it answers 207 Multi-Status with their results
+// followed by the error of the failed record
+func (g *httpGateway) handleProduceBatch(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxHTTPBody))
+	if err != nil {
+		writeHTTPError(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	var reqs []*api.ProduceRequest
+	switch mediaType(r.Header.Get("Content-Type")) {
+	case contentTypeJSON:
+		reqs, err = jsonProduceRequests(body)
+	case contentTypeText, "":
+		for _, line := range bytes.Split(body, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			req, err := rawProduceRequest(r, line)
+			if err != nil {
+				writeHTTPError(w, err)
+				return
+			}
+			reqs = append(reqs, req)
+		}
+	default:
+		err = status.Errorf(codes.InvalidArgument, "unsupported content type %q", r.Header.Get("Content-Type"))
+	}
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	results := make([]json.RawMessage, 0, len(reqs))
+	for _, req := range reqs {
+		res, err := g.produce(r, req)
+		if err == nil {
+			var b []byte
+			if b, err = httpJSON.Marshal(res); err == nil {
+				results = append(results, b)
+				continue
+			}
+		}
+		if len(results) == 0 {
+			writeHTTPError(w, err)
+			return
+		}
+		_, body := httpError(err)
+		b, _ := json.Marshal(body)
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(http.StatusMultiStatus)
+		_ = json.NewEncoder(w).Encode(append(results, b))
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	_ = json.NewEncoder(w).Encode(results)
+}
+
+// handleConsume returns the record as JSON, or its raw value when the
+// request accepts text/plain or application/octet-stream
+func (g *httpGateway) handleConsume(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	query := r.URL.Query()
+	req := &api.ConsumeRequest{Topic: query.Get("topic")}
+	var err error
+	if req.Offset, err = parseUint(query.Get("offset"), 64); err != nil {
+		writeHTTPError(w, status.Errorf(codes.InvalidArgument, "invalid offset: %v", err))
+		return
+	}
+	partition, err := parseUint(query.Get("partition"), 32)
+	if err != nil {
+		writeHTTPError(w, status.Errorf(codes.InvalidArgument, "invalid partition: %v", err))
+		return
+	}
+	req.Partition = uint32(partition)
+	if req.IsolationLevel, err = parseIsolation(query.Get("isolation")); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	ctx, err := g.context(r, api.Log_Consume_FullMethodName, req)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	res, err := g.server.Consume(ctx, req)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	accept := r.Header.Get("Accept")
+	for _, contentType := range []string{contentTypeBinary, contentTypeText} {
+		if strings.Contains(accept, contentType) {
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("X-Offset", strconv.FormatUint(res.Record.Offset, 10))
+			w.Header().Set("X-High-Watermark", strconv.FormatUint(res.HighWatermark, 10))
+			_, _ = w.Write(res.Record.Value)
+			return
+		}
+	}
+	writeHTTPMessage(w, res)
+}
+
+func (g *httpGateway) produce(r *http.Request, req *api.ProduceRequest) (*api.ProduceResponse, error) {
+	if req.Record == nil {
+		return nil, status.Error(codes.InvalidArgument, "produce request should hold a record")
+	}
+	ctx, err := g.context(r, api.Log_Produce_FullMethodName, req)
+	if err != nil {
+		return nil, err
+	}
+	return g.server.Produce(ctx, req)
+}
+
+// context authenticates and authorizes the request like the interceptors of
+// the grpc server do for the call of method
+func (g *httpGateway) context(r *http.Request, method string, req interface{}) (context.Context, error) {
+	ctx := r.Context()
+	if r.TLS != nil {
+		addr, _ := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr, AuthInfo: credentials.TLSInfo{State: *r.TLS}})
+	}
+	if value := r.Header.Get("Authorization"); value != "" {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", value))
+	}
+	var err error
+	if g.server.Health != nil {
+		if err = ready(ctx, g.server.Health, method); err != nil {
+			return nil, err
+		}
+	}
+	if g.server.Tokens != nil {
+		if ctx, err = authenticate(ctx, g.server.Tokens, method, req); err != nil {
+			return nil, err
+		}
+	}
+	if g.server.Authorizer != nil {
+		if err = authorize(ctx, g.server.Authorizer, method, req); err != nil {
+			return nil, err
+		}
+	}
+	return ctx, nil
+}
+
+// rawProduceRequest builds a request for the value, addressed by the query of r
+func rawProduceRequest(r *http.Request, value []byte) (*api.ProduceRequest, error) {
+	query := r.URL.Query()
+	req := &api.ProduceRequest{
+		Record: &api.Record{Value: value},
+		Topic:  query.Get("topic"),
+	}
+	if key := query.Get("key"); key != "" {
+		req.Record.Key = []byte(key)
+	}
+	if query.Has("producer_id") {
+		sequence, err := parseUint(query.Get("sequence"), 64)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid sequence: %v", err)
+		}
+		req.ProducerId = query.Get("producer_id")
+		req.Sequence = sequence
+	}
+	if query.Has("partition") {
+		partition, err := parseUint(query.Get("partition"), 32)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid partition: %v", err)
+		}
+		p := uint32(partition)
+		req.Partition = &p
+	}
+	return req, nil
+}
+
+func jsonProduceRequests(body []byte) ([]*api.ProduceRequest, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "batch should be a JSON array: %v", err)
+	}
+	reqs := make([]*api.ProduceRequest, 0, len(raw))
+	for _, b := range raw {
+		req := &api.ProduceRequest{}
+		if err := protojson.Unmarshal(b, req); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	return false
+}
+
+func writeHTTPMessage(w http.ResponseWriter, m proto.Message) {
+	b, err := httpJSON.Marshal(m)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	_, _ = w.Write(b)
+}
+
+// httpErrorBody is the JSON body of a failed request
+type httpErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeHTTPError writes the status of err as a JSON body with the matching HTTP status
+func writeHTTPError(w http.ResponseWriter, err error) {
+	code, body := httpError(err)
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// httpError returns the HTTP status matching err and the body describing it
+func httpError(err error) (int, httpErrorBody) {
+	st := status.Convert(err)
+	code, ok := httpStatuses[st.Code()]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+	var outOfRange log.ErrOffsetOutOfRange
+	if errors.As(err, &outOfRange) {
+		code = http.StatusNotFound
+	}
+	return code, httpErrorBody{Code: st.Code().String(), Message: st.Message()}
+}
+
+func mediaType(contentType string) string {
+	t, _, _ := mime.ParseMediaType(contentType)
+	return t
+}
+
+// parseUint parses a query parameter, treating a missing one as 0
+func parseUint(s string, bitSize int) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(s, 10, bitSize)
+}
+
+// parseIsolation reads the isolation query parameter of consume requests
+func parseIsolation(s string) (api.IsolationLevel, error) {
+	switch s {
+	case "", "read_uncommitted":
+		return api.IsolationLevel_ISOLATION_LEVEL_READ_UNCOMMITTED, nil
+	case "read_committed":
+		return api.IsolationLevel_ISOLATION_LEVEL_READ_COMMITTED, nil
+	default:
+		return 0, status.Errorf(codes.InvalidArgument,
+			"invalid isolation %q, expected read_committed or read_uncommitted", s)
+	}
+}
@@ -0,0 +1,326 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/a-shakra/commit-log/internal/auth"
+	"github.com/a-shakra/commit-log/internal/config"
+	"github.com/a-shakra/commit-log/internal/topic"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type HTTPTestSuite struct {
+	suite.Suite
+	topics       *topic.Manager
+	authorizer   *auth.Authorizer
+	server       *http.Server
+	baseURL      string
+	rootClient   *http.Client
+	nobodyClient *http.Client
+}
+
+func TestHTTPTestSuite(t *testing.T) {
+	suite.Run(t, &HTTPTestSuite{})
+}
+
+func (s *HTTPTestSuite) SetupTest() {
+	dir := s.T().TempDir()
+	var err error
+	s.topics, err = topic.NewManager(filepath.Join(dir, "topics"))
+	s.Require().NoError(err)
+	policyFile := filepath.Join(dir, "policy.csv")
+	err = os.WriteFile(policyFile, []byte("root,*,*\nnobody,default,consume\n"), 0644)
+	s.Require().NoError(err)
+	s.authorizer, err = auth.NewAuthorizer(policyFile)
+	s.Require().NoError(err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.ServerCertFile,
+		KeyFile:  config.ServerKeyFile,
+		CAFile:   config.CAFile,
+		Server:   true,
+	})
+	s.Require().NoError(err)
+	s.server, err = NewHTTPServer(&Config{
+		Topics:     s.topics,
+		Authorizer: s.authorizer,
+		HTTPAddr:   listener.Addr().String(),
+	}, tlsConfig)
+	s.Require().NoError(err)
+	go func() {
+		_ = s.server.ServeTLS(listener, "", "")
+	}()
+
+	s.baseURL = "https://" + listener.Addr().String()
+	s.rootClient = s.newClient(config.RootClientCertFile, config.RootClientKeyFile)
+	s.nobodyClient = s.newClient(config.NobodyClientCertFile, config.NobodyClientKeyFile)
+}
+
+func (s *HTTPTestSuite) TearDownTest() {
+	s.Require().NoError(s.server.Close())
+	s.Require().NoError(s.authorizer.Close())
+	s.Require().NoError(s.topics.Close())
+}
+
+func (s *HTTPTestSuite) TestProduceConsumeText() {
+	res := s.do(s.rootClient, http.MethodPost, "/v1/produce", "text/plain", "hello world")
+	s.Require().Equal(http.StatusOK, res.StatusCode)
+	s.Require().JSONEq(`{"offset":"0","partition":0}`, s.body(res))
+
+	req, err := http.NewRequest(http.MethodGet, s.baseURL+"/v1/consume?offset=0", nil)
+	s.Require().NoError(err)
+	req.Header.Set("Accept", "text/plain")
+	res, err = s.nobodyClient.Do(req)
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusOK, res.StatusCode)
+	s.Require().Equal("0", res.Header.Get("X-Offset"))
+	s.Require().Equal("1", res.Header.Get("X-High-Watermark"))
+	s.Require().Equal("hello world", s.body(res))
+}
+
+func (s *HTTPTestSuite) TestProduceConsumeJSON() {
+	// JSON bodies carry values base64 encoded
+	res := s.do(s.rootClient, http.MethodPost, "/v1/produce", "application/json",
+		`{"record":{"value":"aGVsbG8gd29ybGQ=","key":"a2V5"}}`)
+	s.Require().Equal(http.StatusOK, res.StatusCode)
+	s.body(res)
+
+	res = s.do(s.nobodyClient, http.MethodGet, "/v1/consume?offset=0", "", "")
+	s.Require().Equal(http.StatusOK, res.StatusCode)
+	var consume struct {
+		Record struct {
+			Value []byte `json:"value"`
+			Key   []byte `json:"key"`
+		} `json:"record"`
+	}
+	s.Require().NoError(json.Unmarshal([]byte(s.body(res)), &consume))
+	s.Require().Equal("hello world", string(consume.Record.Value))
+	s.Require().Equal("key", string(consume.Record.Key))
+}
+
+func (s *HTTPTestSuite) TestProduceBatch() {
+	_, err := s.topics.Create("orders", 2, "")
+	s.Require().NoError(err)
+
+	res := s.do(s.rootClient, http.MethodPost, "/v1/produce/batch?topic=orders&partition=1", "text/plain",
+		"first\nsecond\n")
+	s.Require().Equal(http.StatusOK, res.StatusCode)
+	s.Require().JSONEq(`[{"offset":"0","partition":1},{"offset":"1","partition":1}]`, s.body(res))
+
+	res = s.do(s.rootClient, http.MethodPost, "/v1/produce/batch", "application/json",
+		`[{"record":{"value":"dGhpcmQ="},"topic":"orders","partition":1},{"record":{"value":"Zm91cnRo"}}]`)
+	s.Require().Equal(http.StatusOK, res.StatusCode)
+	s.Require().JSONEq(`[{"offset":"2","partition":1},{"offset":"0","partition":0}]`, s.body(res))
+
+	// the records before a failed one stay produced and are reported with its error
+	res = s.do(s.rootClient, http.MethodPost, "/v1/produce/batch", "application/json",
+		`[{"record":{"value":"Zmlyc3Q="},"topic":"orders","partition":1},{"record":{"value":"c2Vjb25k"},"topic":"orders","partition":7}]`)
+	s.Require().Equal(http.StatusMultiStatus, res.StatusCode)
+	var results []map[string]interface{}
+	s.Require().NoError(json.Unmarshal([]byte(s.body(res)), &results))
+	s.Require().Len(results, 2)
+	s.Require().Equal("3", results[0]["offset"])
+	s.Require().Equal("NotFound", results[1]["code"])
+
+	// nothing was produced when the first record fails
+	res = s.do(s.rootClient, http.MethodPost, "/v1/produce/batch", "application/json",
+		`[{"record":{"value":"c2Vjb25k"},"topic":"orders","partition":7}]`)
+	s.Require().Equal(http.StatusNotFound, res.StatusCode)
+	s.body(res)
+}
+
+func (s *HTTPTestSuite) TestErrorStatuses() {
+	res := s.do(s.rootClient, http.MethodGet, "/v1/consume?offset=5", "", "")
+	s.Require().Equal(http.StatusNotFound, res.StatusCode)
+	s.body(res)
+
+	res = s.do(s.nobodyClient, http.MethodPost, "/v1/produce", "text/plain", "hello world")
+	s.Require().Equal(http.StatusForbidden, res.StatusCode)
+	s.Require().JSONEq(`{"code":"PermissionDenied","message":"\"nobody\" is not permitted to produce on \"default\""}`,
+		s.body(res))
+
+	res = s.do(s.rootClient, http.MethodPost, "/v1/produce", "application/json", `{"record":`)
+	s.Require().Equal(http.StatusBadRequest, res.StatusCode)
+	s.body(res)
+
+	res = s.do(s.rootClient, http.MethodGet, "/v1/produce", "", "")
+	s.Require().Equal(http.StatusMethodNotAllowed, res.StatusCode)
+	s.body(res)
+}
+
+func (s *HTTPTestSuite) TestEvents() {
+	for _, value := range []string{"first", "second", "third"} {
+		res := s.do(s.rootClient, http.MethodPost, "/v1/produce", "text/plain", value)
+		s.Require().Equal(http.StatusOK, res.StatusCode)
+		s.body(res)
+	}
+
+	res := s.do(s.nobodyClient, http.MethodGet, "/v1/consume/events?limit=2", "", "")
+	s.Require().Equal(http.StatusOK, res.StatusCode)
+	s.Require().Equal("text/event-stream", res.Header.Get("Content-Type"))
+	events := s.events(res)
+	s.Require().Equal([]string{"0", "1"}, events)
+
+	// a reconnecting EventSource resumes after the last event it saw
+	req, err := http.NewRequest(http.MethodGet, s.baseURL+"/v1/consume/events?limit=1", nil)
+	s.Require().NoError(err)
+	req.Header.Set("Last-Event-ID", "1")
+	res, err = s.nobodyClient.Do(req)
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"2"}, s.events(res))
+}
+
+func (s *HTTPTestSuite) TestEventsFollowAndHeartbeat() {
+	heartbeat := sseHeartbeat
+	sseHeartbeat = 10 * time.Millisecond
+	defer func() { sseHeartbeat = heartbeat }()
+
+	res := s.do(s.nobodyClient, http.MethodGet, "/v1/consume/events?limit=1", "", "")
+	s.Require().Equal(http.StatusOK, res.StatusCode)
+	defer res.Body.Close()
+	lines := bufio.NewScanner(res.Body)
+	s.Require().True(lines.Scan())
+	s.Require().Equal(": heartbeat", lines.Text())
+
+	produce := s.do(s.rootClient, http.MethodPost, "/v1/produce", "text/plain", "live")
+	s.Require().Equal(http.StatusOK, produce.StatusCode)
+	s.body(produce)
+	for lines.Scan() {
+		if strings.HasPrefix(lines.Text(), "data: ") {
+			s.Require().Contains(lines.Text(), base64.StdEncoding.EncodeToString([]byte("live")))
+			return
+		}
+	}
+	s.Fail("stream ended without the produced record")
+}
+
+func (s *HTTPTestSuite) TestEventsServerLimits() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	srv, err := NewHTTPServer(&Config{
+		Topics:             s.topics,
+		HTTPAddr:           listener.Addr().String(),
+		MaxEventStreams:    1,
+		MaxEventsPerStream: 1,
+	}, nil)
+	s.Require().NoError(err)
+	go func() {
+		_ = srv.Serve(listener)
+	}()
+	defer srv.Close()
+	baseURL := "http://" + listener.Addr().String()
+
+	for _, value := range []string{"first", "second"} {
+		res := s.do(s.rootClient, http.MethodPost, "/v1/produce", "text/plain", value)
+		s.Require().Equal(http.StatusOK, res.StatusCode)
+		s.body(res)
+	}
+	// the server caps the records of a stream below the limit the client asked for
+	res, err := http.Get(baseURL + "/v1/consume/events?limit=5")
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"0"}, s.events(res))
+
+	// a second stream is rejected while the first one is open
+	open, err := http.Get(baseURL + "/v1/consume/events?offset=2")
+	s.Require().NoError(err)
+	defer open.Body.Close()
+	s.Require().Equal(http.StatusOK, open.StatusCode)
+	rejected, err := http.Get(baseURL + "/v1/consume/events?offset=2")
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusTooManyRequests, rejected.StatusCode)
+	s.body(rejected)
+}
+
+func (s *HTTPTestSuite) TestEventsUnauthorized() {
+	res := s.do(s.nobodyClient, http.MethodGet, "/v1/consume/events?topic=orders", "", "")
+	s.Require().Equal(http.StatusForbidden, res.StatusCode)
+	s.body(res)
+}
+
+func (s *HTTPTestSuite) TestMetrics() {
+	res := s.do(s.rootClient, http.MethodPost, "/v1/produce", "text/plain", "hello world")
+	s.Require().Equal(http.StatusOK, res.StatusCode)
+	s.body(res)
+
+	res = s.do(s.nobodyClient, http.MethodGet, "/metrics", "", "")
+	s.Require().Equal(http.StatusOK, res.StatusCode)
+	body := s.body(res)
+	s.Require().Contains(body, "# TYPE commitlog_log_append_seconds histogram")
+	s.Require().Contains(body, fmt.Sprintf(`commitlog_log_segments{dir="%s"} 1`,
+		filepath.Join(s.topics.Dir, "default", "0")))
+}
+
+func (s *HTTPTestSuite) TestMetricsListener() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	srv, err := NewMetricsServer(&Config{MetricsAddr: listener.Addr().String()})
+	s.Require().NoError(err)
+	go func() {
+		_ = srv.Serve(listener)
+	}()
+	defer srv.Close()
+
+	res, err := http.Get("http://" + listener.Addr().String() + "/metrics")
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusOK, res.StatusCode)
+	s.Require().Contains(s.body(res), "# TYPE commitlog_grpc_requests_total counter")
+
+	_, err = NewMetricsServer(&Config{})
+	s.Require().Error(err)
+}
+
+// events reads the ids of the record events of a stream until it ends
+func (s *HTTPTestSuite) events(res *http.Response) []string {
+	defer res.Body.Close()
+	var ids []string
+	lines := bufio.NewScanner(res.Body)
+	for lines.Scan() {
+		if id, ok := strings.CutPrefix(lines.Text(), "id: "); ok {
+			ids = append(ids, id)
+		}
+	}
+	s.Require().NoError(lines.Err())
+	return ids
+}
+
+func (s *HTTPTestSuite) do(client *http.Client, method string, path string, contentType string, body string) *http.Response {
+	req, err := http.NewRequest(method, s.baseURL+path, bytes.NewBufferString(body))
+	s.Require().NoError(err)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := client.Do(req)
+	s.Require().NoError(err)
+	return res
+}
+
+func (s *HTTPTestSuite) body(res *http.Response) string {
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	s.Require().NoError(err)
+	return string(b)
+}
+
+func (s *HTTPTestSuite) newClient(certFile string, keyFile string) *http.Client {
+	tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      certFile,
+		KeyFile:       keyFile,
+		CAFile:        config.CAFile,
+		ServerAddress: "127.0.0.1",
+	})
+	s.Require().NoError(err)
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+}
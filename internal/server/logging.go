@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"github.com/a-shakra/commit-log/internal/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type offsetMessage interface {
+	GetOffset() uint64
+}
+
+type partitionMessage interface {
+	GetPartition() uint32
+}
+
+// DefaultRequestLogLevel logs calls that succeeded at debug level, calls the
+// client got wrong at info level and calls the server failed at error level
+func DefaultRequestLogLevel(code codes.Code) slog.Level {
+	switch code {
+	case codes.OK:
+		return slog.LevelDebug
+	case codes.Canceled, codes.InvalidArgument, codes.NotFound, codes.AlreadyExists,
+		codes.PermissionDenied, codes.Unauthenticated, codes.FailedPrecondition, codes.OutOfRange,
+		offsetOutOfRangeCode:
+		return slog.LevelInfo
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
+
+// offsetOutOfRangeCode is the code of log.ErrOffsetOutOfRange, which does not use a standard one
+var offsetOutOfRangeCode = status.Code(log.ErrOffsetOutOfRange{})
+
+// loggingUnary logs every unary call with its method, peer, request and outcome
+func loggingUnary(logger *slog.Logger, level func(codes.Code) slog.Level) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (
+		interface{}, error) {
+		start := time.Now()
+		res, err := handler(ctx, req)
+		attrs := append(messageAttrs("request", req), messageAttrs("response", res)...)
+		logRequest(ctx, logger, level, info.FullMethod, start, err, attrs)
+		return res, err
+	}
+}
+
+// loggingStream logs every stream when it ends, with the last request received on it
+func loggingStream(logger *slog.Logger, level func(codes.Code) slog.Level) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		logged := &loggedStream{ServerStream: stream}
+		err := handler(srv, logged)
+		logRequest(stream.Context(), logger, level, info.FullMethod, start, err, logged.attrs())
+		return err
+	}
+}
+
+type loggedStream struct {
+	grpc.ServerStream
+	mu   sync.Mutex
+	last interface{}
+}
+
+func (s *loggedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.last = m
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *loggedStream) attrs() []any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return messageAttrs("request", s.last)
+}
+
+func logRequest(
+	ctx context.Context,
+	logger *slog.Logger,
+	level func(codes.Code) slog.Level,
+	method string,
+	start time.Time,
+	err error,
+	attrs []any,
+) {
+	code := status.Code(err)
+	lvl := level(code)
+	if !logger.Enabled(ctx, lvl) {
+		return
+	}
+	attrs = append([]any{
+		"method", method,
+		"code", code.String(),
+		"duration", time.Since(start),
+	}, attrs...)
+	if p, ok := peer.FromContext(ctx); ok {
+		attrs = append(attrs, "peer", p.Addr.String())
+	}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	logger.Log(ctx, lvl, "rpc handled", attrs...)
+}
+
+// messageAttrs groups the topic, partition and offset of a request or response
+func messageAttrs(key string, m interface{}) []any {
+	var attrs []any
+	if m, ok := m.(topicRequest); ok && m.GetTopic() != "" {
+		attrs = append(attrs, slog.String("topic", m.GetTopic()))
+	}
+	if m, ok := m.(partitionMessage); ok {
+		attrs = append(attrs, slog.Uint64("partition", uint64(m.GetPartition())))
+	}
+	if m, ok := m.(offsetMessage); ok {
+		attrs = append(attrs, slog.Uint64("offset", m.GetOffset()))
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+	return []any{slog.Group(key, attrs...)}
+}
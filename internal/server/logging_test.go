@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/topic"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer lets the server log while the test reads what it logged
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+type LoggingTestSuite struct {
+	suite.Suite
+	topics *topic.Manager
+	logs   *syncBuffer
+	server *grpc.Server
+	ccon   *grpc.ClientConn
+	client api.LogClient
+}
+
+func TestLoggingTestSuite(t *testing.T) {
+	suite.Run(t, &LoggingTestSuite{})
+}
+
+func (s *LoggingTestSuite) SetupTest() {
+	var err error
+	s.topics, err = topic.NewManager(filepath.Join(s.T().TempDir(), "topics"))
+	s.Require().NoError(err)
+	s.logs = &syncBuffer{}
+	// successful calls are logged at debug level, which this handler leaves out
+	logger := slog.New(slog.NewTextHandler(s.logs, nil))
+	s.server, err = NewGrpcServer(&Config{Topics: s.topics, Logger: logger})
+	s.Require().NoError(err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	go func() {
+		_ = s.server.Serve(listener)
+	}()
+	s.ccon, err = grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	s.Require().NoError(err)
+	s.client = api.NewLogClient(s.ccon)
+}
+
+func (s *LoggingTestSuite) TearDownTest() {
+	s.Require().NoError(s.ccon.Close())
+	s.server.Stop()
+	s.Require().NoError(s.topics.Close())
+}
+
+func (s *LoggingTestSuite) TestRequestLogging() {
+	ctx := context.Background()
+	_, err := s.client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("test input")}})
+	s.Require().NoError(err)
+	s.Require().Empty(s.logs.String())
+
+	_, err = s.client.Consume(ctx, &api.ConsumeRequest{Topic: "orders", Offset: 5})
+	s.Require().Error(err)
+	_, err = s.client.Consume(ctx, &api.ConsumeRequest{Offset: 5})
+	s.Require().Error(err)
+
+	lines := strings.Split(strings.TrimSpace(s.logs.String()), "\n")
+	s.Require().Len(lines, 2)
+	for _, line := range lines {
+		s.Require().Contains(line, "level=INFO")
+		s.Require().Contains(line, `msg="rpc handled" method=`+api.Log_Consume_FullMethodName)
+		s.Require().Contains(line, "request.offset=5")
+		s.Require().Contains(line, "peer=127.0.0.1:")
+	}
+	s.Require().Contains(lines[0], "code=NotFound")
+	s.Require().Contains(lines[0], "request.topic=orders")
+}
+
+func (s *LoggingTestSuite) TestStreamErrorsAreLogged() {
+	stream, err := s.client.ProduceStream(context.Background())
+	s.Require().NoError(err)
+	err = stream.Send(&api.ProduceRequest{Topic: "orders", Record: &api.Record{Value: []byte("test input")}})
+	s.Require().NoError(err)
+	_, err = stream.Recv()
+	s.Require().Error(err)
+
+	s.Require().Eventually(func() bool {
+		return strings.Contains(s.logs.String(), "method="+api.Log_ProduceStream_FullMethodName)
+	}, time.Second, 10*time.Millisecond)
+	s.Require().Contains(s.logs.String(), "request.topic=orders")
+}
+
+func (s *LoggingTestSuite) TestRequestLogLevel() {
+	logs := &syncBuffer{}
+	server, err := NewGrpcServer(&Config{
+		Topics: s.topics,
+		Logger: slog.New(slog.NewTextHandler(logs, nil)),
+		RequestLogLevel: func(code codes.Code) slog.Level {
+			return slog.LevelWarn
+		},
+	})
+	s.Require().NoError(err)
+	defer server.Stop()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	ccon, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	s.Require().NoError(err)
+	defer ccon.Close()
+
+	_, err = api.NewLogClient(ccon).Produce(context.Background(),
+		&api.ProduceRequest{Record: &api.Record{Value: []byte("test input")}})
+	s.Require().NoError(err)
+	s.Require().Contains(logs.String(), "level=WARN")
+	s.Require().Contains(logs.String(), "code=OK")
+	s.Require().Contains(logs.String(), "response.partition=0 response.offset=0")
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/a-shakra/commit-log/internal/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"time"
+)
+
+var (
+	rpcRequests = metrics.Default.NewCounter(
+		"commitlog_grpc_requests_total", "RPCs handled by the server by their status code.", "method", "code")
+	rpcSeconds = metrics.Default.NewHistogram(
+		"commitlog_grpc_request_seconds", "Latency of unary RPCs and duration of streams.", metrics.DefaultBuckets, "method")
+	rpcActiveStreams = metrics.Default.NewGauge(
+		"commitlog_grpc_active_streams", "Streams that are open on the server.", "method")
+)
+
+// NewMetricsServer returns an HTTP server for the MetricsAddr of the config that
+// serves the metrics of the server in the Prometheus text format at /metrics.
+// It runs apart from the HTTP/JSON gateway, so metrics are scraped without
+// the gateway being enabled or scrapers needing a client certificate
+func NewMetricsServer(config *Config) (*http.Server, error) {
+	if config.MetricsAddr == "" {
+		return nil, errors.New("metrics listener is not enabled, set MetricsAddr in the server config")
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default)
+	return &http.Server{Addr: config.MetricsAddr, Handler: mux}, nil
+}
+
+// metricsUnary records the latency and status code of every unary call
+func metricsUnary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (
+		interface{}, error) {
+		start := time.Now()
+		res, err := handler(ctx, req)
+		observeRPC(info.FullMethod, start, err)
+		return res, err
+	}
+}
+
+// metricsStream records the duration and status code of every stream and how many are open
+func metricsStream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		rpcActiveStreams.Inc(info.FullMethod)
+		defer rpcActiveStreams.Dec(info.FullMethod)
+		err := handler(srv, stream)
+		observeRPC(info.FullMethod, start, err)
+		return err
+	}
+}
+
+func observeRPC(method string, start time.Time, err error) {
+	rpcSeconds.Observe(time.Since(start).Seconds(), method)
+	rpcRequests.Inc(method, status.Code(err).String())
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/replication"
+	"github.com/a-shakra/commit-log/internal/topic"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+type replicaNode struct {
+	dir      string
+	topics   *topic.Manager
+	leader   *replication.Leader
+	follower *replication.Follower
+	server   *grpc.Server
+	listener net.Listener
+	ccon     *grpc.ClientConn
+	client   api.LogClient
+}
+
+type ReplicationTestSuite struct {
+	suite.Suite
+	nodes []*replicaNode
+}
+
+func TestReplicationTestSuite(t *testing.T) {
+	suite.Run(t, &ReplicationTestSuite{})
+}
+
+func (s *ReplicationTestSuite) SetupTest() {
+	s.nodes = nil
+	leader := s.startNode("")
+	s.startNode(leader.listener.Addr().String())
+	s.startNode(leader.listener.Addr().String())
+}
+
+func (s *ReplicationTestSuite) TearDownTest() {
+	for i := len(s.nodes) - 1; i >= 0; i-- {
+		s.stopNode(s.nodes[i])
+	}
+}
+
+func (s *ReplicationTestSuite) TestFollowersReplicateWithIdenticalOffsets() {
+	ctx := context.Background()
+	leader := s.nodes[0]
+	_, err := leader.client.CreateTopic(ctx, &api.CreateTopicRequest{Name: "orders", Partitions: 2})
+	s.Require().NoError(err)
+	s.waitForReplicas("orders", 0, 2)
+	s.waitForReplicas("orders", 1, 2)
+
+	partition := uint32(1)
+	for i := 0; i < 3; i++ {
+		produce, err := leader.client.Produce(ctx, &api.ProduceRequest{
+			Topic:     "orders",
+			Partition: &partition,
+			Acks:      api.Acks_ACKS_ALL,
+			Record:    &api.Record{Value: []byte(fmt.Sprintf("record %d", i))},
+		})
+		s.Require().NoError(err)
+		s.Require().Equal(uint64(i), produce.Offset)
+	}
+
+	for _, node := range s.nodes {
+		t, err := node.topics.Get("orders")
+		s.Require().NoError(err)
+		l, err := t.Partition(1)
+		s.Require().NoError(err)
+		// acks=all returned, so every in-sync replica already stored the records
+		s.Require().Equal(uint64(3), l.NextOffset())
+		for i := uint64(0); i < 3; i++ {
+			rec, err := l.Read(i)
+			s.Require().NoError(err)
+			s.Require().Equal(i, rec.Offset)
+			s.Require().Equal([]byte(fmt.Sprintf("record %d", i)), rec.Value)
+		}
+	}
+
+	// followers serve reads once the leader reported the high watermark
+	follower := s.nodes[1]
+	s.Require().Eventually(func() bool {
+		res, err := follower.client.Consume(ctx, &api.ConsumeRequest{Topic: "orders", Partition: 1, Offset: 2})
+		return err == nil && res.HighWatermark == 3
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func (s *ReplicationTestSuite) TestHighWatermarkWaitsForInSyncReplicas() {
+	ctx := context.Background()
+	leader := s.nodes[0]
+	s.waitForReplicas(topic.DefaultTopic, 0, 2)
+
+	produce, err := leader.client.Produce(ctx, &api.ProduceRequest{
+		Acks:   api.Acks_ACKS_ALL,
+		Record: &api.Record{Value: []byte("test api record")},
+	})
+	s.Require().NoError(err)
+	consume, err := leader.client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(1), consume.HighWatermark)
+
+	// once a follower stops, it leaves the in-sync replicas and no longer blocks producers
+	s.stopNode(s.nodes[2])
+	s.nodes = s.nodes[:2]
+	s.waitForReplicas(topic.DefaultTopic, 0, 1)
+	produce, err = leader.client.Produce(ctx, &api.ProduceRequest{
+		Acks:   api.Acks_ACKS_ALL,
+		Record: &api.Record{Value: []byte("test api record")},
+	})
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(1), produce.Offset)
+}
+
+func (s *ReplicationTestSuite) TestAcksNone() {
+	ctx := context.Background()
+	leader := s.nodes[0]
+	_, err := leader.client.Produce(ctx, &api.ProduceRequest{
+		Acks:   api.Acks_ACKS_NONE,
+		Record: &api.Record{Value: []byte("test api record")},
+	})
+	s.Require().NoError(err)
+	s.Require().Eventually(func() bool {
+		t, err := leader.topics.Get(topic.DefaultTopic)
+		s.Require().NoError(err)
+		l, err := t.Partition(0)
+		s.Require().NoError(err)
+		return l.NextOffset() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func (s *ReplicationTestSuite) TestFollowerRejectsWrites() {
+	ctx := context.Background()
+	follower := s.nodes[1]
+	_, err := follower.client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("test api record")},
+	})
+	s.Require().Equal(codes.FailedPrecondition, status.Code(err))
+	_, err = follower.client.CreateTopic(ctx, &api.CreateTopicRequest{Name: "orders"})
+	s.Require().Equal(codes.FailedPrecondition, status.Code(err))
+}
+
+func (s *ReplicationTestSuite) TestFollowersMirrorTopicDeletion() {
+	ctx := context.Background()
+	leader := s.nodes[0]
+	_, err := leader.client.CreateTopic(ctx, &api.CreateTopicRequest{Name: "orders"})
+	s.Require().NoError(err)
+	s.waitForReplicas("orders", 0, 2)
+
+	_, err = leader.client.DeleteTopic(ctx, &api.DeleteTopicRequest{Name: "orders"})
+	s.Require().NoError(err)
+	for _, node := range s.nodes[1:] {
+		s.Require().Eventually(func() bool {
+			_, err := node.topics.Get("orders")
+			return err != nil
+		}, 5*time.Second, 20*time.Millisecond)
+	}
+}
+
+// waitForReplicas waits until n followers replicate the partition
+func (s *ReplicationTestSuite) waitForReplicas(name string, partition uint32, n int) {
+	p := replication.Partition{Topic: name, Partition: partition}
+	s.Require().Eventually(func() bool {
+		return len(s.nodes[0].leader.Replicas(p)) == n
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// startNode starts a leader when leaderAddr is empty and a follower of leaderAddr otherwise
+func (s *ReplicationTestSuite) startNode(leaderAddr string) *replicaNode {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	dir, err := os.MkdirTemp("", "replication-test")
+	s.Require().NoError(err)
+	topics, err := topic.NewManager(dir)
+	s.Require().NoError(err)
+
+	node := &replicaNode{dir: dir, topics: topics, listener: listener}
+	config := &Config{Topics: topics}
+	if leaderAddr == "" {
+		node.leader, err = replication.NewLeader(replication.WithMaxLag(time.Second))
+		s.Require().NoError(err)
+		config.Replication = node.leader
+	} else {
+		node.follower, err = replication.NewFollower(
+			fmt.Sprintf("follower-%d", len(s.nodes)),
+			leaderAddr,
+			topics,
+			replication.WithSyncInterval(20*time.Millisecond),
+			replication.WithDialOptions(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		)
+		s.Require().NoError(err)
+		config.Follower = node.follower
+	}
+	node.server, err = NewGrpcServer(config)
+	s.Require().NoError(err)
+	go func() {
+		node.server.Serve(listener)
+	}()
+
+	node.ccon, err = grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	s.Require().NoError(err)
+	node.client = api.NewLogClient(node.ccon)
+	s.nodes = append(s.nodes, node)
+	return node
+}
+
+func (s *ReplicationTestSuite) stopNode(node *replicaNode) {
+	if node.follower != nil {
+		err := node.follower.Close()
+		s.Require().NoError(err)
+	}
+	if node.leader != nil {
+		err := node.leader.Close()
+		s.Require().NoError(err)
+	}
+	err := node.ccon.Close()
+	s.Require().NoError(err)
+	node.server.Stop()
+	err = node.topics.Remove()
+	s.Require().NoError(err)
+}
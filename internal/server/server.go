@@ -3,63 +3,375 @@ package server
 import (
 	"context"
 	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/auth"
+	"github.com/a-shakra/commit-log/internal/group"
 	"github.com/a-shakra/commit-log/internal/log"
+	"github.com/a-shakra/commit-log/internal/membership"
+	"github.com/a-shakra/commit-log/internal/raft"
+	"github.com/a-shakra/commit-log/internal/replication"
+	"github.com/a-shakra/commit-log/internal/topic"
+	"github.com/a-shakra/commit-log/internal/txn"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"io"
+	"log/slog"
+	"time"
 )
 
+// replicationHeartbeat is how often an idle Replicate stream sends the high watermark
+var replicationHeartbeat = time.Second
+
+// pollInterval is how often a consume stream that caught up with its partition
+// looks for new records, when its log does not tell it about appends
+var pollInterval = 10 * time.Millisecond
+
 type WriteAheadLog interface {
 	Append(record *api.Record) (uint64, error)
 	Read(offset uint64) (*api.Record, error)
+	LowestOffset() uint64
+	NextOffset() uint64
 	Remove() error
 }
 
+// Config holds the dependencies of the grpc server
+type Config struct {
+	// Topics owns the WriteAheadLog of every topic the server serves
+	Topics *topic.Manager
+	// Log is served as the single partition of the default topic instead of Topics when set,
+	// such as by servers embedding their own WriteAheadLog. Topics, Replication, Follower,
+	// Consensus and Transactions are not used with it
+	Log WriteAheadLog
+	// Offsets stores the committed offsets of consumer groups, groups are disabled when nil
+	Offsets *group.OffsetStore
+	// Transactions coordinates the transactions of producers, transactions are disabled when nil
+	Transactions *txn.Coordinator
+	// Replication tracks the followers of this server when it is a replication leader
+	Replication *replication.Leader
+	// Follower replicates the topics of a leader into Topics when this server is a follower.
+	// Followers only serve reads
+	Follower *replication.Follower
+	// Consensus replicates partition 0 of the default topic through Raft when set.
+	// Writes to it return once a quorum of the cluster committed them
+	Consensus *raft.Node
+	// Membership tracks the other servers of the cluster and serves their heartbeats when set
+	Membership *membership.Membership
+	// Authorizer checks every call against the identity of the client certificate when set
+	Authorizer *auth.Authorizer
+	// Tokens verifies the bearer tokens callers may authenticate with instead of a client certificate,
+	// calls outside the actions of a token are denied with or without an Authorizer
+	Tokens *auth.Tokens
+	// Health reports the serving status of the server through grpc.health.v1, see NewHealth.
+	// When nil the server reports SERVING right away, since Topics are open by then.
+	// Servers started before their Topics are opened set them with OpenTopics
+	Health *health.Server
+	// Logger receives the calls the server handled and their errors, slog.Default when nil
+	Logger *slog.Logger
+	// RequestLogLevel picks the level calls are logged at by their status code,
+	// DefaultRequestLogLevel when nil
+	RequestLogLevel func(code codes.Code) slog.Level
+	// HTTPAddr is the address the HTTP/JSON gateway built by NewHTTPServer listens on, the gateway is disabled when empty
+	HTTPAddr string
+	// MetricsAddr is the address the metrics listener built by NewMetricsServer listens on,
+	// the listener is disabled when empty
+	MetricsAddr string
+	// MaxEventStreams caps the Server-Sent Event streams the gateway serves at once, unlimited when 0
+	MaxEventStreams int
+	// MaxEventsPerStream ends a Server-Sent Event stream after that many records, unlimited when 0.
+	// Clients may ask for fewer with the limit query parameter
+	MaxEventsPerStream uint64
+}
+
+// transactionalLog is implemented by logs that track the transactions written to them
+type transactionalLog interface {
+	LastStableOffset() uint64
+	Aborted(transactionID string) bool
+}
+
+// appendNotifier is implemented by logs that wake up readers when records are appended to them
+type appendNotifier interface {
+	Appended() <-chan struct{}
+}
+
 // guarantee *grpc.server meets LogServer interface at compile time
 var _ api.LogServer = &grpcServer{}
 
 // grpcServer TODO improve error handling logic in the method functions
 type grpcServer struct {
 	api.UnimplementedLogServer
-	log WriteAheadLog
+	*Config
 }
 
-func NewGrpcServer(log WriteAheadLog, opts ...grpc.ServerOption) (*grpc.Server, error) {
+func NewGrpcServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, error) {
+	h := config.Health
+	if h == nil {
+		h = health.NewServer()
+		SetServing(h, true)
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	level := config.RequestLogLevel
+	if level == nil {
+		level = DefaultRequestLogLevel
+	}
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(metricsUnary(), loggingUnary(logger, level)),
+		grpc.ChainStreamInterceptor(metricsStream(), loggingStream(logger, level)),
+		grpc.ChainUnaryInterceptor(readyUnary(h)),
+		grpc.ChainStreamInterceptor(readyStream(h)),
+	)
+	if config.Tokens != nil {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(authenticateUnary(config.Tokens)),
+			grpc.ChainStreamInterceptor(authenticateStream(config.Tokens)),
+		)
+	}
+	if config.Authorizer != nil {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(authorizeUnary(config.Authorizer)),
+			grpc.ChainStreamInterceptor(authorizeStream(config.Authorizer)),
+		)
+	}
 	gServer := grpc.NewServer(opts...)
-	server, err := newGrpcServer(log)
+	server, err := newGrpcServer(config)
 	if err != nil {
 		return nil, err
 	}
 	api.RegisterLogServer(gServer, server)
+	if config.Consensus != nil {
+		api.RegisterRaftServer(gServer, config.Consensus)
+	}
+	if config.Membership != nil {
+		api.RegisterMembershipServer(gServer, config.Membership)
+	}
+	healthpb.RegisterHealthServer(gServer, h)
+	reflection.Register(gServer)
 	return gServer, nil
 }
 
-func newGrpcServer(log WriteAheadLog) (s *grpcServer, err error) {
+func newGrpcServer(config *Config) (s *grpcServer, err error) {
 	s = &grpcServer{
-		log: log,
+		Config: config,
 	}
 	return s, nil
 }
 
+// topic resolves the topic with the given name. Requests that do not
+// name a topic are served from the default topic
+func (s *grpcServer) topic(name string) (*topic.Topic, error) {
+	return s.Topics.Get(topicName(name))
+}
+
+// log resolves the WriteAheadLog of a partition of the given topic
+func (s *grpcServer) log(name string, partition uint32) (WriteAheadLog, error) {
+	if s.Log != nil {
+		return s.singleLog(name, partition)
+	}
+	if s.isConsensusLog(name, partition) {
+		return s.Consensus, nil
+	}
+	t, err := s.topic(name)
+	if err != nil {
+		return nil, err
+	}
+	l, err := t.Partition(partition)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
 // Produce sends a *api.ProduceRequest object to the Log object with a record that is to be stored.
-// the offset at which this record has been stored is returned.
-// Produce TODO implement retry logic if the err returned is because the active segment was full
+// The record goes to the requested partition, or to the one chosen by the topic's partitioner
+// if none is requested. The partition and offset at which this record has been stored are returned.
 func (s *grpcServer) Produce(ctx context.Context, req *api.ProduceRequest) (
 	*api.ProduceResponse, error) {
-	offset, err := s.log.Append(req.Record)
+	if err := s.requireLeader(); err != nil {
+		return nil, err
+	}
+	if req.Record == nil {
+		return nil, status.Error(codes.InvalidArgument, "produce request should hold a record")
+	}
+	if s.Log != nil {
+		return s.produceSingle(req)
+	}
+	t, err := s.topic(req.Topic)
+	if err != nil {
+		return nil, err
+	}
+	// the producer id and sequence are stored with the record, so the dedup
+	// window of the log can be rebuilt after a restart
+	req.Record.ProducerId = req.ProducerId
+	req.Record.Sequence = req.Sequence
+	// clients only write data records, and only to the transaction the request names
+	req.Record.TransactionId = req.TransactionId
+	req.Record.Type = api.RecordType_RECORD_TYPE_DATA
+	var partition uint32
+	switch {
+	case req.Partition != nil:
+		partition = *req.Partition
+	case s.Consensus != nil && t.Name == topic.DefaultTopic:
+		// without an explicit partition the default topic is written through the consensus log
+	default:
+		if partition, err = t.Route(req.Record); err != nil {
+			return nil, err
+		}
+	}
+	if req.TransactionId != "" {
+		return s.produceTxn(ctx, t, partition, req)
+	}
+	var offset uint64
+	if s.isConsensusLog(t.Name, partition) {
+		if offset, err = s.Consensus.Propose(ctx, req.Record); err != nil {
+			return nil, err
+		}
+		return &api.ProduceResponse{Offset: offset, Partition: partition}, nil
+	}
+	offset, err = s.append(ctx, t, partition, req)
 	if err != nil {
 		return nil, err
 	}
 
+	return &api.ProduceResponse{Offset: offset, Partition: partition}, nil
+}
+
+// produceSingle appends the record to the Log of the config
+func (s *grpcServer) produceSingle(req *api.ProduceRequest) (*api.ProduceResponse, error) {
+	if req.TransactionId != "" {
+		return nil, status.Error(codes.Unimplemented, "transactions are not enabled on this server")
+	}
+	var partition uint32
+	if req.Partition != nil {
+		partition = *req.Partition
+	}
+	wal, err := s.singleLog(req.Topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	req.Record.ProducerId = req.ProducerId
+	req.Record.Sequence = req.Sequence
+	req.Record.TransactionId = ""
+	req.Record.Type = api.RecordType_RECORD_TYPE_DATA
+	offset, err := wal.Append(req.Record)
+	if err != nil {
+		return nil, err
+	}
 	return &api.ProduceResponse{Offset: offset}, nil
 }
 
+// singleLog resolves the Log of the config, which is partition 0 of the default topic
+func (s *grpcServer) singleLog(name string, partition uint32) (WriteAheadLog, error) {
+	if topicName(name) != topic.DefaultTopic {
+		return nil, topic.ErrTopicNotFound{Topic: name}
+	}
+	if partition != 0 {
+		return nil, topic.ErrPartitionNotFound{Topic: topic.DefaultTopic, Partition: partition}
+	}
+	return s.Log, nil
+}
+
+// produceTxn appends the record of a transaction, which cannot end meanwhile
+func (s *grpcServer) produceTxn(ctx context.Context, t *topic.Topic, partition uint32, req *api.ProduceRequest) (
+	*api.ProduceResponse, error) {
+	if s.Transactions == nil {
+		return nil, status.Error(codes.Unimplemented, "transactions are not enabled on this server")
+	}
+	if s.isConsensusLog(t.Name, partition) {
+		return nil, status.Error(codes.FailedPrecondition, "transactions cannot write to the consensus log")
+	}
+	if req.Acks == api.Acks_ACKS_NONE {
+		// the record could be appended after the transaction ended
+		return nil, status.Error(codes.InvalidArgument, "transactional records should be acknowledged")
+	}
+	if _, err := t.Partition(partition); err != nil {
+		return nil, err
+	}
+	var offset uint64
+	err := s.Transactions.Write(req.TransactionId, txn.Partition{Topic: t.Name, Partition: partition}, func() error {
+		var err error
+		offset, err = s.append(ctx, t, partition, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.ProduceResponse{Offset: offset, Partition: partition}, nil
+}
+
+// append stores the record of the request in a partition that is not replicated through Raft
+func (s *grpcServer) append(ctx context.Context, t *topic.Topic, partition uint32, req *api.ProduceRequest) (
+	uint64, error) {
+	wal, err := t.Partition(partition)
+	if err != nil {
+		return 0, err
+	}
+	if s.Replication != nil {
+		p := replication.Partition{Topic: t.Name, Partition: partition}
+		return s.Replication.Append(ctx, p, wal, req.Record, req.Acks)
+	}
+	return wal.Append(req.Record)
+}
+
+// BeginTxn starts a transaction that records are added to by naming it in produce requests
+func (s *grpcServer) BeginTxn(ctx context.Context, req *api.BeginTxnRequest) (*api.BeginTxnResponse, error) {
+	if err := s.requireTransactions(); err != nil {
+		return nil, err
+	}
+	id, err := s.Transactions.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &api.BeginTxnResponse{TransactionId: id}, nil
+}
+
+// CommitTxn makes every record of the transaction visible to consumers reading committed
+func (s *grpcServer) CommitTxn(ctx context.Context, req *api.CommitTxnRequest) (*api.CommitTxnResponse, error) {
+	if err := s.requireTransactions(); err != nil {
+		return nil, err
+	}
+	if err := s.Transactions.Commit(req.TransactionId); err != nil {
+		return nil, err
+	}
+	return &api.CommitTxnResponse{}, nil
+}
+
+// AbortTxn hides every record of the transaction from consumers reading committed
+func (s *grpcServer) AbortTxn(ctx context.Context, req *api.AbortTxnRequest) (*api.AbortTxnResponse, error) {
+	if err := s.requireTransactions(); err != nil {
+		return nil, err
+	}
+	if err := s.Transactions.Abort(req.TransactionId); err != nil {
+		return nil, err
+	}
+	return &api.AbortTxnResponse{}, nil
+}
+
 // Consume will return the record that is stored at the indicated offset by the *api.ConsumeRequest req object.
+// Records hidden from the request, such as transaction markers, are skipped for the next visible one
 func (s *grpcServer) Consume(ctx context.Context, req *api.ConsumeRequest) (
 	*api.ConsumeResponse, error) {
-	rec, err := s.log.Read(req.Offset)
+	wal, err := s.log(req.Topic, req.Partition)
 	if err != nil {
 		return nil, err
 	}
-	return &api.ConsumeResponse{Record: rec}, nil
+	hwm, limit := s.readLimit(req, wal)
+	// records that are hidden are skipped, up to the last stable offset when reading committed
+	for off := req.Offset; off < limit; off++ {
+		rec, err := wal.Read(off)
+		if err != nil {
+			return nil, err
+		}
+		if visible(req, wal, rec) {
+			return &api.ConsumeResponse{Record: rec, HighWatermark: hwm}, nil
+		}
+	}
+	return nil, log.ErrOffsetOutOfRange{Offset: req.Offset}
 }
 
 // ProduceStream implements a bidirectional streaming RPC.
@@ -77,7 +389,7 @@ func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
 			return err
 		}
 		if err = stream.Send(res); err != nil {
-			return nil
+			return err
 		}
 	}
 }
@@ -85,27 +397,353 @@ func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
 // ConsumeStream implements a server-side streaming RPC.
 // Client sends a starting offset to begin reading from log
 // and the ConsumeStream will return all records in Log starting
-// at that offset. Connection remains open until the ctx is canceled
+// at that offset. When the request names a consumer group the
+// stream starts at the group's committed offset instead, or at
+// the position picked by the reset policy if nothing was committed.
+// Headers are sent as soon as the starting offset is resolved.
+// Connection remains open until the ctx is canceled
 func (s *grpcServer) ConsumeStream(
 	req *api.ConsumeRequest,
 	stream api.Log_ConsumeStreamServer) error {
+	wal, err := s.log(req.Topic, req.Partition)
+	if err != nil {
+		return err
+	}
+	if req.Group != "" {
+		if req.Offset, err = s.groupOffset(req, wal); err != nil {
+			return err
+		}
+	}
+	if err = stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+	p := replication.Partition{Topic: topicName(req.Topic), Partition: req.Partition}
+	notifier, notifies := wal.(appendNotifier)
 	for {
-		select {
-		case <-stream.Context().Done():
-			return nil
-		default:
-			res, err := s.Consume(stream.Context(), req)
+		var changed, appended <-chan struct{}
+		if s.Replication != nil {
+			changed = s.Replication.Changed(p)
+		}
+		if notifies {
+			appended = notifier.Appended()
+		}
+		hwm, limit := s.readLimit(req, wal)
+		if req.Offset < limit {
+			rec, err := wal.Read(req.Offset)
 			switch err.(type) {
 			case nil:
-			case log.ErrOffsetOutOfRange:
+				req.Offset++
+				if !visible(req, wal, rec) {
+					continue
+				}
+				if err = stream.Send(&api.ConsumeResponse{Record: rec, HighWatermark: hwm}); err != nil {
+					return err
+				}
 				continue
+			case log.ErrOffsetOutOfRange:
+				// records below the lowest offset are gone and never show up
+				if req.Offset < wal.LowestOffset() {
+					return err
+				}
 			default:
 				return err
 			}
-			if err = stream.Send(res); err != nil {
+		}
+
+		// caught up, wait for an append. Logs that do not notify, such as the
+		// one of a Raft node whose commits advance without appends, are polled
+		var timer *time.Timer
+		var poll <-chan time.Time
+		if !notifies {
+			timer = time.NewTimer(pollInterval)
+			poll = timer.C
+		}
+		var done bool
+		select {
+		case <-changed:
+		case <-appended:
+		case <-poll:
+		case <-stream.Context().Done():
+			done = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// CreateTopic registers a new topic on the server
+func (s *grpcServer) CreateTopic(ctx context.Context, req *api.CreateTopicRequest) (
+	*api.CreateTopicResponse, error) {
+	if err := s.requireTopics(); err != nil {
+		return nil, err
+	}
+	meta, err := s.Topics.Create(req.Name, req.Partitions, req.Partitioner)
+	if err != nil {
+		return nil, err
+	}
+	return &api.CreateTopicResponse{Topic: topicMessage(meta)}, nil
+}
+
+// DeleteTopic removes a topic and all of its records from the server
+func (s *grpcServer) DeleteTopic(ctx context.Context, req *api.DeleteTopicRequest) (
+	*api.DeleteTopicResponse, error) {
+	if err := s.requireTopics(); err != nil {
+		return nil, err
+	}
+	if err := s.Topics.Delete(req.Name); err != nil {
+		return nil, err
+	}
+	if s.Replication != nil {
+		s.Replication.Remove(req.Name)
+	}
+	if s.Offsets != nil {
+		if err := s.Offsets.RemoveTopic(req.Name); err != nil {
+			return nil, err
+		}
+	}
+	return &api.DeleteTopicResponse{}, nil
+}
+
+// ListTopics returns every topic that is served by the server
+func (s *grpcServer) ListTopics(ctx context.Context, req *api.ListTopicsRequest) (
+	*api.ListTopicsResponse, error) {
+	if s.Topics == nil {
+		topics := []*api.Topic{{Name: topic.DefaultTopic, Partitions: 1, Partitioner: topic.DefaultPartitioner}}
+		return &api.ListTopicsResponse{Topics: topics}, nil
+	}
+	var topics []*api.Topic
+	for _, meta := range s.Topics.List() {
+		topics = append(topics, topicMessage(meta))
+	}
+	return &api.ListTopicsResponse{Topics: topics}, nil
+}
+
+// CommitOffset durably records the next offset a consumer group reads from a partition
+func (s *grpcServer) CommitOffset(ctx context.Context, req *api.CommitOffsetRequest) (
+	*api.CommitOffsetResponse, error) {
+	if err := s.validateGroupRequest(req.Group, req.Topic, req.Partition); err != nil {
+		return nil, err
+	}
+	if err := s.Offsets.Commit(req.Group, topicName(req.Topic), req.Partition, req.Offset); err != nil {
+		return nil, err
+	}
+	return &api.CommitOffsetResponse{}, nil
+}
+
+// FetchOffset returns the offset a consumer group committed for a partition
+func (s *grpcServer) FetchOffset(ctx context.Context, req *api.FetchOffsetRequest) (
+	*api.FetchOffsetResponse, error) {
+	if err := s.validateGroupRequest(req.Group, req.Topic, req.Partition); err != nil {
+		return nil, err
+	}
+	offset, ok := s.Offsets.Fetch(req.Group, topicName(req.Topic), req.Partition)
+	return &api.FetchOffsetResponse{Offset: offset, Committed: ok}, nil
+}
+
+func (s *grpcServer) validateGroupRequest(group string, name string, partition uint32) error {
+	if s.Offsets == nil {
+		return status.Error(codes.Unimplemented, "consumer groups are not enabled on this server")
+	}
+	if group == "" {
+		return status.Error(codes.InvalidArgument, "group id should not be empty")
+	}
+	_, err := s.log(name, partition)
+	return err
+}
+
+// groupOffset resolves the offset a consumer group resumes consuming a partition from
+func (s *grpcServer) groupOffset(req *api.ConsumeRequest, wal WriteAheadLog) (uint64, error) {
+	if err := s.validateGroupRequest(req.Group, req.Topic, req.Partition); err != nil {
+		return 0, err
+	}
+	if offset, ok := s.Offsets.Fetch(req.Group, topicName(req.Topic), req.Partition); ok {
+		return offset, nil
+	}
+	switch req.OffsetReset {
+	case api.OffsetReset_OFFSET_RESET_LATEST:
+		return wal.NextOffset(), nil
+	default:
+		return wal.LowestOffset(), nil
+	}
+}
+
+// Replicate streams the records of a partition to a follower. It is built on
+// the same read path as ConsumeStream, but instead of polling it wakes up when
+// records are appended, and it feeds the offsets the follower acknowledges back
+// into the high watermark of the partition
+func (s *grpcServer) Replicate(stream api.Log_ReplicateServer) error {
+	if s.Replication == nil {
+		return status.Error(codes.FailedPrecondition, "server is not a replication leader")
+	}
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if req.ReplicaId == "" {
+		return status.Error(codes.InvalidArgument, "replica id should not be empty")
+	}
+	wal, err := s.log(req.Topic, req.Partition)
+	if err != nil {
+		return err
+	}
+	p := replication.Partition{Topic: topicName(req.Topic), Partition: req.Partition}
+	s.Replication.Report(p, req.ReplicaId, req.Offset, wal)
+	defer s.Replication.Unregister(p, req.ReplicaId)
+
+	acks := make(chan error, 1)
+	go func() {
+		for {
+			ack, err := stream.Recv()
+			if err != nil {
+				acks <- err
+				return
+			}
+			s.Replication.Report(p, req.ReplicaId, ack.Offset, wal)
+		}
+	}()
+
+	offset := req.Offset
+	var sentHwm uint64
+	for {
+		changed := s.Replication.Changed(p)
+		hwm := s.Replication.HighWatermark(p, wal)
+		rec, err := wal.Read(offset)
+		switch err.(type) {
+		case nil:
+			if err = stream.Send(&api.ReplicateResponse{Record: rec, HighWatermark: hwm}); err != nil {
+				return err
+			}
+			sentHwm = hwm
+			offset++
+			continue
+		case log.ErrOffsetOutOfRange:
+		default:
+			return err
+		}
+		if hwm != sentHwm {
+			if err = stream.Send(&api.ReplicateResponse{HighWatermark: hwm}); err != nil {
 				return err
 			}
-			req.Offset++
+			sentHwm = hwm
 		}
+
+		timer := time.NewTimer(replicationHeartbeat)
+		select {
+		case <-changed:
+		case <-timer.C:
+			sentHwm = 0
+		case err = <-acks:
+			timer.Stop()
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-stream.Context().Done():
+			timer.Stop()
+			return nil
+		}
+		timer.Stop()
+	}
+}
+
+// GetServers returns the servers of the cluster with their RPC address and role
+func (s *grpcServer) GetServers(ctx context.Context, req *api.GetServersRequest) (
+	*api.GetServersResponse, error) {
+	if s.Membership == nil {
+		return nil, status.Error(codes.FailedPrecondition, "server is not a member of a cluster")
+	}
+	return &api.GetServersResponse{Servers: s.Membership.GetServers()}, nil
+}
+
+// requireTopics rejects changes to topics on servers that do not manage them
+func (s *grpcServer) requireTopics() error {
+	if err := s.requireLeader(); err != nil {
+		return err
+	}
+	if s.Topics == nil {
+		return status.Error(codes.Unimplemented, "topics are not managed by this server")
+	}
+	return nil
+}
+
+func (s *grpcServer) requireTransactions() error {
+	if err := s.requireLeader(); err != nil {
+		return err
+	}
+	if s.Transactions == nil || s.Log != nil {
+		return status.Error(codes.Unimplemented, "transactions are not enabled on this server")
+	}
+	return nil
+}
+
+// requireLeader rejects writes on followers, which only mirror the topics of their leader
+func (s *grpcServer) requireLeader() error {
+	if s.Follower != nil {
+		return status.Errorf(codes.FailedPrecondition,
+			"server is a follower, send writes to the leader at %s", s.Follower.LeaderAddr)
+	}
+	return nil
+}
+
+// isConsensusLog reports whether the partition is replicated through Raft
+func (s *grpcServer) isConsensusLog(name string, partition uint32) bool {
+	return s.Consensus != nil && topicName(name) == topic.DefaultTopic && partition == 0
+}
+
+// highWatermark returns the offset below which records of the partition may be served to consumers
+func (s *grpcServer) highWatermark(name string, partition uint32, wal WriteAheadLog) uint64 {
+	p := replication.Partition{Topic: topicName(name), Partition: partition}
+	switch {
+	case s.Replication != nil:
+		return s.Replication.HighWatermark(p, wal)
+	case s.Follower != nil:
+		return min(s.Follower.HighWatermark(p), wal.NextOffset())
+	default:
+		return wal.NextOffset()
+	}
+}
+
+// readLimit returns the high watermark of the partition and the offset below
+// which its records are served for the request, which stops at the last
+// stable offset when reading committed
+func (s *grpcServer) readLimit(req *api.ConsumeRequest, wal WriteAheadLog) (hwm uint64, limit uint64) {
+	hwm = s.highWatermark(req.Topic, req.Partition, wal)
+	limit = hwm
+	if tl, ok := wal.(transactionalLog); ok && req.IsolationLevel == api.IsolationLevel_ISOLATION_LEVEL_READ_COMMITTED {
+		limit = min(limit, tl.LastStableOffset())
+	}
+	return hwm, limit
+}
+
+// visible reports whether a record is streamed for the request: control
+// records never are, records of aborted transactions are not when reading committed
+func visible(req *api.ConsumeRequest, wal WriteAheadLog, rec *api.Record) bool {
+	if rec.Type != api.RecordType_RECORD_TYPE_DATA {
+		return false
+	}
+	if rec.TransactionId == "" || req.IsolationLevel != api.IsolationLevel_ISOLATION_LEVEL_READ_COMMITTED {
+		return true
+	}
+	tl, ok := wal.(transactionalLog)
+	return !ok || !tl.Aborted(rec.TransactionId)
+}
+
+// topicName maps requests that do not name a topic onto the default topic
+func topicName(name string) string {
+	if name == "" {
+		return topic.DefaultTopic
+	}
+	return name
+}
+
+func topicMessage(meta topic.Metadata) *api.Topic {
+	return &api.Topic{
+		Name:        meta.Name,
+		Partitions:  meta.Partitions,
+		Partitioner: meta.Partitioner,
 	}
 }
@@ -1,25 +1,52 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	api "github.com/a-shakra/commit-log/api/v1"
 	"github.com/a-shakra/commit-log/internal/config"
+	"github.com/a-shakra/commit-log/internal/group"
 	"github.com/a-shakra/commit-log/internal/log"
+	"github.com/a-shakra/commit-log/internal/metrics"
+	"github.com/a-shakra/commit-log/internal/topic"
+	"github.com/a-shakra/commit-log/internal/txn"
 	"github.com/stretchr/testify/suite"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
 	"net"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
+// TestMain issues a throwaway PKI, so the tests do not depend on certificates generated beforehand
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "server-test-certs")
+	if err != nil {
+		panic(err)
+	}
+	if err = config.GenerateCerts(dir); err != nil {
+		panic(err)
+	}
+	config.UseDir(dir)
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 type serverOpenResources struct {
-	wal      WriteAheadLog
-	client   api.LogClient
-	server   *grpc.Server
-	ccon     *grpc.ClientConn
-	listener net.Listener
+	topics       *topic.Manager
+	offsets      *group.OffsetStore
+	transactions *txn.Coordinator
+	client       api.LogClient
+	server       *grpc.Server
+	ccon         *grpc.ClientConn
+	listener     net.Listener
 }
 
 type ServerTestSuite struct {
@@ -39,7 +66,11 @@ func (s *ServerTestSuite) SetupTest() {
 	dir, err := os.MkdirTemp("", "server-test")
 	s.Require().NoError(err)
 
-	wal, err := log.NewLog(dir)
+	topics, err := topic.NewManager(dir)
+	s.Require().NoError(err)
+	offsets, err := group.NewOffsetStore(filepath.Join(dir, "__consumer_offsets"))
+	s.Require().NoError(err)
+	transactions, err := txn.NewCoordinator(filepath.Join(dir, "__transactions"), topics)
 	s.Require().NoError(err)
 
 	// setup server
@@ -52,7 +83,10 @@ func (s *ServerTestSuite) SetupTest() {
 		})
 	s.Require().NoError(err)
 	serverCreds := credentials.NewTLS(serverTLSConfig)
-	server, err := NewGrpcServer(wal, grpc.Creds(serverCreds))
+	server, err := NewGrpcServer(
+		&Config{Topics: topics, Offsets: offsets, Transactions: transactions},
+		grpc.Creds(serverCreds),
+	)
 	s.Require().NoError(err)
 
 	go func() {
@@ -68,17 +102,23 @@ func (s *ServerTestSuite) SetupTest() {
 	client := api.NewLogClient(cconn)
 
 	resources := serverOpenResources{
-		wal:      wal,
-		client:   client,
-		server:   server,
-		ccon:     cconn,
-		listener: listener,
+		topics:       topics,
+		offsets:      offsets,
+		transactions: transactions,
+		client:       client,
+		server:       server,
+		ccon:         cconn,
+		listener:     listener,
 	}
 	s.resources = resources
 }
 
 func (s *ServerTestSuite) TearDownTest() {
-	err := s.resources.wal.Remove()
+	err := s.resources.transactions.Close()
+	s.Require().NoError(err)
+	err = s.resources.offsets.Close()
+	s.Require().NoError(err)
+	err = s.resources.topics.Remove()
 	s.Require().NoError(err)
 	err = s.resources.ccon.Close()
 	s.Require().NoError(err)
@@ -102,6 +142,117 @@ func (s *ServerTestSuite) TestProduceConsume() {
 	s.Require().Equal(want.Offset, consume.Record.Offset)
 }
 
+func (s *ServerTestSuite) TestIdempotentProduce() {
+	ctx := context.Background()
+	req := &api.ProduceRequest{
+		Record:     &api.Record{Value: []byte("test api record")},
+		ProducerId: "producer",
+		Sequence:   1,
+	}
+	first, err := s.resources.client.Produce(ctx, req)
+	s.Require().NoError(err)
+	// a retry of a request that timed out is not stored again
+	retry, err := s.resources.client.Produce(ctx, req)
+	s.Require().NoError(err)
+	s.Require().Equal(first.Offset, retry.Offset)
+
+	req.Sequence = 2
+	next, err := s.resources.client.Produce(ctx, req)
+	s.Require().NoError(err)
+	s.Require().Equal(first.Offset+1, next.Offset)
+	consume, err := s.resources.client.Consume(ctx, &api.ConsumeRequest{Offset: next.Offset})
+	s.Require().NoError(err)
+	s.Require().Equal("producer", consume.Record.ProducerId)
+	s.Require().Equal(uint64(2), consume.Record.Sequence)
+
+	req.Sequence = 4
+	_, err = s.resources.client.Produce(ctx, req)
+	s.Require().Equal(codes.FailedPrecondition, status.Code(err))
+}
+
+func (s *ServerTestSuite) TestProduceStoresDataRecords() {
+	ctx := context.Background()
+	// a client cannot write control records or add records to a transaction it did not name
+	produce, err := s.resources.client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{
+		Value:         []byte("test input"),
+		Type:          api.RecordType_RECORD_TYPE_ABORT,
+		TransactionId: "forged",
+	}})
+	s.Require().NoError(err)
+	consume, err := s.resources.client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	s.Require().NoError(err)
+	s.Require().Equal(api.RecordType_RECORD_TYPE_DATA, consume.Record.Type)
+	s.Require().Empty(consume.Record.TransactionId)
+}
+
+func (s *ServerTestSuite) TestTransactions() {
+	ctx := context.Background()
+	produce := func(value string, transactionID string) uint64 {
+		res, err := s.resources.client.Produce(ctx, &api.ProduceRequest{
+			Record:        &api.Record{Value: []byte(value)},
+			TransactionId: transactionID,
+		})
+		s.Require().NoError(err)
+		return res.Offset
+	}
+	consumeCommitted := func(offset uint64) (*api.Record, error) {
+		res, err := s.resources.client.Consume(ctx, &api.ConsumeRequest{
+			Offset:         offset,
+			IsolationLevel: api.IsolationLevel_ISOLATION_LEVEL_READ_COMMITTED,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return res.Record, nil
+	}
+
+	aborted, err := s.resources.client.BeginTxn(ctx, &api.BeginTxnRequest{})
+	s.Require().NoError(err)
+	committed, err := s.resources.client.BeginTxn(ctx, &api.BeginTxnRequest{})
+	s.Require().NoError(err)
+	produce("aborted", aborted.TransactionId)
+	first := produce("committed 1", committed.TransactionId)
+	produce("committed 2", committed.TransactionId)
+	plain := produce("plain", "")
+
+	// nothing after the first record of an open transaction is served when reading committed
+	_, err = consumeCommitted(0)
+	s.Require().Error(err)
+	res, err := s.resources.client.Consume(ctx, &api.ConsumeRequest{Offset: plain})
+	s.Require().NoError(err)
+	s.Require().Equal([]byte("plain"), res.Record.Value)
+
+	_, err = s.resources.client.AbortTxn(ctx, &api.AbortTxnRequest{TransactionId: aborted.TransactionId})
+	s.Require().NoError(err)
+	_, err = consumeCommitted(0)
+	s.Require().Error(err)
+	_, err = s.resources.client.CommitTxn(ctx, &api.CommitTxnRequest{TransactionId: committed.TransactionId})
+	s.Require().NoError(err)
+
+	stream, err := s.resources.client.ConsumeStream(ctx, &api.ConsumeRequest{
+		IsolationLevel: api.IsolationLevel_ISOLATION_LEVEL_READ_COMMITTED,
+	})
+	s.Require().NoError(err)
+	for _, want := range []string{"committed 1", "committed 2", "plain"} {
+		res, err := stream.Recv()
+		s.Require().NoError(err)
+		s.Require().Equal([]byte(want), res.Record.Value)
+	}
+	// the aborted record is skipped
+	rec, err := consumeCommitted(0)
+	s.Require().NoError(err)
+	s.Require().Equal(first, rec.Offset)
+	// the markers that ended the transactions are hidden when reading uncommitted too
+	_, err = s.resources.client.Consume(ctx, &api.ConsumeRequest{Offset: plain + 1})
+	s.Require().Equal(status.Code(log.ErrOffsetOutOfRange{}.GRPCStatus().Err()), status.Code(err))
+
+	_, err = s.resources.client.Produce(ctx, &api.ProduceRequest{
+		Record:        &api.Record{Value: []byte("late")},
+		TransactionId: committed.TransactionId,
+	})
+	s.Require().Equal(codes.NotFound, status.Code(err))
+}
+
 func (s *ServerTestSuite) TestOffsetOutOfBounds() {
 	ctx := context.Background()
 	want := &api.Record{Value: []byte("test api record")}
@@ -162,3 +313,218 @@ func (s *ServerTestSuite) TestProduceConsumeStream() {
 	}
 
 }
+
+func (s *ServerTestSuite) TestConsumeStreamBelowLowestOffset() {
+	client := s.serveLogFrom(5)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 2})
+	s.Require().NoError(err)
+	_, err = stream.Recv()
+	s.Require().Equal(status.Code(log.ErrOffsetOutOfRange{}), status.Code(err))
+}
+
+// serveLogFrom serves a log whose lowest offset is lowest without TLS, until the test ends
+func (s *ServerTestSuite) serveLogFrom(lowest uint64) api.LogClient {
+	l, err := log.NewLog(s.T().TempDir(), log.WithSegmentParams(1024, 1024, lowest))
+	s.Require().NoError(err)
+	s.T().Cleanup(func() { _ = l.Close() })
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	gServer, err := NewGrpcServer(&Config{Log: l})
+	s.Require().NoError(err)
+	go func() {
+		_ = gServer.Serve(listener)
+	}()
+	s.T().Cleanup(gServer.Stop)
+	cc, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	s.Require().NoError(err)
+	s.T().Cleanup(func() { _ = cc.Close() })
+	return api.NewLogClient(cc)
+}
+
+func (s *ServerTestSuite) TestTopicLifecycle() {
+	ctx := context.Background()
+	created, err := s.resources.client.CreateTopic(ctx, &api.CreateTopicRequest{Name: "orders"})
+	s.Require().NoError(err)
+	s.Require().Equal("orders", created.Topic.Name)
+
+	_, err = s.resources.client.CreateTopic(ctx, &api.CreateTopicRequest{Name: "orders"})
+	s.Require().Equal(codes.AlreadyExists, status.Code(err))
+
+	list, err := s.resources.client.ListTopics(ctx, &api.ListTopicsRequest{})
+	s.Require().NoError(err)
+	s.Require().Equal(2, len(list.Topics))
+	s.Require().Equal(topic.DefaultTopic, list.Topics[0].Name)
+	s.Require().Equal("orders", list.Topics[1].Name)
+
+	_, err = s.resources.client.DeleteTopic(ctx, &api.DeleteTopicRequest{Name: "orders"})
+	s.Require().NoError(err)
+	_, err = s.resources.client.Produce(ctx, &api.ProduceRequest{
+		Topic:  "orders",
+		Record: &api.Record{Value: []byte("test api record")},
+	})
+	s.Require().Equal(codes.NotFound, status.Code(err))
+}
+
+func (s *ServerTestSuite) TestProduceConsumeTopicsAreIsolated() {
+	ctx := context.Background()
+	for _, name := range []string{"orders", "payments"} {
+		_, err := s.resources.client.CreateTopic(ctx, &api.CreateTopicRequest{Name: name})
+		s.Require().NoError(err)
+		produce, err := s.resources.client.Produce(ctx, &api.ProduceRequest{
+			Topic:  name,
+			Record: &api.Record{Value: []byte(name)},
+		})
+		s.Require().NoError(err)
+		// every topic has its own offset space
+		s.Require().Equal(uint64(0), produce.Offset)
+	}
+
+	consume, err := s.resources.client.Consume(ctx, &api.ConsumeRequest{Topic: "payments", Offset: 0})
+	s.Require().NoError(err)
+	s.Require().Equal([]byte("payments"), consume.Record.Value)
+}
+
+func (s *ServerTestSuite) TestProduceConsumePartitions() {
+	ctx := context.Background()
+	created, err := s.resources.client.CreateTopic(ctx, &api.CreateTopicRequest{
+		Name:        "orders",
+		Partitions:  3,
+		Partitioner: topic.HashPartitioner,
+	})
+	s.Require().NoError(err)
+	s.Require().Equal(uint32(3), created.Topic.Partitions)
+
+	// records with the same key always land on the same partition in order
+	var partition uint32
+	for i := 0; i < 3; i++ {
+		produce, err := s.resources.client.Produce(ctx, &api.ProduceRequest{
+			Topic:  "orders",
+			Record: &api.Record{Key: []byte("customer-42"), Value: []byte("test api record")},
+		})
+		s.Require().NoError(err)
+		if i > 0 {
+			s.Require().Equal(partition, produce.Partition)
+		}
+		partition = produce.Partition
+		s.Require().Equal(uint64(i), produce.Offset)
+	}
+
+	explicit := (partition + 1) % 3
+	produce, err := s.resources.client.Produce(ctx, &api.ProduceRequest{
+		Topic:     "orders",
+		Partition: &explicit,
+		Record:    &api.Record{Key: []byte("customer-42"), Value: []byte("explicit")},
+	})
+	s.Require().NoError(err)
+	s.Require().Equal(explicit, produce.Partition)
+	s.Require().Equal(uint64(0), produce.Offset)
+
+	consume, err := s.resources.client.Consume(ctx, &api.ConsumeRequest{
+		Topic:     "orders",
+		Partition: explicit,
+		Offset:    0,
+	})
+	s.Require().NoError(err)
+	s.Require().Equal([]byte("explicit"), consume.Record.Value)
+
+	_, err = s.resources.client.Consume(ctx, &api.ConsumeRequest{Topic: "orders", Partition: 3})
+	s.Require().Equal(codes.NotFound, status.Code(err))
+}
+
+func (s *ServerTestSuite) TestCommitFetchOffset() {
+	ctx := context.Background()
+	fetch, err := s.resources.client.FetchOffset(ctx, &api.FetchOffsetRequest{Group: "billing"})
+	s.Require().NoError(err)
+	s.Require().False(fetch.Committed)
+
+	_, err = s.resources.client.CommitOffset(ctx, &api.CommitOffsetRequest{Group: "billing", Offset: 3})
+	s.Require().NoError(err)
+	fetch, err = s.resources.client.FetchOffset(ctx, &api.FetchOffsetRequest{Group: "billing"})
+	s.Require().NoError(err)
+	s.Require().True(fetch.Committed)
+	s.Require().Equal(uint64(3), fetch.Offset)
+
+	_, err = s.resources.client.CommitOffset(ctx, &api.CommitOffsetRequest{Offset: 3})
+	s.Require().Equal(codes.InvalidArgument, status.Code(err))
+	_, err = s.resources.client.CommitOffset(ctx, &api.CommitOffsetRequest{Group: "billing", Topic: "missing"})
+	s.Require().Equal(codes.NotFound, status.Code(err))
+}
+
+func (s *ServerTestSuite) TestDeleteTopicForgetsOffsets() {
+	ctx := context.Background()
+	_, err := s.resources.client.CreateTopic(ctx, &api.CreateTopicRequest{Name: "orders"})
+	s.Require().NoError(err)
+	_, err = s.resources.client.CommitOffset(ctx, &api.CommitOffsetRequest{Group: "billing", Topic: "orders", Offset: 3})
+	s.Require().NoError(err)
+
+	// groups of a topic created again under the same name start over
+	_, err = s.resources.client.DeleteTopic(ctx, &api.DeleteTopicRequest{Name: "orders"})
+	s.Require().NoError(err)
+	_, err = s.resources.client.CreateTopic(ctx, &api.CreateTopicRequest{Name: "orders"})
+	s.Require().NoError(err)
+	fetch, err := s.resources.client.FetchOffset(ctx, &api.FetchOffsetRequest{Group: "billing", Topic: "orders"})
+	s.Require().NoError(err)
+	s.Require().False(fetch.Committed)
+}
+
+func (s *ServerTestSuite) TestConsumeStreamResumesGroup() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		_, err := s.resources.client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("test api record")},
+		})
+		s.Require().NoError(err)
+	}
+
+	// without a commit the reset policy picks the starting offset
+	stream, err := s.resources.client.ConsumeStream(ctx, &api.ConsumeRequest{Group: "billing", Offset: 2})
+	s.Require().NoError(err)
+	res, err := stream.Recv()
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(0), res.Record.Offset)
+
+	_, err = s.resources.client.CommitOffset(ctx, &api.CommitOffsetRequest{Group: "billing", Offset: 1})
+	s.Require().NoError(err)
+	stream, err = s.resources.client.ConsumeStream(ctx, &api.ConsumeRequest{Group: "billing"})
+	s.Require().NoError(err)
+	res, err = stream.Recv()
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(1), res.Record.Offset)
+
+	stream, err = s.resources.client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Group:       "shipping",
+		OffsetReset: api.OffsetReset_OFFSET_RESET_LATEST,
+	})
+	s.Require().NoError(err)
+	// wait for the stream to be positioned before producing
+	_, err = stream.Header()
+	s.Require().NoError(err)
+	_, err = s.resources.client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("latest record")},
+	})
+	s.Require().NoError(err)
+	res, err = stream.Recv()
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(3), res.Record.Offset)
+}
+
+func (s *ServerTestSuite) TestMetrics() {
+	ctx := context.Background()
+	_, err := s.resources.client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("test api record")}})
+	s.Require().NoError(err)
+	_, err = s.resources.client.Consume(ctx, &api.ConsumeRequest{Offset: 5})
+	s.Require().Error(err)
+
+	var buf bytes.Buffer
+	_, err = metrics.Default.WriteTo(&buf)
+	s.Require().NoError(err)
+	s.Require().Contains(buf.String(),
+		fmt.Sprintf(`commitlog_grpc_requests_total{method="%s",code="OK"}`, api.Log_Produce_FullMethodName))
+	s.Require().Contains(buf.String(),
+		fmt.Sprintf(`commitlog_grpc_request_seconds_count{method="%s"}`, api.Log_Consume_FullMethodName))
+	s.Require().Contains(buf.String(), "commitlog_log_append_seconds_count")
+	s.Require().Contains(buf.String(), "commitlog_log_read_errors_total")
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"time"
+)
+
+// sessionLagInterval is how often a ConsumeSession reports its lag
+var sessionLagInterval = time.Second
+
+// session is the state of a ConsumeSession, only the goroutine serving it touches it
+type session struct {
+	// req is the start request, its offset is the position of the session
+	req     *api.ConsumeRequest
+	wal     WriteAheadLog
+	records uint64
+	bytes   uint64
+	// byRecords and byBytes are set once the client granted credit of that kind,
+	// from then on records are only sent while credit of that kind is left
+	byRecords bool
+	byBytes   bool
+	paused    bool
+}
+
+// ConsumeSession implements a bidirectional streaming RPC. It reads a
+// partition like ConsumeStream, but only sends records the client granted
+// credit for. The client may seek, pause and resume at any time, and the server
+// reports the lag of the session periodically. The session ends when the client
+// closes its side of the stream
+func (s *grpcServer) ConsumeSession(stream api.Log_ConsumeSessionServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	start := first.GetStart()
+	if start == nil {
+		return status.Error(codes.InvalidArgument, "consume session should begin with a start command")
+	}
+	wal, err := s.log(start.Topic, start.Partition)
+	if err != nil {
+		return err
+	}
+	if start.Group != "" {
+		if start.Offset, err = s.groupOffset(start, wal); err != nil {
+			return err
+		}
+	}
+	if start.Offset < wal.LowestOffset() {
+		return log.ErrOffsetOutOfRange{Offset: start.Offset}
+	}
+	sess := &session{req: start, wal: wal}
+
+	commands := make(chan *api.ConsumeSessionRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			cmd, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case commands <- cmd:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	if err = s.sendLag(stream, sess); err != nil {
+		return err
+	}
+	lag := time.NewTicker(sessionLagInterval)
+	defer lag.Stop()
+	// ready never blocks, it lets commands in between records that are sent back to back
+	ready := make(chan time.Time)
+	close(ready)
+	for {
+		sent, err := s.sendNext(stream, sess)
+		if err != nil {
+			return err
+		}
+		var timer *time.Timer
+		wait := (<-chan time.Time)(ready)
+		switch {
+		case sent:
+		case !sess.sendable():
+			// nothing is sent until a command arrives
+			wait = nil
+		default:
+			timer = time.NewTimer(pollInterval)
+			wait = timer.C
+		}
+
+		var done bool
+		select {
+		case cmd := <-commands:
+			err = sess.apply(cmd)
+		case err = <-recvErr:
+			done = true
+		case <-lag.C:
+			err = s.sendLag(stream, sess)
+		case <-wait:
+		case <-stream.Context().Done():
+			done = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if done && err == io.EOF {
+			return nil
+		}
+		if done || err != nil {
+			return err
+		}
+	}
+}
+
+// apply changes the session as the command of the client asks
+func (sess *session) apply(cmd *api.ConsumeSessionRequest) error {
+	switch c := cmd.Command.(type) {
+	case *api.ConsumeSessionRequest_Credit:
+		sess.records += c.Credit.Records
+		sess.bytes += c.Credit.Bytes
+		sess.byRecords = sess.byRecords || c.Credit.Records > 0
+		sess.byBytes = sess.byBytes || c.Credit.Bytes > 0
+	case *api.ConsumeSessionRequest_Seek:
+		if c.Seek.Offset < sess.wal.LowestOffset() {
+			return log.ErrOffsetOutOfRange{Offset: c.Seek.Offset}
+		}
+		sess.req.Offset = c.Seek.Offset
+	case *api.ConsumeSessionRequest_Pause:
+		sess.paused = true
+	case *api.ConsumeSessionRequest_Resume:
+		sess.paused = false
+	case *api.ConsumeSessionRequest_Start:
+		return status.Error(codes.InvalidArgument, "consume session has already started")
+	default:
+		return status.Error(codes.InvalidArgument, "consume session command should not be empty")
+	}
+	return nil
+}
+
+// sendable reports whether the session is running and has credit of every kind the client granted
+func (sess *session) sendable() bool {
+	switch {
+	case sess.paused, !sess.byRecords && !sess.byBytes:
+		return false
+	case sess.byRecords && sess.records == 0, sess.byBytes && sess.bytes == 0:
+		return false
+	}
+	return true
+}
+
+// spend takes the credit of a sent record, the bytes credit may be overdrawn by the last record
+func (sess *session) spend(rec *api.Record) {
+	if sess.byRecords {
+		sess.records--
+	}
+	if sess.byBytes {
+		sess.bytes -= min(sess.bytes, uint64(proto.Size(rec)))
+	}
+}
+
+// sendNext sends the next visible record when the session has credit for it,
+// sent is false when there is none or no record to send yet
+func (s *grpcServer) sendNext(stream api.Log_ConsumeSessionServer, sess *session) (sent bool, err error) {
+	if !sess.sendable() {
+		return false, nil
+	}
+	for {
+		_, limit := s.readLimit(sess.req, sess.wal)
+		if sess.req.Offset >= limit {
+			return false, nil
+		}
+		rec, err := sess.wal.Read(sess.req.Offset)
+		switch err.(type) {
+		case nil:
+		case log.ErrOffsetOutOfRange:
+			// records below the lowest offset are gone and never show up
+			if sess.req.Offset < sess.wal.LowestOffset() {
+				return false, err
+			}
+			return false, nil
+		default:
+			return false, err
+		}
+		sess.req.Offset++
+		if !visible(sess.req, sess.wal, rec) {
+			continue
+		}
+		res := &api.ConsumeSessionResponse{Event: &api.ConsumeSessionResponse_Record{Record: rec}}
+		if err = stream.Send(res); err != nil {
+			return false, err
+		}
+		sess.spend(rec)
+		return true, nil
+	}
+}
+
+func (s *grpcServer) sendLag(stream api.Log_ConsumeSessionServer, sess *session) error {
+	hwm := s.highWatermark(sess.req.Topic, sess.req.Partition, sess.wal)
+	lag := &api.Lag{HighWatermark: hwm, Position: sess.req.Offset}
+	if hwm > sess.req.Offset {
+		lag.Lag = hwm - sess.req.Offset
+	}
+	return stream.Send(&api.ConsumeSessionResponse{Event: &api.ConsumeSessionResponse_Lag{Lag: lag}})
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"time"
+)
+
+func (s *ServerTestSuite) TestConsumeSession() {
+	defer func(interval time.Duration) { sessionLagInterval = interval }(sessionLagInterval)
+	sessionLagInterval = 20 * time.Millisecond
+	s.produceRecords(5)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := s.resources.client.ConsumeSession(ctx)
+	s.Require().NoError(err)
+	s.Require().NoError(stream.Send(startCommand(&api.ConsumeRequest{})))
+	s.Require().Equal(&api.Lag{HighWatermark: 5, Position: 0, Lag: 5}, s.recvLag(stream))
+
+	s.Require().NoError(stream.Send(creditCommand(2, 0)))
+	s.Require().Equal(uint64(0), s.recvRecord(stream).Offset)
+	s.Require().Equal(uint64(1), s.recvRecord(stream).Offset)
+	// without credit the session only reports its lag
+	s.Require().Equal(uint64(2), s.recvLag(stream).Position)
+
+	// a paused session keeps the credit it is granted
+	pause := &api.ConsumeSessionRequest{Command: &api.ConsumeSessionRequest_Pause{Pause: &api.Pause{}}}
+	s.Require().NoError(stream.Send(pause))
+	s.Require().NoError(stream.Send(creditCommand(10, 0)))
+	s.Require().Equal(uint64(2), s.recvLag(stream).Position)
+
+	seek := &api.ConsumeSessionRequest{Command: &api.ConsumeSessionRequest_Seek{Seek: &api.Seek{Offset: 4}}}
+	resume := &api.ConsumeSessionRequest{Command: &api.ConsumeSessionRequest_Resume{Resume: &api.Resume{}}}
+	s.Require().NoError(stream.Send(seek))
+	s.Require().NoError(stream.Send(resume))
+	s.Require().Equal(uint64(4), s.recvRecord(stream).Offset)
+	s.Require().Equal(&api.Lag{HighWatermark: 5, Position: 5, Lag: 0}, s.recvLag(stream))
+
+	s.Require().NoError(stream.CloseSend())
+	// the session ends cleanly once the client closed its side
+	_, err = stream.Recv()
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	s.Require().Equal(io.EOF, err)
+}
+
+func (s *ServerTestSuite) TestConsumeSessionByteCredit() {
+	defer func(interval time.Duration) { sessionLagInterval = interval }(sessionLagInterval)
+	sessionLagInterval = 20 * time.Millisecond
+	s.produceRecords(3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := s.resources.client.ConsumeSession(ctx)
+	s.Require().NoError(err)
+	s.Require().NoError(stream.Send(startCommand(&api.ConsumeRequest{})))
+	s.recvLag(stream)
+
+	// a record larger than the credit left still goes out
+	s.Require().NoError(stream.Send(creditCommand(0, 1)))
+	s.Require().Equal(uint64(0), s.recvRecord(stream).Offset)
+	s.Require().Equal(uint64(1), s.recvLag(stream).Position)
+
+	s.Require().NoError(stream.Send(creditCommand(0, 1<<20)))
+	s.Require().Equal(uint64(1), s.recvRecord(stream).Offset)
+	s.Require().Equal(uint64(2), s.recvRecord(stream).Offset)
+}
+
+func (s *ServerTestSuite) TestConsumeSessionRecordAndByteCredit() {
+	defer func(interval time.Duration) { sessionLagInterval = interval }(sessionLagInterval)
+	sessionLagInterval = 20 * time.Millisecond
+	s.produceRecords(4)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := s.resources.client.ConsumeSession(ctx)
+	s.Require().NoError(err)
+	s.Require().NoError(stream.Send(startCommand(&api.ConsumeRequest{})))
+	s.recvLag(stream)
+
+	// the first record spends all of the bytes credit, so the records credit left waits for more
+	s.Require().NoError(stream.Send(creditCommand(3, 1)))
+	s.Require().Equal(uint64(0), s.recvRecord(stream).Offset)
+	s.Require().Equal(uint64(1), s.recvLag(stream).Position)
+
+	s.Require().NoError(stream.Send(creditCommand(0, 1<<20)))
+	s.Require().Equal(uint64(1), s.recvRecord(stream).Offset)
+	s.Require().Equal(uint64(2), s.recvRecord(stream).Offset)
+	s.Require().Equal(uint64(3), s.recvLag(stream).Position)
+}
+
+func (s *ServerTestSuite) TestConsumeSessionStartBelowLowestOffset() {
+	client := s.serveLogFrom(5)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.ConsumeSession(ctx)
+	s.Require().NoError(err)
+	s.Require().NoError(stream.Send(startCommand(&api.ConsumeRequest{Offset: 2})))
+	_, err = stream.Recv()
+	s.Require().Equal(status.Code(log.ErrOffsetOutOfRange{}), status.Code(err))
+}
+
+func (s *ServerTestSuite) TestConsumeSessionCommands() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := s.resources.client.ConsumeSession(ctx)
+	s.Require().NoError(err)
+	s.Require().NoError(stream.Send(creditCommand(1, 0)))
+	_, err = stream.Recv()
+	s.Require().Equal(codes.InvalidArgument, status.Code(err))
+
+	stream, err = s.resources.client.ConsumeSession(ctx)
+	s.Require().NoError(err)
+	s.Require().NoError(stream.Send(startCommand(&api.ConsumeRequest{})))
+	s.recvLag(stream)
+	s.Require().NoError(stream.Send(startCommand(&api.ConsumeRequest{})))
+	_, err = stream.Recv()
+	s.Require().Equal(codes.InvalidArgument, status.Code(err))
+}
+
+func (s *ServerTestSuite) produceRecords(n int) {
+	for i := 0; i < n; i++ {
+		_, err := s.resources.client.Produce(context.Background(),
+			&api.ProduceRequest{Record: &api.Record{Value: []byte("session record")}})
+		s.Require().NoError(err)
+	}
+}
+
+func startCommand(req *api.ConsumeRequest) *api.ConsumeSessionRequest {
+	return &api.ConsumeSessionRequest{Command: &api.ConsumeSessionRequest_Start{Start: req}}
+}
+
+func creditCommand(records, bytes uint64) *api.ConsumeSessionRequest {
+	return &api.ConsumeSessionRequest{Command: &api.ConsumeSessionRequest_Credit{
+		Credit: &api.Credit{Records: records, Bytes: bytes},
+	}}
+}
+
+// recvRecord skips lag updates until the session sends a record
+func (s *ServerTestSuite) recvRecord(stream api.Log_ConsumeSessionClient) *api.Record {
+	for {
+		res, err := stream.Recv()
+		s.Require().NoError(err)
+		if rec := res.GetRecord(); rec != nil {
+			return rec
+		}
+	}
+}
+
+// recvLag expects the next message of the session to be a lag update
+func (s *ServerTestSuite) recvLag(stream api.Log_ConsumeSessionClient) *api.Lag {
+	res, err := stream.Recv()
+	s.Require().NoError(err)
+	s.Require().NotNil(res.GetLag(), "session sent %v", res)
+	return res.GetLag()
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sseHeartbeat is how often an idle event stream sends a comment, so proxies keep the connection open
+var sseHeartbeat = 15 * time.Second
+
+// errEventLimit ends an event stream that sent as many records as its client asked for
+var errEventLimit = errors.New("event stream reached its limit")
+
+// handleEvents tails a partition as Server-Sent Events with ConsumeStream. Every
+// record is sent as a "record" event whose id is its offset, so a reconnecting
+// EventSource resumes after the Last-Event-ID it saw. The limit query parameter
+// ends the stream after that many records, at most MaxEventsPerStream. Streams
+// past MaxEventStreams are rejected with 429 Too Many Requests
+func (g *httpGateway) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeHTTPError(w, status.Error(codes.Unimplemented, "streaming is not supported by this connection"))
+		return
+	}
+	query := r.URL.Query()
+	req := &api.ConsumeRequest{Topic: query.Get("topic"), Group: query.Get("group")}
+	var err error
+	if req.Offset, err = parseUint(query.Get("offset"), 64); err != nil {
+		writeHTTPError(w, status.Errorf(codes.InvalidArgument, "invalid offset: %v", err))
+		return
+	}
+	if req.IsolationLevel, err = parseIsolation(query.Get("isolation")); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		last, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			writeHTTPError(w, status.Errorf(codes.InvalidArgument, "invalid Last-Event-ID: %v", err))
+			return
+		}
+		// a resumed stream continues after the last record it saw, even for groups
+		req.Offset, req.Group = last+1, ""
+	}
+	partition, err := parseUint(query.Get("partition"), 32)
+	if err != nil {
+		writeHTTPError(w, status.Errorf(codes.InvalidArgument, "invalid partition: %v", err))
+		return
+	}
+	req.Partition = uint32(partition)
+	limit, err := parseUint(query.Get("limit"), 64)
+	if err != nil {
+		writeHTTPError(w, status.Errorf(codes.InvalidArgument, "invalid limit: %v", err))
+		return
+	}
+	if max := g.server.MaxEventsPerStream; max > 0 && (limit == 0 || limit > max) {
+		limit = max
+	}
+	if req.OffsetReset, err = offsetReset(query.Get("reset")); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	ctx, err := g.context(r, api.Log_ConsumeStream_FullMethodName, req)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if g.eventStreams != nil {
+		select {
+		case g.eventStreams <- struct{}{}:
+			defer func() { <-g.eventStreams }()
+		default:
+			writeHTTPError(w, status.Error(codes.ResourceExhausted, "too many open event streams"))
+			return
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stream := &eventStream{ctx: ctx, w: w, flusher: flusher, limit: limit}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		stream.heartbeat(sseHeartbeat)
+	}()
+	// the response may not be written to once the handler returned
+	defer wg.Wait()
+	defer cancel()
+
+	err = g.server.ConsumeStream(req, stream)
+	switch {
+	case err == nil, errors.Is(err, errEventLimit):
+	case !stream.headerSent():
+		writeHTTPError(w, err)
+	default:
+		st := status.Convert(err)
+		_ = stream.event("error", "", fmt.Sprintf(`{"code":%q,"message":%q}`, st.Code().String(), st.Message()))
+	}
+}
+
+func offsetReset(reset string) (api.OffsetReset, error) {
+	switch reset {
+	case "", "earliest":
+		return api.OffsetReset_OFFSET_RESET_EARLIEST, nil
+	case "latest":
+		return api.OffsetReset_OFFSET_RESET_LATEST, nil
+	default:
+		return 0, status.Errorf(codes.InvalidArgument, "invalid reset %q, should be earliest or latest", reset)
+	}
+}
+
+// guarantee *eventStream meets api.Log_ConsumeStreamServer interface at compile time
+var _ api.Log_ConsumeStreamServer = &eventStream{}
+
+// eventStream writes the responses of ConsumeStream to an HTTP response as Server-Sent Events
+type eventStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	flusher http.Flusher
+	limit   uint64
+
+	mu     sync.Mutex
+	w      http.ResponseWriter
+	header bool
+	sent   uint64
+}
+
+func (s *eventStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *eventStream) SetHeader(metadata.MD) error {
+	return nil
+}
+
+func (s *eventStream) SendHeader(metadata.MD) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeHeader()
+	return nil
+}
+
+func (s *eventStream) Send(res *api.ConsumeResponse) error {
+	b, err := httpJSON.Marshal(res)
+	if err != nil {
+		return err
+	}
+	if err = s.event("record", strconv.FormatUint(res.Record.Offset, 10), string(b)); err != nil {
+		return err
+	}
+	s.sent++
+	if s.limit > 0 && s.sent >= s.limit {
+		return errEventLimit
+	}
+	return nil
+}
+
+// heartbeat sends a comment every interval until the stream is done
+func (s *eventStream) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.header {
+				_, _ = fmt.Fprint(s.w, ": heartbeat\n\n")
+				s.flusher.Flush()
+			}
+			s.mu.Unlock()
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *eventStream) event(name string, id string, data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeHeader()
+	if id != "" {
+		if _, err := fmt.Fprintf(s.w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *eventStream) headerSent() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.header
+}
+
+// writeHeader must be called with the lock held
+func (s *eventStream) writeHeader() {
+	if s.header {
+		return
+	}
+	s.header = true
+	s.w.Header().Set("Content-Type", "text/event-stream")
+	s.w.Header().Set("Cache-Control", "no-cache")
+	s.w.WriteHeader(http.StatusOK)
+	s.flusher.Flush()
+}
@@ -0,0 +1,21 @@
+package topic
+
+import (
+	"github.com/a-shakra/commit-log/internal/log"
+)
+
+type options struct {
+	logOptions func(topic string, partition uint32) []log.Options
+}
+
+type Options func(options *options) error
+
+// WithLogOptions sets the options that the Log of every partition is opened with.
+// The options are resolved per partition so that e.g. tiered storage key prefixes
+// can differ between partitions
+func WithLogOptions(fn func(topic string, partition uint32) []log.Options) Options {
+	return func(options *options) error {
+		options.logOptions = fn
+		return nil
+	}
+}
@@ -0,0 +1,72 @@
+package topic
+
+import (
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type ErrTopicNotFound struct {
+	Topic string
+}
+
+func (e ErrTopicNotFound) GRPCStatus() *status.Status {
+	return status.New(codes.NotFound, fmt.Sprintf("topic does not exist: %q", e.Topic))
+}
+
+func (e ErrTopicNotFound) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrTopicExists struct {
+	Topic string
+}
+
+func (e ErrTopicExists) GRPCStatus() *status.Status {
+	return status.New(codes.AlreadyExists, fmt.Sprintf("topic already exists: %q", e.Topic))
+}
+
+func (e ErrTopicExists) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrInvalidTopic struct {
+	Topic  string
+	Reason string
+}
+
+func (e ErrInvalidTopic) GRPCStatus() *status.Status {
+	return status.New(codes.InvalidArgument, fmt.Sprintf("invalid topic %q: %s", e.Topic, e.Reason))
+}
+
+func (e ErrInvalidTopic) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrPartitionNotFound struct {
+	Topic     string
+	Partition uint32
+}
+
+func (e ErrPartitionNotFound) GRPCStatus() *status.Status {
+	return status.New(codes.NotFound, fmt.Sprintf("topic %q has no partition %d", e.Topic, e.Partition))
+}
+
+func (e ErrPartitionNotFound) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrUnroutableProducer struct {
+	Topic       string
+	Partitioner string
+}
+
+func (e ErrUnroutableProducer) GRPCStatus() *status.Status {
+	return status.New(codes.InvalidArgument, fmt.Sprintf(
+		"records of idempotent producers to topic %q should name a partition, partitioner %q may route retries elsewhere",
+		e.Topic, e.Partitioner))
+}
+
+func (e ErrUnroutableProducer) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
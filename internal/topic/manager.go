@@ -0,0 +1,327 @@
+package topic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTopic is the topic that requests without a topic name are served from.
+	// It is created when the Manager is opened and cannot be deleted
+	DefaultTopic = "default"
+
+	metadataFile = "topics.json"
+
+	// internalPrefix marks names that are reserved for data the server keeps
+	// next to the topics, such as committed consumer group offsets
+	internalPrefix = "__"
+)
+
+var validTopicName = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,249}$`)
+
+// Metadata describes a topic and is persisted in the data root of the Manager
+type Metadata struct {
+	Name        string    `json:"name"`
+	CreatedAt   time.Time `json:"created_at"`
+	Partitions  uint32    `json:"partitions"`
+	Partitioner string    `json:"partitioner"`
+}
+
+// Topic is a named stream of records split into partitions. Every partition is
+// an independent Log, so records are only ordered within a partition
+type Topic struct {
+	Metadata
+	partitions  []*log.Log
+	partitioner Partitioner
+}
+
+// Partition returns the Log that backs the given partition of the topic
+func (t *Topic) Partition(p uint32) (*log.Log, error) {
+	if p >= uint32(len(t.partitions)) {
+		return nil, ErrPartitionNotFound{Topic: t.Name, Partition: p}
+	}
+	return t.partitions[p], nil
+}
+
+// Route returns the partition the topic's partitioner assigns to the record.
+// Sequences of idempotent producers are tracked per partition, so their retries
+// must land where the first attempt did: unless the key picks the partition
+// their records are routed by producer id. Custom partitioners give no such
+// guarantee, idempotent producers name the partition of their records instead
+func (t *Topic) Route(record *api.Record) (uint32, error) {
+	if record.ProducerId == "" {
+		return t.partitioner.Partition(record, t.Partitions), nil
+	}
+	switch {
+	case t.Partitioner == HashPartitioner && len(record.Key) > 0:
+		return hashPartition(record.Key, t.Partitions), nil
+	case t.Partitioner == HashPartitioner || t.Partitioner == RoundRobinPartitioner:
+		return hashPartition([]byte(record.ProducerId), t.Partitions), nil
+	default:
+		return 0, ErrUnroutableProducer{Topic: t.Name, Partitioner: t.Partitioner}
+	}
+}
+
+func (t *Topic) close() error {
+	var errs []error
+	for _, l := range t.partitions {
+		errs = append(errs, l.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Manager is the registry of topics served by a single server. Every topic
+// is stored in a subdirectory of the data root named after it, which in turn
+// holds one Log directory per partition
+type Manager struct {
+	mu sync.RWMutex
+
+	Dir     string
+	topics  map[string]*Topic
+	options options
+}
+
+// NewManager opens every topic registered in the metadata file of dir
+// and creates the default topic if it does not exist yet
+func NewManager(dir string, opts ...Options) (*Manager, error) {
+	var mOpts options
+	for _, opt := range opts {
+		if err := opt(&mOpts); err != nil {
+			return nil, fmt.Errorf("error on topic manager creation: %v", err)
+		}
+	}
+	if mOpts.logOptions == nil {
+		mOpts.logOptions = func(string, uint32) []log.Options { return nil }
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		Dir:     dir,
+		topics:  make(map[string]*Topic),
+		options: mOpts,
+	}
+	metas, err := m.readMetadata()
+	if err != nil {
+		return nil, err
+	}
+	for _, meta := range metas {
+		t, err := m.openTopic(meta)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.topics[meta.Name] = t
+	}
+	if _, ok := m.topics[DefaultTopic]; !ok {
+		if _, err = m.Create(DefaultTopic, 1, DefaultPartitioner); err != nil {
+			m.Close()
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Create registers a new topic and opens an empty Log for each of its partitions.
+// A topic has at least one partition and uses the default partitioner unless another is named
+func (m *Manager) Create(name string, partitions uint32, partitioner string) (Metadata, error) {
+	if err := validateName(name); err != nil {
+		return Metadata{}, err
+	}
+	if partitions == 0 {
+		partitions = 1
+	}
+	if partitioner == "" {
+		partitioner = DefaultPartitioner
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.topics[name]; ok {
+		return Metadata{}, ErrTopicExists{Topic: name}
+	}
+	// a directory without metadata is a leftover of an interrupted delete
+	if err := os.RemoveAll(m.topicDir(name)); err != nil {
+		return Metadata{}, err
+	}
+	t, err := m.openTopic(Metadata{
+		Name:        name,
+		CreatedAt:   time.Now().UTC(),
+		Partitions:  partitions,
+		Partitioner: partitioner,
+	})
+	if err != nil {
+		return Metadata{}, err
+	}
+	m.topics[name] = t
+	if err = m.writeMetadata(); err != nil {
+		delete(m.topics, name)
+		return Metadata{}, errors.Join(err, t.close(), os.RemoveAll(m.topicDir(name)))
+	}
+	return t.Metadata, nil
+}
+
+// Delete unregisters a topic and removes all of its data. Calls that still
+// hold one of its partitions get log.ErrLogClosed from it
+func (m *Manager) Delete(name string) error {
+	if name == DefaultTopic {
+		return ErrInvalidTopic{Topic: name, Reason: "the default topic cannot be deleted"}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.topics[name]
+	if !ok {
+		return ErrTopicNotFound{Topic: name}
+	}
+	delete(m.topics, name)
+	if err := m.writeMetadata(); err != nil {
+		m.topics[name] = t
+		return err
+	}
+	for _, l := range t.partitions {
+		if err := l.Remove(); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(m.topicDir(name))
+}
+
+// Get returns the topic with the given name
+func (m *Manager) Get(name string) (*Topic, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.topics[name]
+	if !ok {
+		return nil, ErrTopicNotFound{Topic: name}
+	}
+	return t, nil
+}
+
+// List returns the metadata of every topic sorted by name
+func (m *Manager) List() []Metadata {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.list()
+}
+
+// Close closes the Log of every topic
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var errs []error
+	for _, t := range m.topics {
+		errs = append(errs, t.close())
+	}
+	return errors.Join(errs...)
+}
+
+// Remove closes every topic and deletes the whole data root
+func (m *Manager) Remove() error {
+	if err := m.Close(); err != nil {
+		return err
+	}
+	return os.RemoveAll(m.Dir)
+}
+
+func (m *Manager) list() []Metadata {
+	metas := make([]Metadata, 0, len(m.topics))
+	for _, t := range m.topics {
+		metas = append(metas, t.Metadata)
+	}
+	sort.Slice(metas, func(i, j int) bool { return metas[i].Name < metas[j].Name })
+	return metas
+}
+
+func (m *Manager) topicDir(name string) string {
+	return filepath.Join(m.Dir, name)
+}
+
+// openTopic opens the Log of every partition of the topic described by meta
+func (m *Manager) openTopic(meta Metadata) (*Topic, error) {
+	if meta.Partitions == 0 {
+		meta.Partitions = 1
+	}
+	if meta.Partitioner == "" {
+		meta.Partitioner = DefaultPartitioner
+	}
+	partitioner, err := newPartitioner(meta.Partitioner)
+	if err != nil {
+		return nil, ErrInvalidTopic{Topic: meta.Name, Reason: err.Error()}
+	}
+	t := &Topic{Metadata: meta, partitioner: partitioner}
+	for p := uint32(0); p < meta.Partitions; p++ {
+		dir := filepath.Join(m.topicDir(meta.Name), strconv.FormatUint(uint64(p), 10))
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return nil, errors.Join(err, t.close())
+		}
+		l, err := log.NewLog(dir, m.options.logOptions(meta.Name, p)...)
+		if err != nil {
+			return nil, errors.Join(err, t.close())
+		}
+		t.partitions = append(t.partitions, l)
+	}
+	return t, nil
+}
+
+func (m *Manager) readMetadata() ([]Metadata, error) {
+	b, err := os.ReadFile(filepath.Join(m.Dir, metadataFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var metas []Metadata
+	if err = json.Unmarshal(b, &metas); err != nil {
+		return nil, fmt.Errorf("corrupted topic metadata: %w", err)
+	}
+	return metas, nil
+}
+
+// writeMetadata replaces the metadata file atomically so that a crash never leaves it half written
+func (m *Manager) writeMetadata() error {
+	b, err := json.MarshalIndent(m.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(m.Dir, metadataFile+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(m.Dir, metadataFile))
+}
+
+func validateName(name string) error {
+	if !validTopicName.MatchString(name) || name == "." || name == ".." {
+		return ErrInvalidTopic{Topic: name, Reason: "names may only contain letters, digits, '.', '_' and '-'"}
+	}
+	if name == metadataFile || strings.HasPrefix(name, internalPrefix) {
+		return ErrInvalidTopic{Topic: name, Reason: "name is reserved"}
+	}
+	return nil
+}
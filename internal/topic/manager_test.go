@@ -0,0 +1,178 @@
+package topic
+
+import (
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/log"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type ManagerTestSuite struct {
+	suite.Suite
+	testDir string
+	manager *Manager
+}
+
+func TestManagerTestSuite(t *testing.T) {
+	suite.Run(t, &ManagerTestSuite{})
+}
+
+func (s *ManagerTestSuite) SetupTest() {
+	dir, err := os.MkdirTemp("", "topic-manager-test")
+	s.Require().NoError(err)
+	s.testDir = dir
+
+	s.manager, err = NewManager(dir)
+	s.Require().NoError(err)
+}
+
+func (s *ManagerTestSuite) TearDownTest() {
+	err := os.RemoveAll(s.testDir)
+	s.Require().NoError(err)
+}
+
+func (s *ManagerTestSuite) TestDefaultTopicExists() {
+	_, err := s.manager.Get(DefaultTopic)
+	s.Require().NoError(err)
+	err = s.manager.Delete(DefaultTopic)
+	s.Require().ErrorAs(err, &ErrInvalidTopic{})
+}
+
+func (s *ManagerTestSuite) TestCreateAndGet() {
+	meta, err := s.manager.Create("orders", 1, "")
+	s.Require().NoError(err)
+	s.Require().Equal("orders", meta.Name)
+	s.Require().DirExists(filepath.Join(s.testDir, "orders"))
+
+	t, err := s.manager.Get("orders")
+	s.Require().NoError(err)
+	s.Require().Equal(uint32(1), t.Partitions)
+	s.Require().Equal(DefaultPartitioner, t.Partitioner)
+	l, err := t.Partition(0)
+	s.Require().NoError(err)
+	off, err := l.Append(&api.Record{Value: []byte("test input")})
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(0), off)
+}
+
+func (s *ManagerTestSuite) TestCreateExistingThenFail() {
+	_, err := s.manager.Create("orders", 1, "")
+	s.Require().NoError(err)
+	_, err = s.manager.Create("orders", 1, "")
+	s.Require().ErrorIs(err, ErrTopicExists{Topic: "orders"})
+}
+
+func (s *ManagerTestSuite) TestCreateInvalidNameThenFail() {
+	for _, name := range []string{"", "..", "a/b", metadataFile, "__consumer_offsets"} {
+		_, err := s.manager.Create(name, 1, "")
+		s.Require().ErrorAs(err, &ErrInvalidTopic{})
+	}
+}
+
+func (s *ManagerTestSuite) TestDelete() {
+	_, err := s.manager.Create("orders", 1, "")
+	s.Require().NoError(err)
+	err = s.manager.Delete("orders")
+	s.Require().NoError(err)
+	s.Require().NoDirExists(filepath.Join(s.testDir, "orders"))
+
+	_, err = s.manager.Get("orders")
+	s.Require().ErrorIs(err, ErrTopicNotFound{Topic: "orders"})
+	err = s.manager.Delete("orders")
+	s.Require().ErrorIs(err, ErrTopicNotFound{Topic: "orders"})
+}
+
+func (s *ManagerTestSuite) TestDeleteWhilePartitionInUse() {
+	_, err := s.manager.Create("orders", 1, "")
+	s.Require().NoError(err)
+	t, err := s.manager.Get("orders")
+	s.Require().NoError(err)
+	l, err := t.Partition(0)
+	s.Require().NoError(err)
+	err = s.manager.Delete("orders")
+	s.Require().NoError(err)
+
+	// callers that still hold the partition get an error instead of touching removed files
+	_, err = l.Append(&api.Record{Value: []byte("test input")})
+	s.Require().ErrorIs(err, log.ErrLogClosed{})
+	_, err = l.Read(0)
+	s.Require().ErrorIs(err, log.ErrLogClosed{})
+}
+
+func (s *ManagerTestSuite) TestReopenRestoresTopics() {
+	_, err := s.manager.Create("orders", 3, RoundRobinPartitioner)
+	s.Require().NoError(err)
+	t, err := s.manager.Get("orders")
+	s.Require().NoError(err)
+	l, err := t.Partition(2)
+	s.Require().NoError(err)
+	_, err = l.Append(&api.Record{Value: []byte("test input")})
+	s.Require().NoError(err)
+	err = s.manager.Close()
+	s.Require().NoError(err)
+
+	s.manager, err = NewManager(s.testDir)
+	s.Require().NoError(err)
+	metas := s.manager.List()
+	s.Require().Equal(2, len(metas))
+	s.Require().Equal(DefaultTopic, metas[0].Name)
+	s.Require().Equal("orders", metas[1].Name)
+
+	t, err = s.manager.Get("orders")
+	s.Require().NoError(err)
+	s.Require().Equal(uint32(3), t.Partitions)
+	s.Require().Equal(RoundRobinPartitioner, t.Partitioner)
+	l, err = t.Partition(2)
+	s.Require().NoError(err)
+	rec, err := l.Read(0)
+	s.Require().NoError(err)
+	s.Require().Equal([]byte("test input"), rec.Value)
+}
+
+func (s *ManagerTestSuite) TestPartitionDirectories() {
+	_, err := s.manager.Create("orders", 2, "")
+	s.Require().NoError(err)
+	s.Require().DirExists(filepath.Join(s.testDir, "orders", "0"))
+	s.Require().DirExists(filepath.Join(s.testDir, "orders", "1"))
+
+	t, err := s.manager.Get("orders")
+	s.Require().NoError(err)
+	_, err = t.Partition(2)
+	s.Require().ErrorIs(err, ErrPartitionNotFound{Topic: "orders", Partition: 2})
+}
+
+func (s *ManagerTestSuite) TestCreateUnknownPartitionerThenFail() {
+	_, err := s.manager.Create("orders", 2, "sticky")
+	s.Require().ErrorAs(err, &ErrInvalidTopic{})
+	_, err = s.manager.Get("orders")
+	s.Require().ErrorIs(err, ErrTopicNotFound{Topic: "orders"})
+}
+
+func (s *ManagerTestSuite) TestRouteIdempotentProducers() {
+	_, err := s.manager.Create("orders", 4, RoundRobinPartitioner)
+	s.Require().NoError(err)
+	t, err := s.manager.Get("orders")
+	s.Require().NoError(err)
+	// retries of an idempotent producer reach the partition that tracks its sequences
+	rec := &api.Record{Value: []byte("test input"), ProducerId: "producer-1"}
+	want, err := t.Route(rec)
+	s.Require().NoError(err)
+	for i := 0; i < 8; i++ {
+		got, err := t.Route(rec)
+		s.Require().NoError(err)
+		s.Require().Equal(want, got)
+	}
+
+	RegisterPartitioner("last", func() Partitioner { return lastPartitioner{} })
+	_, err = s.manager.Create("payments", 4, "last")
+	s.Require().NoError(err)
+	t, err = s.manager.Get("payments")
+	s.Require().NoError(err)
+	_, err = t.Route(rec)
+	s.Require().ErrorIs(err, ErrUnroutableProducer{Topic: "payments", Partitioner: "last"})
+	got, err := t.Route(&api.Record{Value: []byte("test input")})
+	s.Require().NoError(err)
+	s.Require().Equal(uint32(3), got)
+}
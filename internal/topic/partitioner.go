@@ -0,0 +1,77 @@
+package topic
+
+import (
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	HashPartitioner       = "hash"
+	RoundRobinPartitioner = "round_robin"
+
+	// DefaultPartitioner is used by topics that were created without naming a partitioner
+	DefaultPartitioner = HashPartitioner
+)
+
+// Partitioner chooses the partition of a topic that a record is appended to
+// when the producer does not address a partition explicitly
+type Partitioner interface {
+	Partition(record *api.Record, partitions uint32) uint32
+}
+
+var (
+	partitionersMu sync.RWMutex
+	partitioners   = map[string]func() Partitioner{
+		HashPartitioner:       func() Partitioner { return &hashPartitioner{} },
+		RoundRobinPartitioner: func() Partitioner { return &roundRobinPartitioner{} },
+	}
+)
+
+// RegisterPartitioner makes a custom partitioner available to topics under the given name.
+// The constructor is called once for every topic that uses the partitioner
+func RegisterPartitioner(name string, fn func() Partitioner) {
+	partitionersMu.Lock()
+	defer partitionersMu.Unlock()
+	partitioners[name] = fn
+}
+
+func newPartitioner(name string) (Partitioner, error) {
+	partitionersMu.RLock()
+	defer partitionersMu.RUnlock()
+	fn, ok := partitioners[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown partitioner %q", name)
+	}
+	return fn(), nil
+}
+
+// hashPartitioner sends records with the same key to the same partition.
+// Records without a key are spread round robin
+type hashPartitioner struct {
+	roundRobinPartitioner
+}
+
+func (h *hashPartitioner) Partition(record *api.Record, partitions uint32) uint32 {
+	if len(record.Key) == 0 {
+		return h.roundRobinPartitioner.Partition(record, partitions)
+	}
+	return hashPartition(record.Key, partitions)
+}
+
+func hashPartition(b []byte, partitions uint32) uint32 {
+	hash := fnv.New32a()
+	hash.Write(b)
+	return hash.Sum32() % partitions
+}
+
+// roundRobinPartitioner cycles through the partitions regardless of the record
+type roundRobinPartitioner struct {
+	next atomic.Uint32
+}
+
+func (r *roundRobinPartitioner) Partition(_ *api.Record, partitions uint32) uint32 {
+	return (r.next.Add(1) - 1) % partitions
+}
@@ -0,0 +1,59 @@
+package topic
+
+import (
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type PartitionerTestSuite struct {
+	suite.Suite
+}
+
+func TestPartitionerTestSuite(t *testing.T) {
+	suite.Run(t, &PartitionerTestSuite{})
+}
+
+func (s *PartitionerTestSuite) TestHashIsStablePerKey() {
+	p, err := newPartitioner(HashPartitioner)
+	s.Require().NoError(err)
+	rec := &api.Record{Key: []byte("customer-42"), Value: []byte("test input")}
+	want := p.Partition(rec, 8)
+	for i := 0; i < 10; i++ {
+		s.Require().Equal(want, p.Partition(rec, 8))
+	}
+}
+
+func (s *PartitionerTestSuite) TestHashWithoutKeyFallsBackToRoundRobin() {
+	p, err := newPartitioner(HashPartitioner)
+	s.Require().NoError(err)
+	rec := &api.Record{Value: []byte("test input")}
+	for i := uint32(0); i < 6; i++ {
+		s.Require().Equal(i%3, p.Partition(rec, 3))
+	}
+}
+
+func (s *PartitionerTestSuite) TestRoundRobin() {
+	p, err := newPartitioner(RoundRobinPartitioner)
+	s.Require().NoError(err)
+	rec := &api.Record{Key: []byte("customer-42"), Value: []byte("test input")}
+	for i := uint32(0); i < 6; i++ {
+		s.Require().Equal(i%3, p.Partition(rec, 3))
+	}
+}
+
+type lastPartitioner struct{}
+
+func (lastPartitioner) Partition(_ *api.Record, partitions uint32) uint32 {
+	return partitions - 1
+}
+
+func (s *PartitionerTestSuite) TestRegisterPartitioner() {
+	RegisterPartitioner("last", func() Partitioner { return lastPartitioner{} })
+	p, err := newPartitioner("last")
+	s.Require().NoError(err)
+	s.Require().Equal(uint32(4), p.Partition(&api.Record{}, 5))
+
+	_, err = newPartitioner("unknown")
+	s.Require().Error(err)
+}
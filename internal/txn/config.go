@@ -0,0 +1,29 @@
+package txn
+
+import (
+	"errors"
+	"time"
+)
+
+var defaultTimeout = time.Minute
+
+// compactStates is how many states the state log holds before it is compacted,
+// provided most of them belong to ended transactions
+var compactStates uint64 = 1000
+
+type options struct {
+	timeout *time.Duration
+}
+
+type Options func(options *options) error
+
+// WithTimeout sets how long a transaction may go without records before it is aborted
+func WithTimeout(d time.Duration) Options {
+	return func(options *options) error {
+		if d <= 0 {
+			return errors.New("transaction timeout should be a positive duration")
+		}
+		options.timeout = &d
+		return nil
+	}
+}
@@ -0,0 +1,449 @@
+// Package txn coordinates transactions that write records to several
+// partitions atomically. Records of a transaction carry its id and are
+// committed or aborted together by a marker record written to every partition
+// the transaction wrote to
+package txn
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/log"
+	"github.com/a-shakra/commit-log/internal/topic"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	stateOngoing    = "ongoing"
+	stateCommitting = "committing"
+	stateAborting   = "aborting"
+	stateComplete   = "complete"
+
+	// compactedSuffix and replacedSuffix name the directories compact writes
+	// the new state log to and moves the current one to
+	compactedSuffix = ".compacted"
+	replacedSuffix  = ".replaced"
+)
+
+// Partition is a partition of a topic a transaction wrote to
+type Partition struct {
+	Topic     string `json:"topic"`
+	Partition uint32 `json:"partition"`
+}
+
+// transaction is persisted as a whole in the state log every time it changes
+type transaction struct {
+	// mu is held while a record of the transaction is written and while its markers are,
+	// so no record is written to a partition after the marker that ends it there
+	mu sync.Mutex
+
+	ID         string      `json:"id"`
+	State      string      `json:"state"`
+	Partitions []Partition `json:"partitions"`
+
+	// lastWrite is guarded by the lock of the Coordinator
+	lastWrite time.Time
+}
+
+func (t *transaction) hasPartition(p Partition) bool {
+	for _, tp := range t.Partitions {
+		if tp == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Coordinator keeps the state of every transaction in an internal Log, so
+// transactions interrupted by a crash are completed when it is opened again:
+// the ones that were ending get the rest of their markers, the open ones are
+// aborted. Open transactions are aborted as well when they go without records
+// for longer than the timeout
+type Coordinator struct {
+	mu sync.Mutex
+
+	Dir          string
+	log          *log.Log
+	topics       *topic.Manager
+	transactions map[string]*transaction
+	options      options
+
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewCoordinator opens the state log in dir and completes the transactions
+// it holds. Markers are written to the partitions of topics
+func NewCoordinator(dir string, topics *topic.Manager, opts ...Options) (*Coordinator, error) {
+	var cOpts options
+	for _, opt := range opts {
+		if err := opt(&cOpts); err != nil {
+			return nil, fmt.Errorf("error on transaction coordinator creation: %v", err)
+		}
+	}
+	if cOpts.timeout == nil {
+		cOpts.timeout = &defaultTimeout
+	}
+	l, err := openStateLog(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Coordinator{
+		Dir:          dir,
+		log:          l,
+		topics:       topics,
+		transactions: make(map[string]*transaction),
+		options:      cOpts,
+		closed:       make(chan struct{}),
+	}
+	if err = c.recover(); err != nil {
+		return nil, errors.Join(err, l.Close())
+	}
+	c.wg.Add(1)
+	go c.expire()
+	return c, nil
+}
+
+// Begin starts a transaction and returns its id
+func (c *Coordinator) Begin() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	t := &transaction{ID: hex.EncodeToString(b), State: stateOngoing}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.persist(t); err != nil {
+		return "", err
+	}
+	t.lastWrite = time.Now()
+	c.transactions[t.ID] = t
+	return t.ID, nil
+}
+
+// Write adds the partition to the transaction and calls write, which appends
+// a record of the transaction to it. The transaction cannot end while write runs
+func (c *Coordinator) Write(id string, p Partition, write func() error) error {
+	t, err := c.get(id)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.State != stateOngoing {
+		return ErrTransactionEnding{ID: id, State: t.State}
+	}
+
+	c.mu.Lock()
+	t.lastWrite = time.Now()
+	if !t.hasPartition(p) {
+		// the partition is persisted first, so a crash cannot leave records nobody aborts
+		t.Partitions = append(t.Partitions, p)
+		if err = c.persist(t); err != nil {
+			t.Partitions = t.Partitions[:len(t.Partitions)-1]
+			c.mu.Unlock()
+			return err
+		}
+	}
+	c.mu.Unlock()
+	return write()
+}
+
+// Commit makes the records of the transaction visible to consumers reading committed
+func (c *Coordinator) Commit(id string) error {
+	return c.end(id, stateCommitting)
+}
+
+// Abort hides the records of the transaction from consumers reading committed
+func (c *Coordinator) Abort(id string) error {
+	return c.end(id, stateAborting)
+}
+
+// Close stops expiring transactions and closes the state log. Open
+// transactions are aborted when the Coordinator is opened again
+func (c *Coordinator) Close() error {
+	close(c.closed)
+	c.wg.Wait()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.log.Close()
+}
+
+func (c *Coordinator) get(id string) (*transaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.transactions[id]
+	if !ok {
+		return nil, ErrTransactionNotFound{ID: id}
+	}
+	return t, nil
+}
+
+// end persists the decision of the transaction before writing its markers, so
+// a crash in between is completed on recovery. A decision whose markers failed
+// to be written may be retried
+func (c *Coordinator) end(id string, decision string) error {
+	t, err := c.get(id)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch t.State {
+	case stateOngoing:
+		if err = c.setState(t, decision); err != nil {
+			return err
+		}
+	case decision:
+	default:
+		return ErrTransactionEnding{ID: id, State: t.State}
+	}
+	return c.complete(t)
+}
+
+// complete writes the markers of a transaction that was decided and compacts
+// the state log once it mostly holds the states of ended transactions
+func (c *Coordinator) complete(t *transaction) error {
+	marker := api.RecordType_RECORD_TYPE_COMMIT
+	if t.State == stateAborting {
+		marker = api.RecordType_RECORD_TYPE_ABORT
+	}
+	for _, p := range t.Partitions {
+		if err := c.writeMarker(p, &api.Record{Type: marker, TransactionId: t.ID}); err != nil {
+			return err
+		}
+	}
+	if err := c.setState(t, stateComplete); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.transactions, t.ID)
+	if len(c.transactions) == 0 {
+		// nothing in the state log is needed anymore
+		return c.log.Reset()
+	}
+	states := c.log.NextOffset() - c.log.LowestOffset()
+	if states >= compactStates && states > 2*uint64(len(c.transactions)) {
+		return c.compact()
+	}
+	return nil
+}
+
+// writeMarker skips partitions where the transaction has no records without a
+// marker, so markers written before a crash or a failed attempt are not
+// written again on recovery or retry
+func (c *Coordinator) writeMarker(p Partition, marker *api.Record) error {
+	tp, err := c.topics.Get(p.Topic)
+	if errors.As(err, &topic.ErrTopicNotFound{}) {
+		// the records of the transaction were deleted with their topic
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	l, err := tp.Partition(p.Partition)
+	if err != nil {
+		return err
+	}
+	if !l.Ongoing(marker.TransactionId) {
+		return nil
+	}
+	_, err = l.Append(marker)
+	return err
+}
+
+func (c *Coordinator) setState(t *transaction, state string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev := t.State
+	t.State = state
+	if err := c.persist(t); err != nil {
+		t.State = prev
+		return err
+	}
+	return nil
+}
+
+// persist syncs the state of the transaction to disk before it is acted on.
+// It must be called with the lock held
+func (c *Coordinator) persist(t *transaction) error {
+	if err := appendState(c.log, t); err != nil {
+		return err
+	}
+	return c.log.Sync()
+}
+
+func appendState(l *log.Log, t *transaction) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	_, err = l.Append(&api.Record{Key: []byte(t.ID), Value: b})
+	return err
+}
+
+// compact replaces the state log with one holding only the live transactions,
+// in the order they began. The new log is written next to the current one and
+// renamed over it, so a crash leaves either of them. It must be called with the lock held
+func (c *Coordinator) compact() error {
+	compacted := c.Dir + compactedSuffix
+	if err := c.writeCompacted(compacted); err != nil {
+		return errors.Join(err, os.RemoveAll(compacted))
+	}
+	if err := c.log.Close(); err != nil {
+		return err
+	}
+	err := os.Rename(c.Dir, c.Dir+replacedSuffix)
+	if err == nil {
+		err = os.Rename(compacted, c.Dir)
+	}
+	// a failed rename is finished or rolled back like after a crash
+	l, openErr := openStateLog(c.Dir)
+	if openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	c.log = l
+	return err
+}
+
+func (c *Coordinator) writeCompacted(dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	l, err := log.NewLog(dir)
+	if err != nil {
+		return err
+	}
+	if err = c.rewrite(l); err == nil {
+		err = l.Sync()
+	}
+	return errors.Join(err, l.Close())
+}
+
+// rewrite appends the state of every live transaction to l, in the order
+// their first states are found in the state log
+func (c *Coordinator) rewrite(l *log.Log) error {
+	written := make(map[string]bool)
+	for off := c.log.LowestOffset(); off < c.log.NextOffset(); off++ {
+		rec, err := c.log.Read(off)
+		if err != nil {
+			return err
+		}
+		id := string(rec.Key)
+		t, ok := c.transactions[id]
+		if !ok || written[id] {
+			continue
+		}
+		written[id] = true
+		if err = appendState(l, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openStateLog opens the state log in dir, finishing the compaction a crash
+// interrupted once the compacted log was complete and discarding it otherwise
+func openStateLog(dir string) (*log.Log, error) {
+	compacted := dir + compactedSuffix
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		// the crash happened between the renames of compact
+		if err = os.Rename(compacted, dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if err := errors.Join(os.RemoveAll(compacted), os.RemoveAll(dir+replacedSuffix)); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return log.NewLog(dir)
+}
+
+// recover replays the state log and completes every transaction it left unfinished
+func (c *Coordinator) recover() error {
+	// transactions are completed in the order they began
+	var ids []string
+	for off := c.log.LowestOffset(); off < c.log.NextOffset(); off++ {
+		rec, err := c.log.Read(off)
+		if err != nil {
+			return err
+		}
+		t := &transaction{}
+		if err = json.Unmarshal(rec.Value, t); err != nil {
+			return fmt.Errorf("malformed transaction state at %d: %w", off, err)
+		}
+		if _, ok := c.transactions[t.ID]; !ok {
+			ids = append(ids, t.ID)
+		}
+		c.transactions[t.ID] = t
+	}
+	for _, id := range ids {
+		t := c.transactions[id]
+		if t.State == stateComplete {
+			delete(c.transactions, id)
+		}
+	}
+	for _, id := range ids {
+		t, ok := c.transactions[id]
+		if !ok {
+			continue
+		}
+		if t.State == stateOngoing {
+			if err := c.setState(t, stateAborting); err != nil {
+				return err
+			}
+		}
+		if err := c.complete(t); err != nil {
+			return err
+		}
+	}
+	if len(c.transactions) == 0 {
+		return c.log.Reset()
+	}
+	return nil
+}
+
+// expire aborts the transactions that went without records for longer than the timeout
+func (c *Coordinator) expire() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(*c.options.timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+		}
+		// open transactions are aborted, the markers of ended ones whose writes failed are retried
+		expired := make(map[string]string)
+		c.mu.Lock()
+		for id, t := range c.transactions {
+			if time.Since(t.lastWrite) <= *c.options.timeout {
+				continue
+			}
+			expired[id] = t.State
+			if t.State == stateOngoing {
+				expired[id] = stateAborting
+			}
+		}
+		c.mu.Unlock()
+		for id, decision := range expired {
+			// a transaction that ended meanwhile is not found anymore
+			_ = c.end(id, decision)
+		}
+	}
+}
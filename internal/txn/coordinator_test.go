@@ -0,0 +1,201 @@
+package txn
+
+import (
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/log"
+	"github.com/a-shakra/commit-log/internal/topic"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type CoordinatorTestSuite struct {
+	suite.Suite
+	testDir     string
+	topics      *topic.Manager
+	coordinator *Coordinator
+}
+
+func TestCoordinatorTestSuite(t *testing.T) {
+	suite.Run(t, &CoordinatorTestSuite{})
+}
+
+func (s *CoordinatorTestSuite) SetupTest() {
+	dir, err := os.MkdirTemp("", "txn-coordinator-test")
+	s.Require().NoError(err)
+	s.testDir = dir
+
+	s.topics, err = topic.NewManager(filepath.Join(dir, "topics"))
+	s.Require().NoError(err)
+	_, err = s.topics.Create("orders", 2, "")
+	s.Require().NoError(err)
+	s.coordinator, err = NewCoordinator(s.stateDir(), s.topics)
+	s.Require().NoError(err)
+}
+
+func (s *CoordinatorTestSuite) TearDownTest() {
+	s.Require().NoError(s.coordinator.Close())
+	s.Require().NoError(s.topics.Close())
+	s.Require().NoError(os.RemoveAll(s.testDir))
+}
+
+func (s *CoordinatorTestSuite) TestCommit() {
+	id, err := s.coordinator.Begin()
+	s.Require().NoError(err)
+	s.write(id, 0)
+	s.write(id, 1)
+	s.write(id, 1)
+	for p := uint32(0); p < 2; p++ {
+		s.Require().Equal(uint64(0), s.partition(p).LastStableOffset())
+	}
+
+	err = s.coordinator.Commit(id)
+	s.Require().NoError(err)
+	for p := uint32(0); p < 2; p++ {
+		l := s.partition(p)
+		s.Require().Equal(l.NextOffset(), l.LastStableOffset())
+		s.Require().False(l.Aborted(id))
+		marker, err := l.Read(l.NextOffset() - 1)
+		s.Require().NoError(err)
+		s.Require().Equal(api.RecordType_RECORD_TYPE_COMMIT, marker.Type)
+	}
+
+	// an ended transaction takes no more records
+	err = s.coordinator.Write(id, Partition{Topic: "orders"}, func() error { return nil })
+	s.Require().ErrorAs(err, &ErrTransactionNotFound{})
+	err = s.coordinator.Abort(id)
+	s.Require().ErrorAs(err, &ErrTransactionNotFound{})
+}
+
+func (s *CoordinatorTestSuite) TestAbort() {
+	id, err := s.coordinator.Begin()
+	s.Require().NoError(err)
+	s.write(id, 0)
+	err = s.coordinator.Abort(id)
+	s.Require().NoError(err)
+	s.Require().True(s.partition(0).Aborted(id))
+	// the other partition was not written to, so it got no marker
+	s.Require().Equal(uint64(0), s.partition(1).NextOffset())
+}
+
+func (s *CoordinatorTestSuite) TestRecoverAbortsOpenTransactions() {
+	id, err := s.coordinator.Begin()
+	s.Require().NoError(err)
+	s.write(id, 1)
+	err = s.coordinator.Close()
+	s.Require().NoError(err)
+
+	s.coordinator, err = NewCoordinator(s.stateDir(), s.topics)
+	s.Require().NoError(err)
+	s.Require().True(s.partition(1).Aborted(id))
+	err = s.coordinator.Commit(id)
+	s.Require().ErrorAs(err, &ErrTransactionNotFound{})
+}
+
+func (s *CoordinatorTestSuite) TestRecoverCompletesDecidedTransactions() {
+	id, err := s.coordinator.Begin()
+	s.Require().NoError(err)
+	s.write(id, 0)
+	// a crash after the decision was persisted leaves the markers unwritten
+	t, err := s.coordinator.get(id)
+	s.Require().NoError(err)
+	err = s.coordinator.setState(t, stateCommitting)
+	s.Require().NoError(err)
+	err = s.coordinator.Close()
+	s.Require().NoError(err)
+
+	s.coordinator, err = NewCoordinator(s.stateDir(), s.topics)
+	s.Require().NoError(err)
+	l := s.partition(0)
+	s.Require().Equal(l.NextOffset(), l.LastStableOffset())
+	s.Require().False(l.Aborted(id))
+}
+
+func (s *CoordinatorTestSuite) TestRecoverSkipsWrittenMarkers() {
+	id, err := s.coordinator.Begin()
+	s.Require().NoError(err)
+	s.write(id, 0)
+	s.write(id, 1)
+	// a crash after the marker of the first partition was written
+	t, err := s.coordinator.get(id)
+	s.Require().NoError(err)
+	err = s.coordinator.setState(t, stateCommitting)
+	s.Require().NoError(err)
+	_, err = s.partition(0).Append(&api.Record{Type: api.RecordType_RECORD_TYPE_COMMIT, TransactionId: id})
+	s.Require().NoError(err)
+	err = s.coordinator.Close()
+	s.Require().NoError(err)
+
+	s.coordinator, err = NewCoordinator(s.stateDir(), s.topics)
+	s.Require().NoError(err)
+	for p := uint32(0); p < 2; p++ {
+		l := s.partition(p)
+		s.Require().Equal(uint64(2), l.NextOffset())
+		s.Require().Equal(l.NextOffset(), l.LastStableOffset())
+	}
+}
+
+func (s *CoordinatorTestSuite) TestCompact() {
+	defer func(states uint64) { compactStates = states }(compactStates)
+	compactStates = 4
+
+	open, err := s.coordinator.Begin()
+	s.Require().NoError(err)
+	s.write(open, 1)
+	for i := 0; i < 5; i++ {
+		id, err := s.coordinator.Begin()
+		s.Require().NoError(err)
+		s.write(id, 0)
+		s.Require().NoError(s.coordinator.Commit(id))
+		// the state log holds the open transaction and the ones ended since it was compacted
+		l := s.coordinator.log
+		s.Require().Less(l.NextOffset()-l.LowestOffset(), compactStates+4)
+	}
+	err = s.coordinator.Close()
+	s.Require().NoError(err)
+
+	// a crash between the renames of the compaction leaves only the compacted log
+	err = os.Rename(s.stateDir(), s.stateDir()+compactedSuffix)
+	s.Require().NoError(err)
+	s.coordinator, err = NewCoordinator(s.stateDir(), s.topics)
+	s.Require().NoError(err)
+	s.Require().True(s.partition(1).Aborted(open))
+	s.Require().NoDirExists(s.stateDir() + compactedSuffix)
+}
+
+func (s *CoordinatorTestSuite) TestExpire() {
+	err := s.coordinator.Close()
+	s.Require().NoError(err)
+	s.coordinator, err = NewCoordinator(s.stateDir(), s.topics, WithTimeout(20*time.Millisecond))
+	s.Require().NoError(err)
+
+	id, err := s.coordinator.Begin()
+	s.Require().NoError(err)
+	s.write(id, 0)
+	s.Require().Eventually(func() bool {
+		return s.partition(0).Aborted(id)
+	}, time.Second, 10*time.Millisecond)
+}
+
+// write appends a record of the transaction to a partition of the orders topic
+func (s *CoordinatorTestSuite) write(id string, partition uint32) {
+	err := s.coordinator.Write(id, Partition{Topic: "orders", Partition: partition}, func() error {
+		_, err := s.partition(partition).Append(&api.Record{Value: []byte("order"), TransactionId: id})
+		return err
+	})
+	s.Require().NoError(err)
+}
+
+func (s *CoordinatorTestSuite) partition(p uint32) *log.Log {
+	t, err := s.topics.Get("orders")
+	s.Require().NoError(err)
+	l, err := t.Partition(p)
+	s.Require().NoError(err)
+	return l
+}
+
+func (s *CoordinatorTestSuite) stateDir() string {
+	return filepath.Join(s.testDir, "__transactions")
+}
@@ -0,0 +1,33 @@
+package txn
+
+import (
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type ErrTransactionNotFound struct {
+	ID string
+}
+
+func (e ErrTransactionNotFound) GRPCStatus() *status.Status {
+	return status.New(codes.NotFound, fmt.Sprintf("transaction does not exist or has ended: %q", e.ID))
+}
+
+func (e ErrTransactionNotFound) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrTransactionEnding is returned for calls on a transaction that is being committed or aborted
+type ErrTransactionEnding struct {
+	ID    string
+	State string
+}
+
+func (e ErrTransactionEnding) GRPCStatus() *status.Status {
+	return status.New(codes.FailedPrecondition, fmt.Sprintf("transaction %q is %s", e.ID, e.State))
+}
+
+func (e ErrTransactionEnding) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
@@ -0,0 +1,51 @@
+// Package client is a Go client for the Log service of api/v1. A Producer
+// batches records and sends them over ProduceStream, a Consumer reads a
+// partition over ConsumeStream. Both retry calls that failed with Unavailable
+// or ResourceExhausted, waiting longer after every failure.
+//
+// Retried records may be appended twice when the server appended them but the
+// response was lost, unless they carry a producer id and sequence.
+//
+// Connections to a cluster route writes to its leader when they are dialed
+// with the scheme of package loadbalance.
+//
+// Compatibility follows the rules documented in package wal
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math/rand"
+	"time"
+)
+
+// retryable reports whether a call that failed with err may succeed when retried
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// backoff waits before the given retry, attempts count from 1. It returns the
+// error of ctx when ctx is done first
+func (o options) backoff(ctx context.Context, attempt int) error {
+	d := *o.initialBackoff
+	for i := 1; i < attempt && d < *o.maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, *o.maxBackoff)
+	// jitter keeps clients that failed together from retrying together
+	d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/pkg/server"
+	"github.com/a-shakra/commit-log/pkg/wal"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type ClientTestSuite struct {
+	suite.Suite
+	log    *wal.Log
+	server *grpc.Server
+	ccon   *grpc.ClientConn
+	client *flakyClient
+}
+
+func TestClientTestSuite(t *testing.T) {
+	suite.Run(t, &ClientTestSuite{})
+}
+
+func (s *ClientTestSuite) SetupTest() {
+	var err error
+	s.log, err = wal.Open(s.T().TempDir())
+	s.Require().NoError(err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	s.server, err = server.NewGRPCServer(server.Config{Log: s.log})
+	s.Require().NoError(err)
+	go func() {
+		_ = s.server.Serve(listener)
+	}()
+
+	s.ccon, err = grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	s.Require().NoError(err)
+	s.client = &flakyClient{LogClient: api.NewLogClient(s.ccon)}
+}
+
+func (s *ClientTestSuite) TearDownTest() {
+	s.Require().NoError(s.ccon.Close())
+	s.server.Stop()
+	s.Require().NoError(s.log.Close())
+}
+
+// fastRetries keeps the tests from waiting on the default backoff
+func fastRetries() Options {
+	return WithBackoff(time.Millisecond, 5*time.Millisecond)
+}
+
+// flakyClient fails the streaming calls it is told to with Unavailable
+type flakyClient struct {
+	api.LogClient
+
+	mu sync.Mutex
+	// failures is how many of the next streaming calls fail right away
+	failures int
+	// breakAfter makes the next consume stream fail after that many records
+	breakAfter int
+	calls      int
+	consumes   []*api.ConsumeRequest
+}
+
+func (c *flakyClient) fail() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if c.failures > 0 {
+		c.failures--
+		return status.Error(codes.Unavailable, "flaky")
+	}
+	return nil
+}
+
+func (c *flakyClient) ProduceStream(ctx context.Context, opts ...grpc.CallOption) (
+	api.Log_ProduceStreamClient, error) {
+	if err := c.fail(); err != nil {
+		return nil, err
+	}
+	return c.LogClient.ProduceStream(ctx, opts...)
+}
+
+func (c *flakyClient) ConsumeStream(ctx context.Context, req *api.ConsumeRequest, opts ...grpc.CallOption) (
+	api.Log_ConsumeStreamClient, error) {
+	if err := c.fail(); err != nil {
+		return nil, err
+	}
+	stream, err := c.LogClient.ConsumeStream(ctx, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.consumes = append(c.consumes, proto.Clone(req).(*api.ConsumeRequest))
+	brokenStream := &brokenConsumeStream{Log_ConsumeStreamClient: stream, left: c.breakAfter}
+	c.breakAfter = 0
+	return brokenStream, nil
+}
+
+func (c *flakyClient) callCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+// brokenConsumeStream fails with Unavailable after left records, it never fails when left is 0
+type brokenConsumeStream struct {
+	api.Log_ConsumeStreamClient
+	left int
+}
+
+func (s *brokenConsumeStream) Recv() (*api.ConsumeResponse, error) {
+	if s.left < 0 {
+		return nil, status.Error(codes.Unavailable, "broken")
+	}
+	if s.left > 0 {
+		s.left--
+		if s.left == 0 {
+			s.left = -1
+		}
+	}
+	return s.Log_ConsumeStreamClient.Recv()
+}
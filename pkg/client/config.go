@@ -0,0 +1,96 @@
+package client
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	defaultLinger         = 5 * time.Millisecond
+	defaultMaxBatchSize   = 100
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
+	defaultMaxRetries     = 5
+)
+
+type options struct {
+	linger         *time.Duration
+	maxBatchSize   *int
+	initialBackoff *time.Duration
+	maxBackoff     *time.Duration
+	maxRetries     *int
+}
+
+// Options configure a Producer or a Consumer, the ones that do not apply to it are ignored
+type Options func(options *options) error
+
+func newOptions(opts []Options) (options, error) {
+	var cOpts options
+	for _, opt := range opts {
+		if err := opt(&cOpts); err != nil {
+			return cOpts, err
+		}
+	}
+	if cOpts.linger == nil {
+		cOpts.linger = &defaultLinger
+	}
+	if cOpts.maxBatchSize == nil {
+		cOpts.maxBatchSize = &defaultMaxBatchSize
+	}
+	if cOpts.initialBackoff == nil {
+		cOpts.initialBackoff = &defaultInitialBackoff
+	}
+	if cOpts.maxBackoff == nil {
+		cOpts.maxBackoff = &defaultMaxBackoff
+	}
+	if cOpts.maxRetries == nil {
+		cOpts.maxRetries = &defaultMaxRetries
+	}
+	return cOpts, nil
+}
+
+// WithLinger sets how long a Producer waits for more records before it sends a batch that is not full
+func WithLinger(d time.Duration) Options {
+	return func(options *options) error {
+		if d < 0 {
+			return errors.New("linger should not be negative")
+		}
+		options.linger = &d
+		return nil
+	}
+}
+
+// WithMaxBatchSize sets how many records a Producer sends at most in a batch
+func WithMaxBatchSize(n int) Options {
+	return func(options *options) error {
+		if n <= 0 {
+			return errors.New("max batch size should be positive")
+		}
+		options.maxBatchSize = &n
+		return nil
+	}
+}
+
+// WithBackoff sets the wait before the first retry, which doubles on every
+// following retry up to max
+func WithBackoff(initial, max time.Duration) Options {
+	return func(options *options) error {
+		if initial <= 0 || max < initial {
+			return errors.New("backoff should be positive and not exceed its max")
+		}
+		options.initialBackoff = &initial
+		options.maxBackoff = &max
+		return nil
+	}
+}
+
+// WithMaxRetries sets how many times in a row a call is retried before its error is returned
+func WithMaxRetries(n int) Options {
+	return func(options *options) error {
+		if n < 0 {
+			return errors.New("max retries should not be negative")
+		}
+		options.maxRetries = &n
+		return nil
+	}
+}
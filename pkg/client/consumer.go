@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+)
+
+// Consumer reads the records of a partition over ConsumeStream. When the
+// stream fails it is opened again from the offset after the last record
+// returned by Recv, so records are neither skipped nor returned twice
+type Consumer struct {
+	client  api.LogClient
+	options options
+	// req holds the offset the stream is opened at next, only run touches it
+	req *api.ConsumeRequest
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	records chan *api.Record
+	done    chan struct{}
+	// err is set before done is closed
+	err error
+}
+
+// NewConsumer returns a Consumer that reads the records req asks for. A
+// consumer group in req only picks where the first stream starts
+func NewConsumer(client api.LogClient, req *api.ConsumeRequest, opts ...Options) (*Consumer, error) {
+	cOpts, err := newOptions(opts)
+	if err != nil {
+		return nil, fmt.Errorf("error on consumer creation: %v", err)
+	}
+	c := &Consumer{
+		client:  client,
+		options: cOpts,
+		req:     proto.Clone(req).(*api.ConsumeRequest),
+		records: make(chan *api.Record),
+		done:    make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	go c.run()
+	return c, nil
+}
+
+// Recv returns the next record. Once the stream failed for good it returns the
+// error it failed with, ErrClosed after Close. It returns the error of ctx when
+// ctx is done first
+func (c *Consumer) Recv(ctx context.Context) (*api.Record, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case rec := <-c.records:
+		return rec, nil
+	case <-c.done:
+		return nil, c.err
+	}
+}
+
+// Close stops reading records
+func (c *Consumer) Close() error {
+	c.cancel()
+	<-c.done
+	return nil
+}
+
+func (c *Consumer) run() {
+	defer close(c.done)
+	for attempt := 0; ; {
+		delivered, err := c.consume()
+		if c.ctx.Err() != nil {
+			c.err = ErrClosed{}
+			return
+		}
+		if delivered {
+			attempt = 0
+		}
+		if !retryable(err) || attempt >= *c.options.maxRetries {
+			c.err = err
+			return
+		}
+		attempt++
+		if c.options.backoff(c.ctx, attempt) != nil {
+			c.err = ErrClosed{}
+			return
+		}
+	}
+}
+
+// consume passes the records of a single stream to Recv until the stream fails,
+// delivered reports whether Recv got any of them
+func (c *Consumer) consume() (delivered bool, err error) {
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+	stream, err := c.client.ConsumeStream(ctx, c.req)
+	if err != nil {
+		return false, err
+	}
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			err = status.Error(codes.Unavailable, "consume stream ended")
+		}
+		if err != nil {
+			return delivered, err
+		}
+		select {
+		case <-ctx.Done():
+			return delivered, ctx.Err()
+		case c.records <- res.Record:
+		}
+		delivered = true
+		// the group was resolved to an offset by the first stream, the next one resumes after the record
+		c.req.Offset = res.Record.Offset + 1
+		c.req.Group = ""
+	}
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+func (s *ClientTestSuite) TestConsumerReconnects() {
+	for i := 0; i < 5; i++ {
+		_, err := s.log.Append(&api.Record{Value: []byte("consumed")})
+		s.Require().NoError(err)
+	}
+	s.client.failures = 1
+	s.client.breakAfter = 2
+	c, err := NewConsumer(s.client, &api.ConsumeRequest{}, fastRetries())
+	s.Require().NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 5; i++ {
+		rec, err := c.Recv(ctx)
+		s.Require().NoError(err)
+		s.Require().Equal(uint64(i), rec.Offset)
+	}
+	s.Require().NoError(c.Close())
+	_, err = c.Recv(ctx)
+	s.Require().ErrorAs(err, &ErrClosed{})
+
+	// the stream broke after the second record and resumed at the third
+	s.client.mu.Lock()
+	defer s.client.mu.Unlock()
+	s.Require().Len(s.client.consumes, 2)
+	s.Require().Equal(uint64(0), s.client.consumes[0].Offset)
+	s.Require().Equal(uint64(2), s.client.consumes[1].Offset)
+}
+
+func (s *ClientTestSuite) TestConsumerFails() {
+	c, err := NewConsumer(s.client, &api.ConsumeRequest{Topic: "missing"}, fastRetries())
+	s.Require().NoError(err)
+	defer c.Close()
+	_, err = c.Recv(context.Background())
+	s.Require().Equal(codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,17 @@
+package client
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrClosed is returned for records sent to or read from a closed Producer or Consumer
+type ErrClosed struct{}
+
+func (e ErrClosed) GRPCStatus() *status.Status {
+	return status.New(codes.Canceled, "client is closed")
+}
+
+func (e ErrClosed) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
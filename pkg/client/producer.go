@@ -0,0 +1,242 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"sync"
+	"time"
+)
+
+// Future is the outcome of a record sent by a Producer
+type Future struct {
+	req    *api.ProduceRequest
+	queued time.Time
+
+	done chan struct{}
+	res  *api.ProduceResponse
+	err  error
+}
+
+func (f *Future) complete(res *api.ProduceResponse, err error) {
+	f.res, f.err = res, err
+	close(f.done)
+}
+
+// Done is closed once the record was appended or failed
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait returns the response to the record once it was appended, or the error
+// it failed with. It returns the error of ctx when ctx is done first
+func (f *Future) Wait(ctx context.Context) (*api.ProduceResponse, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-f.done:
+		return f.res, f.err
+	}
+}
+
+// Producer sends records asynchronously. Records are sent in the order they
+// were passed to Send, in batches of up to the max batch size over a single
+// ProduceStream call. A batch that is not full is sent once its first record
+// waited for the linger time
+type Producer struct {
+	client  api.LogClient
+	options options
+
+	// ctx is canceled when Close gives up on the records still pending
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	mu      sync.Mutex
+	queue   []*Future
+	closing bool
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+// NewProducer returns a Producer that sends records with client
+func NewProducer(client api.LogClient, opts ...Options) (*Producer, error) {
+	pOpts, err := newOptions(opts)
+	if err != nil {
+		return nil, fmt.Errorf("error on producer creation: %v", err)
+	}
+	p := &Producer{
+		client:  client,
+		options: pOpts,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	p.ctx, p.cancel = context.WithCancelCause(context.Background())
+	go p.run()
+	return p, nil
+}
+
+// Send queues the record of req and returns right away. The Future completes
+// with ErrClosed when the Producer is closing
+func (p *Producer) Send(req *api.ProduceRequest) *Future {
+	f := &Future{req: req, queued: time.Now(), done: make(chan struct{})}
+	p.mu.Lock()
+	if p.closing {
+		p.mu.Unlock()
+		f.complete(nil, ErrClosed{})
+		return f
+	}
+	p.queue = append(p.queue, f)
+	p.mu.Unlock()
+	p.signal()
+	return f
+}
+
+// Close stops taking records and sends the pending ones without waiting for
+// the linger time. When ctx is done first the records that were not appended
+// yet fail with the error of ctx, which Close returns
+func (p *Producer) Close(ctx context.Context) error {
+	p.mu.Lock()
+	p.closing = true
+	p.mu.Unlock()
+	p.signal()
+
+	select {
+	case <-p.done:
+		p.cancel(ErrClosed{})
+		return nil
+	case <-ctx.Done():
+		p.cancel(ctx.Err())
+		<-p.done
+		return ctx.Err()
+	}
+}
+
+func (p *Producer) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run sends one batch at a time, so retries keep the records in order
+func (p *Producer) run() {
+	defer close(p.done)
+	for {
+		batch, ok := p.next()
+		if !ok {
+			return
+		}
+		p.sendBatch(batch)
+	}
+}
+
+// next waits for a batch to be ready, ok is false once the Producer is closing
+// and nothing is left to send
+func (p *Producer) next() (batch []*Future, ok bool) {
+	for {
+		var timer *time.Timer
+		var wait <-chan time.Time
+		p.mu.Lock()
+		switch {
+		case len(p.queue) == 0 && p.closing:
+			p.mu.Unlock()
+			return nil, false
+		case len(p.queue) == 0:
+		case len(p.queue) >= *p.options.maxBatchSize || p.closing:
+			batch = p.take()
+		default:
+			linger := *p.options.linger - time.Since(p.queue[0].queued)
+			if linger <= 0 {
+				batch = p.take()
+				break
+			}
+			timer = time.NewTimer(linger)
+			wait = timer.C
+		}
+		p.mu.Unlock()
+		if batch != nil {
+			return batch, true
+		}
+		select {
+		case <-p.wake:
+		case <-wait:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// take must be called with the lock held
+func (p *Producer) take() []*Future {
+	n := min(len(p.queue), *p.options.maxBatchSize)
+	batch := p.queue[:n:n]
+	p.queue = p.queue[n:]
+	return batch
+}
+
+// sendBatch sends the batch until every record got a response or failed
+func (p *Producer) sendBatch(batch []*Future) {
+	for attempt := 0; len(batch) > 0; {
+		if p.ctx.Err() != nil {
+			err := context.Cause(p.ctx)
+			for _, f := range batch {
+				f.complete(nil, err)
+			}
+			return
+		}
+		n, err := p.produce(batch)
+		batch = batch[n:]
+		if n > 0 {
+			attempt = 0
+		}
+		switch {
+		case err == nil:
+		case retryable(err) && attempt < *p.options.maxRetries:
+			attempt++
+			// a canceled wait fails the batch on the next iteration
+			_ = p.options.backoff(p.ctx, attempt)
+		default:
+			// the server handles records in order, so the error is the one of the
+			// first record without a response and the ones after it were not handled
+			batch[0].complete(nil, err)
+			batch = batch[1:]
+			attempt = 0
+		}
+	}
+}
+
+// produce sends the batch over a ProduceStream call and returns how many of
+// its records got a response, err is set when that is not all of them
+func (p *Producer) produce(batch []*Future) (int, error) {
+	ctx, cancel := context.WithCancel(p.ctx)
+	defer cancel()
+	stream, err := p.client.ProduceStream(ctx)
+	if err != nil {
+		return 0, err
+	}
+	// requests are sent while responses are received so neither side blocks on a full window,
+	// errors of Send surface on Recv
+	go func() {
+		for _, f := range batch {
+			if err := stream.Send(f.req); err != nil {
+				return
+			}
+		}
+		_ = stream.CloseSend()
+	}()
+	for i, f := range batch {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			err = status.Error(codes.Unavailable, "produce stream ended before every record got a response")
+		}
+		if err != nil {
+			return i, err
+		}
+		f.complete(res, nil)
+	}
+	return len(batch), nil
+}
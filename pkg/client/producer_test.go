@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+func produce(value string) *api.ProduceRequest {
+	return &api.ProduceRequest{Record: &api.Record{Value: []byte(value)}}
+}
+
+func (s *ClientTestSuite) TestProducerBatches() {
+	p, err := NewProducer(s.client, WithLinger(20*time.Millisecond), WithMaxBatchSize(3))
+	s.Require().NoError(err)
+	var futures []*Future
+	for i := 0; i < 7; i++ {
+		futures = append(futures, p.Send(produce("batched")))
+	}
+	for i, f := range futures {
+		res, err := f.Wait(context.Background())
+		s.Require().NoError(err)
+		s.Require().Equal(uint64(i), res.Offset)
+	}
+	s.Require().Equal(3, s.client.callCount())
+	s.Require().NoError(p.Close(context.Background()))
+
+	f := p.Send(produce("closed"))
+	_, err = f.Wait(context.Background())
+	s.Require().ErrorAs(err, &ErrClosed{})
+}
+
+func (s *ClientTestSuite) TestProducerCloseFlushes() {
+	p, err := NewProducer(s.client, WithLinger(time.Hour))
+	s.Require().NoError(err)
+	f := p.Send(produce("pending"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.Require().NoError(p.Close(ctx))
+	select {
+	case <-f.Done():
+	default:
+		s.Fail("record was not sent on close")
+	}
+	rec, err := s.log.Read(0)
+	s.Require().NoError(err)
+	s.Require().Equal([]byte("pending"), rec.Value)
+}
+
+func (s *ClientTestSuite) TestProducerRetries() {
+	s.client.failures = 2
+	p, err := NewProducer(s.client, WithLinger(0), fastRetries())
+	s.Require().NoError(err)
+	defer p.Close(context.Background())
+	res, err := p.Send(produce("retried")).Wait(context.Background())
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(0), res.Offset)
+	s.Require().Equal(3, s.client.callCount())
+
+	s.client.failures = 3
+	p2, err := NewProducer(s.client, WithLinger(0), fastRetries(), WithMaxRetries(2))
+	s.Require().NoError(err)
+	defer p2.Close(context.Background())
+	_, err = p2.Send(produce("given up")).Wait(context.Background())
+	s.Require().Equal(codes.Unavailable, status.Code(err))
+}
+
+func (s *ClientTestSuite) TestProducerFailsSingleRecord() {
+	p, err := NewProducer(s.client, WithLinger(20*time.Millisecond))
+	s.Require().NoError(err)
+	first := p.Send(produce("first"))
+	missing := p.Send(&api.ProduceRequest{Topic: "missing", Record: &api.Record{Value: []byte("lost")}})
+	last := p.Send(produce("last"))
+	s.Require().NoError(p.Close(context.Background()))
+
+	res, err := first.Wait(context.Background())
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(0), res.Offset)
+	_, err = missing.Wait(context.Background())
+	s.Require().Equal(codes.NotFound, status.Code(err))
+	res, err = last.Wait(context.Background())
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(1), res.Offset)
+}
+
+func (s *ClientTestSuite) TestProducerCloseTimeout() {
+	s.client.failures = 1000
+	p, err := NewProducer(s.client, WithLinger(0), WithBackoff(time.Second, time.Second), WithMaxRetries(1000))
+	s.Require().NoError(err)
+	f := p.Send(produce("stuck"))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	s.Require().ErrorIs(p.Close(ctx), context.DeadlineExceeded)
+	_, err = f.Wait(context.Background())
+	s.Require().ErrorIs(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,88 @@
+package loadbalance
+
+import (
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync/atomic"
+)
+
+// readMethods are served by any server, every other method is sent to the leader
+var readMethods = map[string]bool{
+	"Consume":        true,
+	"ConsumeStream":  true,
+	"ConsumeSession": true,
+}
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(Name, &Picker{}, base.Config{}))
+}
+
+// guarantee *Picker meets PickerBuilder and Picker interfaces at compile time
+var _ base.PickerBuilder = &Picker{}
+var _ balancer.Picker = &Picker{}
+
+// Picker sends writes to the leader of the cluster and spreads reads over
+// the followers round robin, falling back to the leader when there are none.
+// Calls that fail because the cluster changed make the Resolver refresh
+type Picker struct {
+	leader    balancer.SubConn
+	followers []balancer.SubConn
+	current   uint64
+	resolver  *Resolver
+}
+
+func (p *Picker) Build(info base.PickerBuildInfo) balancer.Picker {
+	picker := &Picker{}
+	for sc, scInfo := range info.ReadySCs {
+		if r, ok := scInfo.Address.Attributes.Value(resolverKey{}).(*Resolver); ok {
+			picker.resolver = r
+		}
+		if isLeader(scInfo.Address) {
+			picker.leader = sc
+			continue
+		}
+		picker.followers = append(picker.followers, sc)
+	}
+	return picker
+}
+
+func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	var result balancer.PickResult
+	method := info.FullMethodName[strings.LastIndex(info.FullMethodName, "/")+1:]
+	if readMethods[method] && len(p.followers) > 0 {
+		result.SubConn = p.nextFollower()
+	} else if p.leader != nil {
+		result.SubConn = p.leader
+	}
+	if result.SubConn == nil {
+		return result, balancer.ErrNoSubConnAvailable
+	}
+	result.Done = p.done
+	return result, nil
+}
+
+func (p *Picker) nextFollower() balancer.SubConn {
+	cur := atomic.AddUint64(&p.current, 1)
+	return p.followers[cur%uint64(len(p.followers))]
+}
+
+// done refreshes the servers when a call failed because the picked server
+// went away or no longer leads the cluster
+func (p *Picker) done(info balancer.DoneInfo) {
+	if info.Err == nil || p.resolver == nil {
+		return
+	}
+	switch status.Code(info.Err) {
+	case codes.Unavailable, codes.FailedPrecondition:
+		go p.resolver.ResolveNow(resolver.ResolveNowOptions{})
+	}
+}
+
+func isLeader(addr resolver.Address) bool {
+	leader, _ := addr.Attributes.Value(isLeaderKey{}).(bool)
+	return leader
+}
@@ -0,0 +1,72 @@
+package loadbalance
+
+import (
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+	"testing"
+)
+
+type subConn struct {
+	balancer.SubConn
+	addr resolver.Address
+}
+
+type PickerTestSuite struct {
+	suite.Suite
+	subConns []*subConn
+}
+
+func TestPickerTestSuite(t *testing.T) {
+	suite.Run(t, &PickerTestSuite{})
+}
+
+func (s *PickerTestSuite) TestNoSubConnAvailable() {
+	picker := (&Picker{}).Build(base.PickerBuildInfo{})
+	for _, method := range []string{"/log.v1.Log/Produce", "/log.v1.Log/Consume"} {
+		_, err := picker.Pick(balancer.PickInfo{FullMethodName: method})
+		s.Require().Equal(balancer.ErrNoSubConnAvailable, err)
+	}
+}
+
+func (s *PickerTestSuite) TestProduceToLeader() {
+	picker := s.buildPicker(3)
+	for i := 0; i < 3; i++ {
+		res, err := picker.Pick(balancer.PickInfo{FullMethodName: "/log.v1.Log/Produce"})
+		s.Require().NoError(err)
+		s.Require().Equal(s.subConns[0], res.SubConn)
+	}
+}
+
+func (s *PickerTestSuite) TestConsumeFromFollowers() {
+	picker := s.buildPicker(3)
+	seen := make(map[balancer.SubConn]int)
+	for i := 0; i < 4; i++ {
+		res, err := picker.Pick(balancer.PickInfo{FullMethodName: "/log.v1.Log/ConsumeStream"})
+		s.Require().NoError(err)
+		seen[res.SubConn]++
+	}
+	s.Require().Equal(map[balancer.SubConn]int{s.subConns[1]: 2, s.subConns[2]: 2}, seen)
+}
+
+func (s *PickerTestSuite) TestConsumeFallsBackToLeader() {
+	picker := s.buildPicker(1)
+	res, err := picker.Pick(balancer.PickInfo{FullMethodName: "/log.v1.Log/Consume"})
+	s.Require().NoError(err)
+	s.Require().Equal(s.subConns[0], res.SubConn)
+}
+
+// buildPicker builds a picker over n servers, the first one leads the cluster
+func (s *PickerTestSuite) buildPicker(n int) balancer.Picker {
+	s.subConns = nil
+	info := base.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo)}
+	for i := 0; i < n; i++ {
+		addr := resolver.Address{Attributes: attributes.New(isLeaderKey{}, i == 0)}
+		sc := &subConn{addr: addr}
+		s.subConns = append(s.subConns, sc)
+		info.ReadySCs[sc] = base.SubConnInfo{Address: addr}
+	}
+	return (&Picker{}).Build(info)
+}
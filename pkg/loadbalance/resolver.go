@@ -0,0 +1,151 @@
+// Package loadbalance registers the "commitlog" resolver scheme and balancer
+// with gRPC. Clients that import it and dial "commitlog:///host:port" discover
+// the servers of the cluster from the server at host:port, send writes to the
+// leader and spread reads over the followers:
+//
+//	import _ "github.com/a-shakra/commit-log/pkg/loadbalance"
+//
+//	conn, err := grpc.Dial("commitlog:///localhost:8400", opts...)
+//
+// Compatibility follows the rules documented in package wal
+package loadbalance
+
+import (
+	"context"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"sync"
+	"time"
+)
+
+// Name is the resolver scheme and balancer name clients dial the cluster with,
+// e.g. grpc.Dial("commitlog:///host:port")
+const Name = "commitlog"
+
+// resolveTimeout bounds a single GetServers call
+var resolveTimeout = 5 * time.Second
+
+// refreshInterval is how often the servers are refreshed while no leader is known
+var refreshInterval = time.Second
+
+type isLeaderKey struct{}
+type resolverKey struct{}
+
+func init() {
+	resolver.Register(&Resolver{})
+}
+
+// guarantee *Resolver meets Builder and Resolver interfaces at compile time
+var _ resolver.Builder = &Resolver{}
+var _ resolver.Resolver = &Resolver{}
+
+// Resolver discovers the servers of a cluster by calling GetServers on the
+// server named by the dial target. Every address carries whether the server
+// leads the cluster so that the Picker can route writes to it. While none of
+// the servers leads the cluster the Resolver refreshes them periodically, as
+// writes wait for a leader without failing calls that would trigger a refresh
+type Resolver struct {
+	mu sync.Mutex
+
+	clientConn    resolver.ClientConn
+	resolverConn  *grpc.ClientConn
+	serviceConfig *serviceconfig.ParseResult
+	leaderKnown   bool
+
+	closed    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (r *Resolver) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (
+	resolver.Resolver, error) {
+	res := &Resolver{clientConn: cc, closed: make(chan struct{}), done: make(chan struct{})}
+	var dialOpts []grpc.DialOption
+	if opts.DialCreds != nil {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(opts.DialCreds))
+	} else {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	res.serviceConfig = cc.ParseServiceConfig(fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, Name))
+	var err error
+	res.resolverConn, err = grpc.Dial(target.Endpoint(), dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	res.ResolveNow(resolver.ResolveNowOptions{})
+	go res.refresh(refreshInterval)
+	return res, nil
+}
+
+func (r *Resolver) Scheme() string {
+	return Name
+}
+
+// ResolveNow refreshes the servers of the cluster and hands them to the client connection
+func (r *Resolver) ResolveNow(resolver.ResolveNowOptions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client := api.NewLogClient(r.resolverConn)
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	res, err := client.GetServers(ctx, &api.GetServersRequest{})
+	if err != nil {
+		r.leaderKnown = false
+		r.clientConn.ReportError(err)
+		return
+	}
+	var addrs []resolver.Address
+	r.leaderKnown = false
+	for _, server := range res.Servers {
+		if server.State == api.ServerState_SERVER_STATE_DEAD {
+			continue
+		}
+		r.leaderKnown = r.leaderKnown || server.IsLeader
+		addrs = append(addrs, resolver.Address{
+			Addr: server.RpcAddr,
+			Attributes: attributes.New(isLeaderKey{}, server.IsLeader).
+				WithValue(resolverKey{}, r),
+		})
+	}
+	if err = r.clientConn.UpdateState(resolver.State{
+		Addresses:     addrs,
+		ServiceConfig: r.serviceConfig,
+	}); err != nil {
+		r.clientConn.ReportError(err)
+	}
+}
+
+// refresh resolves the servers every interval until the Resolver is closed,
+// as long as the last resolution found no leader
+func (r *Resolver) refresh(interval time.Duration) {
+	defer close(r.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.closed:
+			return
+		}
+		r.mu.Lock()
+		known := r.leaderKnown
+		r.mu.Unlock()
+		if !known {
+			r.ResolveNow(resolver.ResolveNowOptions{})
+		}
+	}
+}
+
+// Close stops the periodic refresh and closes the connection used to call GetServers
+func (r *Resolver) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+		<-r.done
+		_ = r.resolverConn.Close()
+	})
+}
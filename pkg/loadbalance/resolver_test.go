@@ -0,0 +1,221 @@
+package loadbalance
+
+import (
+	"context"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/membership"
+	"github.com/a-shakra/commit-log/internal/server"
+	"github.com/a-shakra/commit-log/internal/topic"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type clusterServer struct {
+	dir        string
+	addr       string
+	leader     *atomic.Bool
+	topics     *topic.Manager
+	membership *membership.Membership
+	server     *grpc.Server
+}
+
+type ResolverTestSuite struct {
+	suite.Suite
+	servers []*clusterServer
+}
+
+func TestResolverTestSuite(t *testing.T) {
+	suite.Run(t, &ResolverTestSuite{})
+}
+
+func (s *ResolverTestSuite) SetupTest() {
+	s.servers = nil
+	var listeners []net.Listener
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		s.Require().NoError(err)
+		listeners = append(listeners, listener)
+		addrs = append(addrs, listener.Addr().String())
+	}
+	for i, listener := range listeners {
+		dir, err := os.MkdirTemp("", "loadbalance-test")
+		s.Require().NoError(err)
+		topics, err := topic.NewManager(dir)
+		s.Require().NoError(err)
+		leader := &atomic.Bool{}
+		leader.Store(i == 0)
+		m, err := membership.NewMembership(fmt.Sprintf("server-%d", i), addrs[i], addrs,
+			membership.WithTimeouts(20*time.Millisecond, 100*time.Millisecond, 200*time.Millisecond),
+			membership.WithLeaderFunc(leader.Load),
+			membership.WithDialOptions(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		)
+		s.Require().NoError(err)
+		gServer, err := server.NewGrpcServer(&server.Config{Topics: topics, Membership: m})
+		s.Require().NoError(err)
+		go func(listener net.Listener) {
+			_ = gServer.Serve(listener)
+		}(listener)
+		s.servers = append(s.servers, &clusterServer{
+			dir:        dir,
+			addr:       addrs[i],
+			leader:     leader,
+			topics:     topics,
+			membership: m,
+			server:     gServer,
+		})
+	}
+	// wait until the dialed server knows the whole cluster
+	s.Require().Eventually(func() bool {
+		return len(s.servers[1].membership.Members()) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func (s *ResolverTestSuite) TearDownTest() {
+	for _, srv := range s.servers {
+		srv.server.Stop()
+		s.Require().NoError(srv.membership.Close())
+		s.Require().NoError(srv.topics.Close())
+		s.Require().NoError(os.RemoveAll(srv.dir))
+	}
+}
+
+func (s *ResolverTestSuite) TestResolverListsServers() {
+	cc := &clientConn{}
+	target, err := url.Parse(fmt.Sprintf("%s:///%s", Name, s.servers[1].addr))
+	s.Require().NoError(err)
+	r, err := (&Resolver{}).Build(
+		resolver.Target{URL: *target},
+		cc,
+		resolver.BuildOptions{DialCreds: insecure.NewCredentials()},
+	)
+	s.Require().NoError(err)
+	defer r.Close()
+	// closing twice is harmless, the client connection may close it as well
+	defer r.Close()
+
+	state := cc.lastState()
+	s.Require().Equal(3, len(state.Addresses))
+	for i, addr := range state.Addresses {
+		s.Require().Equal(s.servers[i].addr, addr.Addr)
+		s.Require().Equal(i == 0, isLeader(addr))
+	}
+	s.Require().NotNil(state.ServiceConfig)
+}
+
+func (s *ResolverTestSuite) TestResolverRefreshesWithoutLeader() {
+	s.servers[0].leader.Store(false)
+	s.Require().Eventually(func() bool {
+		for _, member := range s.servers[1].membership.Members() {
+			if member.IsLeader {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cc := &clientConn{}
+	target, err := url.Parse(fmt.Sprintf("%s:///%s", Name, s.servers[1].addr))
+	s.Require().NoError(err)
+	r, err := (&Resolver{}).Build(
+		resolver.Target{URL: *target},
+		cc,
+		resolver.BuildOptions{DialCreds: insecure.NewCredentials()},
+	)
+	s.Require().NoError(err)
+	defer r.Close()
+	for _, addr := range cc.lastState().Addresses {
+		s.Require().False(isLeader(addr))
+	}
+
+	// the new leader is found without any call failing
+	s.servers[2].leader.Store(true)
+	s.Require().Eventually(func() bool {
+		for _, addr := range cc.lastState().Addresses {
+			if isLeader(addr) {
+				return addr.Addr == s.servers[2].addr
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func (s *ResolverTestSuite) TestClientRoutesByRole() {
+	// every server stores a record naming itself, so reads show which server served them
+	for i, srv := range s.servers {
+		t, err := srv.topics.Get(topic.DefaultTopic)
+		s.Require().NoError(err)
+		l, err := t.Partition(0)
+		s.Require().NoError(err)
+		_, err = l.Append(&api.Record{Value: []byte(fmt.Sprintf("server-%d", i))})
+		s.Require().NoError(err)
+	}
+
+	conn, err := grpc.Dial(
+		fmt.Sprintf("%s:///%s", Name, s.servers[1].addr),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	s.Require().NoError(err)
+	defer conn.Close()
+	client := api.NewLogClient(conn)
+	ctx := context.Background()
+
+	produce, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("test input")}})
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(1), produce.Offset)
+	t, err := s.servers[0].topics.Get(topic.DefaultTopic)
+	s.Require().NoError(err)
+	l, err := t.Partition(0)
+	s.Require().NoError(err)
+	s.Require().Equal(uint64(2), l.NextOffset())
+
+	// the picker is rebuilt as subconnections become ready, wait for both followers
+	served := make(map[string]bool)
+	s.Require().Eventually(func() bool {
+		res, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+		s.Require().NoError(err)
+		served[string(res.Record.Value)] = true
+		return served["server-1"] && served["server-2"]
+	}, 5*time.Second, 10*time.Millisecond)
+	for i := 0; i < 4; i++ {
+		res, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+		s.Require().NoError(err)
+		s.Require().NotEqual("server-0", string(res.Record.Value))
+	}
+}
+
+type clientConn struct {
+	resolver.ClientConn
+	mu    sync.Mutex
+	state resolver.State
+}
+
+func (c *clientConn) UpdateState(state resolver.State) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = state
+	return nil
+}
+
+func (c *clientConn) ReportError(error) {}
+
+func (c *clientConn) ParseServiceConfig(config string) *serviceconfig.ParseResult {
+	return &serviceconfig.ParseResult{}
+}
+
+func (c *clientConn) lastState() resolver.State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
@@ -0,0 +1,137 @@
+// Package server is the public API to serve a WAL over the grpc Log service of
+// api/v1 and its HTTP/JSON gateway, for programs that embed a WAL and want to
+// expose it. The WAL is served as the single partition of the default topic.
+//
+// Compatibility follows the rules documented in package wal: exported
+// identifiers are stable within a major version, internal/ is not
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/log"
+	"github.com/a-shakra/commit-log/internal/server"
+	"github.com/a-shakra/commit-log/pkg/wal"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+// WriteAheadLog is what the server needs of the log it serves, every wal.WAL meets it
+type WriteAheadLog interface {
+	Append(record *api.Record) (uint64, error)
+	// Read returns wal.ErrOffsetOutOfRange for offsets it holds no record at
+	Read(offset uint64) (*api.Record, error)
+	LowestOffset() uint64
+	NextOffset() uint64
+	Remove() error
+}
+
+// guarantee wal.WAL meets WriteAheadLog interface at compile time
+var _ WriteAheadLog = wal.WAL(nil)
+
+// serverLog returns the errors of the internal log, by which the server tells
+// reads past the end of the log that wait for appends
+type serverLog struct {
+	WriteAheadLog
+}
+
+func (l serverLog) Read(offset uint64) (*api.Record, error) {
+	rec, err := l.WriteAheadLog.Read(offset)
+	var outOfRange wal.ErrOffsetOutOfRange
+	if errors.As(err, &outOfRange) {
+		return nil, log.ErrOffsetOutOfRange{Offset: outOfRange.Offset}
+	}
+	return rec, err
+}
+
+// Config holds the log a server serves and how the server reports on itself
+type Config struct {
+	// Log is served as partition 0 of the default topic
+	Log WriteAheadLog
+	// Health reports the serving status of the server through grpc.health.v1, see NewHealth.
+	// When nil the server reports SERVING right away
+	Health *health.Server
+	// Logger receives the calls the server handled and their errors, slog.Default when nil
+	Logger *slog.Logger
+	// RequestLogLevel picks the level calls are logged at by their status code,
+	// DefaultRequestLogLevel when nil
+	RequestLogLevel func(code codes.Code) slog.Level
+	// HTTPAddr is the address the gateway built by NewHTTPServer listens on
+	HTTPAddr string
+	// MetricsAddr is the address the listener built by NewMetricsServer listens on
+	MetricsAddr string
+	// MaxEventStreams caps the Server-Sent Event streams the gateway serves at once, unlimited when 0
+	MaxEventStreams int
+	// MaxEventsPerStream ends a Server-Sent Event stream after that many records, unlimited when 0
+	MaxEventsPerStream uint64
+}
+
+func (c Config) internal() *server.Config {
+	var l server.WriteAheadLog
+	if c.Log != nil {
+		l = serverLog{c.Log}
+	}
+	return &server.Config{
+		Log:                l,
+		Health:             c.Health,
+		Logger:             c.Logger,
+		RequestLogLevel:    c.RequestLogLevel,
+		HTTPAddr:           c.HTTPAddr,
+		MetricsAddr:        c.MetricsAddr,
+		MaxEventStreams:    c.MaxEventStreams,
+		MaxEventsPerStream: c.MaxEventsPerStream,
+	}
+}
+
+// NewGRPCServer returns a grpc server that serves the Log service, health
+// checks and reflection. TLS and other settings are passed as opts
+func NewGRPCServer(config Config, opts ...grpc.ServerOption) (*grpc.Server, error) {
+	return server.NewGrpcServer(config.internal(), opts...)
+}
+
+// NewHTTPServer returns the HTTP/JSON gateway for the HTTPAddr of the config,
+// tlsConfig nil serves plain HTTP
+func NewHTTPServer(config Config, tlsConfig *tls.Config) (*http.Server, error) {
+	return server.NewHTTPServer(config.internal(), tlsConfig)
+}
+
+// NewMetricsServer returns an HTTP server for the MetricsAddr of the config
+// that serves the metrics of the server in the Prometheus text format at /metrics
+func NewMetricsServer(config Config) (*http.Server, error) {
+	return server.NewMetricsServer(config.internal())
+}
+
+// NewHealth returns a health server that reports NOT_SERVING until SetServing marks the server ready
+func NewHealth() *health.Server {
+	return server.NewHealth()
+}
+
+// SetServing reports whether the server and its Log service are serving
+func SetServing(h *health.Server, serving bool) {
+	server.SetServing(h, serving)
+}
+
+// Drain reports NOT_SERVING and stops the server once its pending calls
+// finished, calls still pending after a timeout are canceled
+func Drain(gServer *grpc.Server, h *health.Server) {
+	server.Drain(gServer, h)
+}
+
+// Serve serves calls on listener until ctx is done and then drains the server.
+// Started with the Health of NewHealth, the server rejects calls until
+// SetServing marks it ready, such as once the WAL recovered
+func Serve(ctx context.Context, gServer *grpc.Server, h *health.Server, listener net.Listener) error {
+	return server.Serve(ctx, gServer, h, listener)
+}
+
+// DefaultRequestLogLevel logs successful calls at debug level, calls the client
+// got wrong at info level and calls the server failed at error level
+func DefaultRequestLogLevel(code codes.Code) slog.Level {
+	return server.DefaultRequestLogLevel(code)
+}
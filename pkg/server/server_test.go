@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/pkg/wal"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+type ServerTestSuite struct {
+	suite.Suite
+	log    *wal.Log
+	server *grpc.Server
+	ccon   *grpc.ClientConn
+	client api.LogClient
+}
+
+func TestServerTestSuite(t *testing.T) {
+	suite.Run(t, &ServerTestSuite{})
+}
+
+func (s *ServerTestSuite) SetupTest() {
+	var err error
+	s.log, err = wal.Open(s.T().TempDir())
+	s.Require().NoError(err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	s.server, err = NewGRPCServer(Config{Log: s.log})
+	s.Require().NoError(err)
+	go func() {
+		_ = s.server.Serve(listener)
+	}()
+
+	s.ccon, err = grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	s.Require().NoError(err)
+	s.client = api.NewLogClient(s.ccon)
+}
+
+func (s *ServerTestSuite) TearDownTest() {
+	s.Require().NoError(s.ccon.Close())
+	s.server.Stop()
+	s.Require().NoError(s.log.Close())
+}
+
+func (s *ServerTestSuite) TestServeEmbeddedLog() {
+	ctx := context.Background()
+	off, err := s.log.Append(&api.Record{Value: []byte("embedded")})
+	s.Require().NoError(err)
+	produce, err := s.client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("produced")}})
+	s.Require().NoError(err)
+	s.Require().Equal(off+1, produce.Offset)
+
+	consume, err := s.client.Consume(ctx, &api.ConsumeRequest{Offset: off})
+	s.Require().NoError(err)
+	s.Require().Equal([]byte("embedded"), consume.Record.Value)
+	rec, err := s.log.Read(produce.Offset)
+	s.Require().NoError(err)
+	s.Require().Equal([]byte("produced"), rec.Value)
+
+	topics, err := s.client.ListTopics(ctx, &api.ListTopicsRequest{})
+	s.Require().NoError(err)
+	s.Require().Len(topics.Topics, 1)
+	_, err = s.client.Consume(ctx, &api.ConsumeRequest{Topic: "orders"})
+	s.Require().Equal(codes.NotFound, status.Code(err))
+	_, err = s.client.CreateTopic(ctx, &api.CreateTopicRequest{Name: "orders"})
+	s.Require().Equal(codes.Unimplemented, status.Code(err))
+}
+
+func (s *ServerTestSuite) TestStreamWaitsForAppends() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := s.client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	s.Require().NoError(err)
+
+	// the stream caught up with the empty log and waits instead of failing
+	_, err = s.log.Append(&api.Record{Value: []byte("embedded")})
+	s.Require().NoError(err)
+	res, err := stream.Recv()
+	s.Require().NoError(err)
+	s.Require().Equal([]byte("embedded"), res.Record.Value)
+}
+
+func (s *ServerTestSuite) TestServeUntilReady() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	h := NewHealth()
+	gServer, err := NewGRPCServer(Config{Log: s.log, Health: h})
+	s.Require().NoError(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, gServer, h, listener)
+	}()
+	ccon, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	s.Require().NoError(err)
+	defer ccon.Close()
+	client := api.NewLogClient(ccon)
+
+	req := &api.ProduceRequest{Record: &api.Record{Value: []byte("produced")}}
+	_, err = client.Produce(ctx, req)
+	s.Require().Equal(codes.Unavailable, status.Code(err))
+	SetServing(h, true)
+	_, err = client.Produce(ctx, req)
+	s.Require().NoError(err)
+
+	cancel()
+	select {
+	case err = <-served:
+		s.Require().NoError(err)
+	case <-time.After(5 * time.Second):
+		s.Fail("serve did not drain once its context was done")
+	}
+}
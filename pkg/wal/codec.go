@@ -0,0 +1,62 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec turns values of T into record values and back
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(b []byte) (T, error)
+}
+
+// JSONCodec encodes values with encoding/json
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Decode(b []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(b, &v)
+	return v, err
+}
+
+// GobCodec encodes values with encoding/gob. Every value is encoded on its own,
+// so it carries its type description
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Encode(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec[T]) Decode(b []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&v)
+	return v, err
+}
+
+// ProtoCodec encodes protobuf messages, T is a pointer to a generated message type
+type ProtoCodec[T proto.Message] struct{}
+
+func (ProtoCodec[T]) Encode(v T) ([]byte, error) {
+	return proto.Marshal(v)
+}
+
+func (ProtoCodec[T]) Decode(b []byte) (T, error) {
+	// a nil message still describes its type, so it can make an empty one
+	var zero T
+	m := zero.ProtoReflect().New().Interface()
+	if err := proto.Unmarshal(b, m); err != nil {
+		return zero, err
+	}
+	return m.(T), nil
+}
@@ -0,0 +1,57 @@
+package wal
+
+import (
+	"errors"
+	"github.com/a-shakra/commit-log/internal/log"
+	"google.golang.org/grpc/status"
+)
+
+// ErrOffsetOutOfRange is returned for reads of offsets the Log holds no record at
+type ErrOffsetOutOfRange struct {
+	Offset uint64
+}
+
+func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
+	return log.ErrOffsetOutOfRange{Offset: e.Offset}.GRPCStatus()
+}
+
+func (e ErrOffsetOutOfRange) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrOutOfOrderSequence is returned for appends of idempotent producers whose
+// sequence is neither the next one nor the one of a remembered record
+type ErrOutOfOrderSequence struct {
+	ProducerID string
+	Sequence   uint64
+	Expected   uint64
+}
+
+func (e ErrOutOfOrderSequence) GRPCStatus() *status.Status {
+	return log.ErrOutOfOrderSequence{
+		ProducerID: e.ProducerID,
+		Sequence:   e.Sequence,
+		Expected:   e.Expected,
+	}.GRPCStatus()
+}
+
+func (e ErrOutOfOrderSequence) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// convertErr returns the error of this package matching an error of the log
+func convertErr(err error) error {
+	var outOfRange log.ErrOffsetOutOfRange
+	if errors.As(err, &outOfRange) {
+		return ErrOffsetOutOfRange{Offset: outOfRange.Offset}
+	}
+	var outOfOrder log.ErrOutOfOrderSequence
+	if errors.As(err, &outOfOrder) {
+		return ErrOutOfOrderSequence{
+			ProducerID: outOfOrder.ProducerID,
+			Sequence:   outOfOrder.Sequence,
+			Expected:   outOfOrder.Expected,
+		}
+	}
+	return err
+}
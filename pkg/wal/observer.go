@@ -0,0 +1,93 @@
+package wal
+
+import (
+	"github.com/a-shakra/commit-log/internal/log"
+)
+
+// EventType identifies what happened inside a Log
+type EventType int
+
+const (
+	// EventSegmentRolled is emitted when a new active segment replaced a full one
+	EventSegmentRolled EventType = iota
+	// EventSegmentRemoved is emitted when a segment was deleted by a truncation
+	// or after it was offloaded to the object store
+	EventSegmentRemoved
+	// EventAppended is emitted for every record once it was stored
+	EventAppended
+	// EventCorruption is emitted when data that cannot be read was found, such as
+	// index entries of records that were not fully written before a crash
+	EventCorruption
+	// EventUnknown is emitted for events of the log this version does not know
+	EventUnknown
+)
+
+var eventTypes = map[log.EventType]EventType{
+	log.EventSegmentRolled:  EventSegmentRolled,
+	log.EventSegmentRemoved: EventSegmentRemoved,
+	log.EventAppended:       EventAppended,
+	log.EventCorruption:     EventCorruption,
+}
+
+func (t EventType) String() string {
+	switch t {
+	case EventSegmentRolled:
+		return "segment_rolled"
+	case EventSegmentRemoved:
+		return "segment_removed"
+	case EventAppended:
+		return "appended"
+	case EventCorruption:
+		return "corruption"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes what happened inside the Log stored in Dir
+type Event struct {
+	Type EventType
+	Dir  string
+	// BaseOffset is the base offset of the segment the event happened in
+	BaseOffset uint64
+	// Offset is the offset of the appended record, or of the corrupted record when it is known
+	Offset uint64
+	// Offloaded is set when the removed segment is kept in the object store
+	Offloaded bool
+	// Err describes a corruption
+	Err error
+}
+
+// Observer is notified of the events of a Log it was registered with through
+// WithObserver or WithAsyncObserver
+type Observer interface {
+	Observe(e Event)
+}
+
+// ObserverFunc lets a function be used as an Observer
+type ObserverFunc func(e Event)
+
+func (f ObserverFunc) Observe(e Event) {
+	f(e)
+}
+
+// observer hands the events of the log to o
+func observer(o Observer) log.Observer {
+	if o == nil {
+		return nil
+	}
+	return log.ObserverFunc(func(e log.Event) {
+		t, ok := eventTypes[e.Type]
+		if !ok {
+			t = EventUnknown
+		}
+		o.Observe(Event{
+			Type:       t,
+			Dir:        e.Dir,
+			BaseOffset: e.BaseOffset,
+			Offset:     e.Offset,
+			Offloaded:  e.Offloaded,
+			Err:        e.Err,
+		})
+	})
+}
@@ -0,0 +1,139 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	api "github.com/a-shakra/commit-log/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrDecode is returned when the value of the record at Offset cannot be decoded
+type ErrDecode struct {
+	Offset uint64
+	Err    error
+}
+
+func (e ErrDecode) GRPCStatus() *status.Status {
+	return status.New(codes.DataLoss, fmt.Sprintf("cannot decode record at offset %d: %v", e.Offset, e.Err))
+}
+
+func (e ErrDecode) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrDecode) Unwrap() error {
+	return e.Err
+}
+
+// TypedLog stores values of T in a WAL, encoded into the values of its records by a Codec
+type TypedLog[T any] struct {
+	wal   WAL
+	codec Codec[T]
+}
+
+// NewTypedLog returns a TypedLog over w. The TypedLog does not own w, which
+// is still closed by its caller
+func NewTypedLog[T any](w WAL, codec Codec[T]) *TypedLog[T] {
+	return &TypedLog[T]{wal: w, codec: codec}
+}
+
+// Append encodes v and stores it at the next offset of the WAL
+func (l *TypedLog[T]) Append(v T) (uint64, error) {
+	b, err := l.codec.Encode(v)
+	if err != nil {
+		return 0, fmt.Errorf("cannot encode value: %w", err)
+	}
+	return l.wal.Append(&api.Record{Value: b})
+}
+
+// Read returns the value stored at off, or ErrDecode when it cannot be decoded
+func (l *TypedLog[T]) Read(off uint64) (T, error) {
+	rec, err := l.wal.Read(off)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return l.decode(rec)
+}
+
+func (l *TypedLog[T]) decode(rec *api.Record) (T, error) {
+	v, err := l.codec.Decode(rec.Value)
+	if err != nil {
+		return v, ErrDecode{Offset: rec.Offset, Err: err}
+	}
+	return v, nil
+}
+
+// Iterator returns an Iterator over the values stored from offset from on, or
+// from the lowest offset when older records were removed
+func (l *TypedLog[T]) Iterator(from uint64) *Iterator[T] {
+	return &Iterator[T]{log: l, next: from}
+}
+
+// Iterator walks the values of a TypedLog in offset order, skipping control
+// records such as transaction markers:
+//
+//	it := l.Iterator(0)
+//	for it.Next() {
+//		use(it.Offset(), it.Value())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Next stops at the end of the WAL, a later call picks up records appended meanwhile
+type Iterator[T any] struct {
+	log    *TypedLog[T]
+	next   uint64
+	offset uint64
+	value  T
+	err    error
+}
+
+// Next advances to the next value and reports whether there is one. It stops
+// for good on the first error, which Err returns
+func (it *Iterator[T]) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	w := it.log.wal
+	it.next = max(it.next, w.LowestOffset())
+	for ; it.next < w.NextOffset(); it.next++ {
+		rec, err := w.Read(it.next)
+		if errors.As(err, &ErrOffsetOutOfRange{}) && it.next < w.LowestOffset() {
+			// the record was removed while iterating
+			it.next = w.LowestOffset() - 1
+			continue
+		}
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if rec.Type != api.RecordType_RECORD_TYPE_DATA {
+			continue
+		}
+		if it.value, it.err = it.log.decode(rec); it.err != nil {
+			return false
+		}
+		it.offset = it.next
+		it.next++
+		return true
+	}
+	return false
+}
+
+// Value returns the value Next advanced to
+func (it *Iterator[T]) Value() T {
+	return it.value
+}
+
+// Offset returns the offset of the value Next advanced to
+func (it *Iterator[T]) Offset() uint64 {
+	return it.offset
+}
+
+// Err returns the error that stopped the iteration, such as an ErrDecode
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
@@ -0,0 +1,103 @@
+package wal
+
+import (
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type order struct {
+	ID    string
+	Items int
+}
+
+type TypedLogTestSuite struct {
+	suite.Suite
+	log *Log
+}
+
+func TestTypedLogTestSuite(t *testing.T) {
+	suite.Run(t, &TypedLogTestSuite{})
+}
+
+func (s *TypedLogTestSuite) SetupTest() {
+	var err error
+	s.log, err = Open(s.T().TempDir(), WithSegmentParams(1024, 1024, 0))
+	s.Require().NoError(err)
+}
+
+func (s *TypedLogTestSuite) TearDownTest() {
+	s.Require().NoError(s.log.Close())
+}
+
+func (s *TypedLogTestSuite) TestCodecs() {
+	want := order{ID: "o-1", Items: 3}
+	for name, codec := range map[string]Codec[order]{
+		"json": JSONCodec[order]{},
+		"gob":  GobCodec[order]{},
+	} {
+		l := NewTypedLog[order](s.log, codec)
+		off, err := l.Append(want)
+		s.Require().NoError(err, name)
+		got, err := l.Read(off)
+		s.Require().NoError(err, name)
+		s.Require().Equal(want, got, name)
+	}
+
+	l := NewTypedLog[*api.Record](s.log, ProtoCodec[*api.Record]{})
+	off, err := l.Append(&api.Record{Key: []byte("key"), Value: []byte("nested")})
+	s.Require().NoError(err)
+	got, err := l.Read(off)
+	s.Require().NoError(err)
+	s.Require().Equal([]byte("key"), got.Key)
+	s.Require().Equal([]byte("nested"), got.Value)
+}
+
+func (s *TypedLogTestSuite) TestIterator() {
+	l := NewTypedLog[order](s.log, JSONCodec[order]{})
+	for i := 0; i < 3; i++ {
+		_, err := l.Append(order{Items: i})
+		s.Require().NoError(err)
+	}
+	// control records are skipped
+	_, err := s.log.Append(&api.Record{Type: api.RecordType_RECORD_TYPE_COMMIT, TransactionId: "txn"})
+	s.Require().NoError(err)
+
+	it := l.Iterator(1)
+	var items []int
+	var offsets []uint64
+	for it.Next() {
+		items = append(items, it.Value().Items)
+		offsets = append(offsets, it.Offset())
+	}
+	s.Require().NoError(it.Err())
+	s.Require().Equal([]int{1, 2}, items)
+	s.Require().Equal([]uint64{1, 2}, offsets)
+
+	// records appended after the end are picked up by the next call
+	_, err = l.Append(order{Items: 3})
+	s.Require().NoError(err)
+	s.Require().True(it.Next())
+	s.Require().Equal(3, it.Value().Items)
+	s.Require().False(it.Next())
+}
+
+func (s *TypedLogTestSuite) TestDecodeError() {
+	l := NewTypedLog[order](s.log, JSONCodec[order]{})
+	_, err := l.Append(order{ID: "o-1"})
+	s.Require().NoError(err)
+	bad, err := s.log.Append(&api.Record{Value: []byte("not json")})
+	s.Require().NoError(err)
+
+	_, err = l.Read(bad)
+	var decodeErr ErrDecode
+	s.Require().ErrorAs(err, &decodeErr)
+	s.Require().Equal(bad, decodeErr.Offset)
+
+	it := l.Iterator(0)
+	s.Require().True(it.Next())
+	s.Require().False(it.Next())
+	s.Require().ErrorAs(it.Err(), &decodeErr)
+	s.Require().Equal(bad, decodeErr.Offset)
+	s.Require().False(it.Next())
+}
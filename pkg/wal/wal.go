@@ -0,0 +1,178 @@
+// Package wal is the public API of the segmented write-ahead log, for programs
+// that embed it instead of running a server.
+//
+// Compatibility: the identifiers exported by the packages under pkg/ and the
+// messages of api/v1 follow semantic versioning, they are neither removed nor
+// changed incompatibly within a major version of the module. Methods may be
+// added to the types, but not to the WAL interface. Everything under internal/
+// is an implementation detail that may change in any release
+package wal
+
+import (
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/a-shakra/commit-log/internal/log"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// WAL is an append-only sequence of records addressed by their offset
+type WAL interface {
+	// Append stores the record at the next offset and returns it
+	Append(record *api.Record) (uint64, error)
+	// Read returns the record at the offset, or ErrOffsetOutOfRange
+	Read(offset uint64) (*api.Record, error)
+	// LowestOffset returns the offset of the oldest record that can be read
+	LowestOffset() uint64
+	// NextOffset returns the offset the next appended record is stored at
+	NextOffset() uint64
+	// Close releases the files of the WAL
+	Close() error
+	// Remove closes the WAL and deletes its records
+	Remove() error
+}
+
+// guarantee *Log meets WAL interface at compile time
+var _ WAL = &Log{}
+
+// Log is the WAL stored as segments of a store and an index file in a
+// directory. Besides the WAL methods it supports truncation and offloading
+// segments to an ObjectStore. Records of idempotent producers, the ones that
+// carry a producer id, are appended once however often they are retried
+type Log struct {
+	log *log.Log
+}
+
+// Options configure a Log when it is opened
+type Options func(options *options) error
+
+type options struct {
+	log []log.Options
+}
+
+// Open opens the Log stored in dir, recovering it from its files when it exists
+func Open(dir string, opts ...Options) (*Log, error) {
+	var wOpts options
+	for _, opt := range opts {
+		if err := opt(&wOpts); err != nil {
+			return nil, err
+		}
+	}
+	l, err := log.NewLog(dir, wOpts.log...)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{log: l}, nil
+}
+
+// Append stores the record at the next offset and returns it. A retried record
+// of an idempotent producer returns the offset it was stored at first
+func (l *Log) Append(record *api.Record) (uint64, error) {
+	off, err := l.log.Append(record)
+	return off, convertErr(err)
+}
+
+// Read returns the record at the offset, or ErrOffsetOutOfRange
+func (l *Log) Read(offset uint64) (*api.Record, error) {
+	rec, err := l.log.Read(offset)
+	return rec, convertErr(err)
+}
+
+// LowestOffset returns the offset of the oldest record that can be read
+func (l *Log) LowestOffset() uint64 {
+	return l.log.LowestOffset()
+}
+
+// NextOffset returns the offset the next appended record is stored at
+func (l *Log) NextOffset() uint64 {
+	return l.log.NextOffset()
+}
+
+// TruncateAfter removes every record after the offset, the next appended
+// record is stored at offset+1
+func (l *Log) TruncateAfter(offset uint64) error {
+	return convertErr(l.log.TruncateAfter(offset))
+}
+
+// Offload uploads the segments that are due for tiered storage right away
+// instead of waiting for the background offload, see WithTieredStorage
+func (l *Log) Offload() error {
+	return l.log.Offload()
+}
+
+// Close releases the files of the Log
+func (l *Log) Close() error {
+	return l.log.Close()
+}
+
+// Remove closes the Log and deletes its records
+func (l *Log) Remove() error {
+	return l.log.Remove()
+}
+
+// with adds an option of the underlying log
+func with(opt log.Options) Options {
+	return func(options *options) error {
+		options.log = append(options.log, opt)
+		return nil
+	}
+}
+
+// WithSegmentParams sets the maximum sizes in bytes of the index and store
+// files of a segment and the offset of the first record of an empty Log
+func WithSegmentParams(indexSize uint64, storeSize uint64, initialOffset uint64) Options {
+	return with(log.WithSegmentParams(indexSize, storeSize, initialOffset))
+}
+
+// WithTieredStorage offloads sealed segments that have not been written to for
+// at least threshold into store, under keys prefixed with keyPrefix. Offloaded
+// segments are fetched into a local cache of at most cacheSegments segments.
+// Segments are offloaded in the background while the Log is open
+func WithTieredStorage(store ObjectStore, keyPrefix string, threshold time.Duration, cacheSegments int) Options {
+	return with(log.WithTieredStorage(store, keyPrefix, threshold, cacheSegments))
+}
+
+// WithProducerWindow sets how many of its latest records are remembered per idempotent producer
+func WithProducerWindow(n int) Options {
+	return with(log.WithProducerWindow(n))
+}
+
+// WithLogger logs segment lifecycle and recovery actions to logger instead of slog.Default
+func WithLogger(logger *slog.Logger) Options {
+	return with(log.WithLogger(logger))
+}
+
+// WithObserver calls o synchronously for every event of the Log, see Observer
+func WithObserver(o Observer) Options {
+	return with(log.WithObserver(observer(o)))
+}
+
+// WithAsyncObserver calls o for every event of the Log from a goroutine of its own
+func WithAsyncObserver(o Observer) Options {
+	return with(log.WithAsyncObserver(observer(o)))
+}
+
+// ObjectStore holds offloaded segments, such as a bucket of a cloud object
+// storage. Keys are flat names without any directory component, Get returns
+// ErrObjectNotFound for keys it holds no object at
+type ObjectStore interface {
+	Put(key string, r io.Reader) error
+	Get(key string) (io.ReadCloser, error)
+	List(prefix string) ([]string, error)
+	Delete(key string) error
+}
+
+// guarantee ObjectStore meets the ObjectStore of the log at compile time
+var _ log.ObjectStore = ObjectStore(nil)
+
+// NewDirObjectStore returns an ObjectStore that keeps objects as files in dir
+func NewDirObjectStore(dir string) (ObjectStore, error) {
+	store, err := log.NewDirObjectStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// ErrObjectNotFound is returned by an ObjectStore for keys it holds no object at
+var ErrObjectNotFound = log.ErrObjectNotFound
@@ -0,0 +1,86 @@
+package wal
+
+import (
+	api "github.com/a-shakra/commit-log/api/v1"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type WALTestSuite struct {
+	suite.Suite
+	log *Log
+}
+
+func TestWALTestSuite(t *testing.T) {
+	suite.Run(t, &WALTestSuite{})
+}
+
+func (s *WALTestSuite) SetupTest() {
+	var err error
+	s.log, err = Open(s.T().TempDir(), WithSegmentParams(1024, 1024, 0))
+	s.Require().NoError(err)
+}
+
+func (s *WALTestSuite) TearDownTest() {
+	s.Require().NoError(s.log.Close())
+}
+
+func (s *WALTestSuite) TestAppendRead() {
+	var events []EventType
+	dir := s.T().TempDir()
+	l, err := Open(dir, WithObserver(ObserverFunc(func(e Event) {
+		events = append(events, e.Type)
+	})))
+	s.Require().NoError(err)
+	var w WAL = l
+	defer w.Close()
+
+	off, err := w.Append(&api.Record{Value: []byte("embedded")})
+	s.Require().NoError(err)
+	rec, err := w.Read(off)
+	s.Require().NoError(err)
+	s.Require().Equal([]byte("embedded"), rec.Value)
+	s.Require().Equal([]EventType{EventAppended}, events)
+
+	_, err = w.Read(off + 1)
+	s.Require().ErrorAs(err, &ErrOffsetOutOfRange{})
+}
+
+func (s *WALTestSuite) TestIdempotentAppend() {
+	record := &api.Record{Value: []byte("embedded"), ProducerId: "producer", Sequence: 1}
+	first, err := s.log.Append(record)
+	s.Require().NoError(err)
+	retry, err := s.log.Append(record)
+	s.Require().NoError(err)
+	s.Require().Equal(first, retry)
+
+	record.Sequence = 3
+	_, err = s.log.Append(record)
+	s.Require().ErrorAs(err, &ErrOutOfOrderSequence{})
+}
+
+func (s *WALTestSuite) TestTruncateAndOffload() {
+	store, err := NewDirObjectStore(s.T().TempDir())
+	s.Require().NoError(err)
+	l, err := Open(s.T().TempDir(), WithSegmentParams(1024, 32, 0), WithTieredStorage(store, "orders-", 0, 1))
+	s.Require().NoError(err)
+	defer l.Close()
+	for i := 0; i < 4; i++ {
+		_, err = l.Append(&api.Record{Value: []byte("embedded")})
+		s.Require().NoError(err)
+	}
+
+	s.Require().NoError(l.TruncateAfter(2))
+	s.Require().Equal(uint64(3), l.NextOffset())
+	s.Require().NoError(l.Offload())
+	keys, err := store.List("orders-")
+	s.Require().NoError(err)
+	s.Require().NotEmpty(keys)
+	// offloaded records are still read through the cache
+	rec, err := l.Read(0)
+	s.Require().NoError(err)
+	s.Require().Equal([]byte("embedded"), rec.Value)
+
+	_, err = Open(s.T().TempDir(), WithProducerWindow(0))
+	s.Require().Error(err)
+}